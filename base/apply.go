@@ -0,0 +1,407 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	constvar "my2sql/constvar"
+	toolkits "my2sql/toolkits"
+	"github.com/siddontang/go-log/log"
+)
+
+var (
+	GOptsValidApplyOnError []string = []string{"stop", "skip"}
+)
+
+// checkpoint of applying sql files, sqls before Offset of SqlFile and all sqls of files before SqlFile are applied
+type ApplyCheckpoint struct {
+	SqlFile  string `json:"sql_file"`
+	Offset   int64  `json:"offset"`
+	Finished bool   `json:"finished"`
+}
+
+type SqlApplier struct {
+	cfg        *ConfCmd
+	ctx        context.Context
+	db         *sql.DB
+	conn       *sql.Conn
+	failedFH   *os.File
+	checkpoint ApplyCheckpoint
+
+	batch      []string
+	appliedCnt int
+	failedCnt  int
+}
+
+func ReadApplyCheckpoint(cpFile string) (*ApplyCheckpoint, error) {
+	if !toolkits.IsFile(cpFile) {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(cpFile)
+	if err != nil {
+		return nil, err
+	}
+	cp := &ApplyCheckpoint{}
+	err = json.Unmarshal(content, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (this *SqlApplier) SaveCheckpoint() {
+	if this.cfg.ApplyDryRun {
+		return
+	}
+	content, err := json.MarshalIndent(this.checkpoint, "", constvar.JSON_INDENT_TAB)
+	if err != nil {
+		log.Fatalf("fail to marshal apply checkpoint %v", err)
+	}
+	tmpFile := this.cfg.ApplyCheckpointFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, this.cfg.ApplyCheckpointFile)
+	}
+	if err != nil {
+		log.Fatalf("fail to write apply checkpoint file %s: %v", this.cfg.ApplyCheckpointFile, err)
+	}
+}
+
+// ApplySqlFilesToTarget executes the generated forward sql files in the order of binlog, or the rollback sql files
+// in the reversed order of binlog, against the mysql of -apply-to
+func ApplySqlFilesToTarget(cfg *ConfCmd) {
+	var (
+		err       error
+		sqlFiles  []string
+		skipFiles bool = false
+	)
+	if cfg.WorkType == "rollback" {
+		for i := len(cfg.OutputSqlFiles) - 1; i >= 0; i-- {
+			sqlFiles = append(sqlFiles, cfg.OutputSqlFiles[i])
+		}
+	} else {
+		sqlFiles = cfg.OutputSqlFiles
+	}
+	if len(sqlFiles) == 0 {
+		log.Info("no sql to apply")
+		return
+	}
+
+	applier := &SqlApplier{cfg: cfg, ctx: context.Background()}
+	cp, err := ReadApplyCheckpoint(cfg.ApplyCheckpointFile)
+	if err != nil {
+		log.Fatalf("fail to read apply checkpoint file %s: %v", cfg.ApplyCheckpointFile, err)
+	}
+	if cp != nil && cp.SqlFile != "" {
+		if !toolkits.ContainsString(sqlFiles, cp.SqlFile) {
+			log.Fatalf("%s in apply checkpoint file %s is not generated this time, cannot resume", cp.SqlFile, cfg.ApplyCheckpointFile)
+		}
+		log.Infof("resume applying sqls from %s offset %d", cp.SqlFile, cp.Offset)
+		applier.checkpoint = *cp
+		skipFiles = true
+	}
+
+	if !cfg.ApplyDryRun {
+		applier.db, err = CreateMysqlCon(cfg.ApplyToDsn)
+		if err != nil {
+			log.Fatalf("fail to connect to -apply-to mysql %v", err)
+		}
+		defer applier.db.Close()
+		// SET GTID_NEXT and the transaction after it must be in the same session
+		applier.conn, err = applier.db.Conn(applier.ctx)
+		if err != nil {
+			log.Fatalf("fail to connect to -apply-to mysql %v", err)
+		}
+		defer applier.conn.Close()
+	}
+	if cfg.ApplyOnError == "skip" {
+		failedFile := GetApplyFailedFileName(cfg)
+		applier.failedFH, err = os.OpenFile(failedFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("fail to open file %s: %v", failedFile, err)
+		}
+		defer applier.failedFH.Close()
+	}
+
+	for _, sqlFile := range sqlFiles {
+		var offset int64 = 0
+		if skipFiles {
+			if sqlFile != applier.checkpoint.SqlFile {
+				log.Infof("skip %s, it is applied according to checkpoint", sqlFile)
+				continue
+			}
+			skipFiles = false
+			offset = applier.checkpoint.Offset
+		}
+		err = applier.ApplyOneSqlFile(sqlFile, offset)
+		if err != nil {
+			log.Fatalf("fail to apply %s: %v", sqlFile, err)
+		}
+	}
+	applier.checkpoint.Finished = true
+	applier.SaveCheckpoint()
+	log.Infof("finish applying sqls to target mysql, %d sqls applied, %d sqls failed", applier.appliedCnt, applier.failedCnt)
+}
+
+func GetApplyFailedFileName(cfg *ConfCmd) string {
+	return filepath.Join(cfg.OutputDir, "apply_failed.sql")
+}
+
+func (this *SqlApplier) ApplyOneSqlFile(sqlFile string, offset int64) error {
+	var (
+		oneSql   string
+		startPos int64
+		inTrx    bool = false
+	)
+	log.Infof("start to apply %s", sqlFile)
+	FH, err := os.Open(sqlFile)
+	if err != nil {
+		return err
+	}
+	defer FH.Close()
+	if offset > 0 {
+		if _, err = FH.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	this.checkpoint.SqlFile = sqlFile
+	this.checkpoint.Offset = offset
+	sqlReader := NewSqlFileReader(FH, offset)
+	for {
+		oneSql, startPos, err = sqlReader.ReadSql()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case strings.EqualFold(oneSql, "begin"):
+			this.ExecBatch(sqlFile, startPos)
+			inTrx = true
+		case strings.EqualFold(oneSql, "commit"):
+			this.ExecBatch(sqlFile, sqlReader.Pos)
+			inTrx = false
+		case strings.HasPrefix(strings.ToUpper(oneSql), "SET GTID_NEXT"):
+			this.ExecBatch(sqlFile, startPos)
+			this.ExecSessionSql(sqlFile, oneSql)
+		default:
+			this.batch = append(this.batch, oneSql)
+			if !inTrx && len(this.batch) >= this.cfg.ApplyBatchSize {
+				this.ExecBatch(sqlFile, sqlReader.Pos)
+			}
+		}
+	}
+	this.ExecBatch(sqlFile, sqlReader.Pos)
+	log.Infof("finish applying %s", sqlFile)
+	return nil
+}
+
+// SqlFileReader reads the generated sqls one by one. a sql ends with ';' out of quotes and comments, it may span
+// multiple lines, ex: statement based dml and ddl. the comment lines between sqls are skipped
+type SqlFileReader struct {
+	r   *bufio.Reader
+	Pos int64 // offset in file of the end of the last sql read
+}
+
+func NewSqlFileReader(r io.Reader, offset int64) *SqlFileReader {
+	return &SqlFileReader{r: bufio.NewReader(r), Pos: offset}
+}
+
+func (this *SqlFileReader) readByte() (byte, error) {
+	c, err := this.r.ReadByte()
+	if err == nil {
+		this.Pos++
+	}
+	return c, err
+}
+
+func (this *SqlFileReader) unreadByte() {
+	this.r.UnreadByte()
+	this.Pos--
+}
+
+// skipLine skips the rest of the line including '\n', and appends it to buf if buf is not nil
+func (this *SqlFileReader) skipLine(buf *bytes.Buffer) error {
+	for {
+		c, err := this.readByte()
+		if err != nil {
+			return err
+		}
+		if buf != nil {
+			buf.WriteByte(c)
+		}
+		if c == '\n' {
+			return nil
+		}
+	}
+}
+
+// ReadSql returns the next sql without ';' and the offset in file where it starts, io.EOF if no sql is left.
+// the last sql without ';' at the end of file is returned too
+func (this *SqlFileReader) ReadSql() (string, int64, error) {
+	var (
+		buf      bytes.Buffer
+		startPos int64 = -1
+		quote    byte  = 0
+	)
+	for {
+		c, err := this.readByte()
+		if err == io.EOF {
+			if oneSql := strings.TrimSpace(buf.String()); oneSql != "" {
+				return oneSql, startPos, nil
+			}
+			return "", this.Pos, io.EOF
+		} else if err != nil {
+			return "", this.Pos, err
+		}
+		if startPos < 0 {
+			// between sqls
+			switch c {
+			case ' ', '\t', '\r', '\n', ';':
+				continue
+			case '#':
+				if err = this.skipLine(nil); err != nil && err != io.EOF {
+					return "", this.Pos, err
+				}
+				continue
+			}
+			startPos = this.Pos - 1
+		}
+		buf.WriteByte(c)
+		if quote != 0 {
+			if c == '\\' && quote != '`' {
+				if c, err = this.readByte(); err == nil {
+					buf.WriteByte(c)
+				}
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '#':
+			err = this.skipLine(&buf)
+		case '-':
+			// "-- " starts a comment, "--1" does not
+			if c, err = this.readByte(); err == nil && c != '-' {
+				this.unreadByte()
+			} else if err == nil {
+				buf.WriteByte(c)
+				if c, err = this.readByte(); err == nil {
+					buf.WriteByte(c)
+					if c == ' ' || c == '\t' || c == '\r' {
+						err = this.skipLine(&buf)
+					} else if c != '\n' {
+						buf.Truncate(buf.Len() - 1)
+						this.unreadByte()
+					}
+				}
+			}
+		case '/':
+			if c, err = this.readByte(); err == nil {
+				if c != '*' {
+					this.unreadByte()
+					break
+				}
+				buf.WriteByte(c)
+				// skip to the end of comment
+				var last byte
+				for c, err = this.readByte(); err == nil; c, err = this.readByte() {
+					buf.WriteByte(c)
+					if last == '*' && c == '/' {
+						break
+					}
+					last = c
+				}
+			}
+		case ';':
+			buf.Truncate(buf.Len() - 1)
+			// the rest of the line is the terminator too if it is blank
+			for c, err = this.readByte(); err == nil && (c == ' ' || c == '\t' || c == '\r'); c, err = this.readByte() {
+			}
+			if err == nil && c != '\n' {
+				this.unreadByte()
+			}
+			if err != nil && err != io.EOF {
+				return "", this.Pos, err
+			}
+			return strings.TrimSpace(buf.String()), startPos, nil
+		}
+		if err != nil && err != io.EOF {
+			return "", this.Pos, err
+		}
+	}
+}
+
+// ExecSessionSql executes sql out of transaction, ex: SET GTID_NEXT
+func (this *SqlApplier) ExecSessionSql(sqlFile string, oneSql string) {
+	if this.cfg.ApplyDryRun {
+		fmt.Printf("%s;\n", oneSql)
+		return
+	}
+	_, err := this.conn.ExecContext(this.ctx, oneSql)
+	if err != nil {
+		this.HandleApplyError(sqlFile, oneSql, err)
+	}
+}
+
+// ExecBatch executes sqls of the batch in one transaction, then records offset in checkpoint
+func (this *SqlApplier) ExecBatch(sqlFile string, offset int64) {
+	if len(this.batch) == 0 {
+		this.checkpoint.Offset = offset
+		return
+	}
+	if this.cfg.ApplyDryRun {
+		fmt.Printf("begin;\n%s;\ncommit;\n", strings.Join(this.batch, ";\n"))
+		this.appliedCnt += len(this.batch)
+		this.batch = this.batch[:0]
+		return
+	}
+
+	var okCnt int = 0
+	tx, err := this.conn.BeginTx(this.ctx, nil)
+	if err != nil {
+		log.Fatalf("fail to begin transaction on -apply-to mysql: %v", err)
+	}
+	for _, oneSql := range this.batch {
+		_, err = tx.ExecContext(this.ctx, oneSql)
+		if err != nil {
+			if this.cfg.ApplyOnError == "stop" {
+				tx.Rollback()
+			}
+			this.HandleApplyError(sqlFile, oneSql, err)
+			continue
+		}
+		okCnt++
+	}
+	err = tx.Commit()
+	if err != nil {
+		this.HandleApplyError(sqlFile, strings.Join(this.batch, ";\n"), err)
+	} else {
+		this.appliedCnt += okCnt
+	}
+	this.batch = this.batch[:0]
+	this.checkpoint.Offset = offset
+	this.SaveCheckpoint()
+}
+
+func (this *SqlApplier) HandleApplyError(sqlFile string, oneSql string, err error) {
+	if this.cfg.ApplyOnError == "stop" {
+		log.Fatalf("fail to apply sql of %s: %s\nerror: %v\nsqls before it are applied, rerun with the same options to resume from checkpoint %s",
+			sqlFile, oneSql, err, this.cfg.ApplyCheckpointFile)
+	}
+	this.failedCnt++
+	log.Errorf("fail to apply sql of %s, skip it: %s\nerror: %v", sqlFile, oneSql, err)
+	this.failedFH.WriteString(fmt.Sprintf("# file=%s error=%s\n%s;\n", sqlFile, strings.Replace(err.Error(), "\n", " ", -1), oneSql))
+}
@@ -0,0 +1,108 @@
+package base
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sql file as generated with -keep-trx -add-gtid-next, a statement based dml spans multiple lines
+var testApplySqlFile = "# datetime=2020-07-16_10:20:00 database=db1 table=t1 binlog=mysql-bin.000001 startpos=100 stoppos=200\n" +
+	"SET GTID_NEXT='3e11fa47-71ca-11e1-9e33-c80aa9429562:1';\n" +
+	"begin;\n" +
+	"INSERT INTO `db1`.`t1` (`id`,`name`) VALUES (1,'a;\\nb');\n" +
+	"UPDATE `db1`.`t1` SET `name`='it''s;' WHERE `id`=1;\n" +
+	"commit;\n" +
+	"SET GTID_NEXT='AUTOMATIC';\n" +
+	"begin;\n" +
+	"# rows_query=update t1\n# set name = 'x;'\n" +
+	"UPDATE db1.t1\n  SET name = 'x;\ny' -- it's; a comment\n  WHERE id = 1 # c;\n;\n" +
+	"DELETE FROM `db1`.`t1` /* a; 'b */ WHERE `id`=2--1;\n" +
+	"commit;\n"
+
+func TestSqlFileReader(t *testing.T) {
+	expected := []string{
+		"SET GTID_NEXT='3e11fa47-71ca-11e1-9e33-c80aa9429562:1'",
+		"begin",
+		"INSERT INTO `db1`.`t1` (`id`,`name`) VALUES (1,'a;\\nb')",
+		"UPDATE `db1`.`t1` SET `name`='it''s;' WHERE `id`=1",
+		"commit",
+		"SET GTID_NEXT='AUTOMATIC'",
+		"begin",
+		"UPDATE db1.t1\n  SET name = 'x;\ny' -- it's; a comment\n  WHERE id = 1 # c;",
+		"DELETE FROM `db1`.`t1` /* a; 'b */ WHERE `id`=2--1",
+		"commit",
+	}
+	reader := NewSqlFileReader(strings.NewReader(testApplySqlFile), 0)
+	var sqls []string
+	for {
+		oneSql, startPos, err := reader.ReadSql()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(testApplySqlFile[startPos:], oneSql) {
+			t.Errorf("%s does not start at %d", oneSql, startPos)
+		}
+		if !strings.HasSuffix(testApplySqlFile[:reader.Pos], ";\n") {
+			t.Errorf("%s does not end at the end of line, position %d", oneSql, reader.Pos)
+		}
+		sqls = append(sqls, oneSql)
+	}
+	if !reflect.DeepEqual(sqls, expected) {
+		t.Errorf("sqls are\n%q\nexpected\n%q", sqls, expected)
+	}
+	if reader.Pos != int64(len(testApplySqlFile)) {
+		t.Errorf("position is %d at the end, expected %d", reader.Pos, len(testApplySqlFile))
+	}
+
+	// the last sql without ';'
+	reader = NewSqlFileReader(strings.NewReader("begin;\ncommit\n"), 0)
+	for _, sql := range []string{"begin", "commit"} {
+		if oneSql, _, err := reader.ReadSql(); err != nil || oneSql != sql {
+			t.Errorf("got %q %v, expected %q", oneSql, err, sql)
+		}
+	}
+	if _, _, err := reader.ReadSql(); err != io.EOF {
+		t.Errorf("io.EOF expected, but got %v", err)
+	}
+}
+
+func TestApplyOneSqlFileResume(t *testing.T) {
+	sqlFile := filepath.Join(t.TempDir(), "forward.1.sql")
+	if err := ioutil.WriteFile(sqlFile, []byte(testApplySqlFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the sqls are printed with -apply-dry-run
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	secondTrx := int64(strings.Index(testApplySqlFile, "SET GTID_NEXT='AUTOMATIC'"))
+	cases := []struct {
+		name       string
+		offset     int64
+		appliedCnt int
+	}{
+		{"from the beginning", 0, 4},
+		{"resume from the second transaction", secondTrx, 2},
+		{"resume at the end", int64(len(testApplySqlFile)), 0},
+	}
+	for _, c := range cases {
+		applier := &SqlApplier{cfg: &ConfCmd{ApplyDryRun: true, ApplyBatchSize: 1}}
+		if err := applier.ApplyOneSqlFile(sqlFile, c.offset); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if applier.appliedCnt != c.appliedCnt {
+			t.Errorf("%s: %d sqls applied, expected %d", c.name, applier.appliedCnt, c.appliedCnt)
+		}
+		if applier.checkpoint.SqlFile != sqlFile || applier.checkpoint.Offset != int64(len(testApplySqlFile)) {
+			t.Errorf("%s: checkpoint is %+v at the end", c.name, applier.checkpoint)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package base
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	toolkits "my2sql/toolkits"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_binlogIndexSuffix = ".index"
+)
+
+// BinlogFileInfo is a local binlog file, Name is the binlog name without directory and compression suffix
+type BinlogFileInfo struct {
+	Path     string
+	Name     string
+	BaseName string
+	Seq      int
+}
+
+// GetBinlogSequence returns the base name and sequence number of binlog, ex: mysql-bin and 123 for mysql-bin.000123.gz,
+// false if it is not named as binlog
+func GetBinlogSequence(binlog string) (string, int, bool) {
+	name := StripBinlogCompressSuffix(filepath.Base(binlog))
+	idx := strings.LastIndexByte(name, '.')
+	if idx <= 0 || idx == len(name)-1 {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(name[idx+1:])
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return name[:idx], seq, true
+}
+
+// IfBinlogFileList returns true if -local-binlog-file is not a single binlog file, but a comma separated list of
+// binlog files, directories, globs or binlog index files
+func IfBinlogFileList(str string) bool {
+	if strings.Contains(str, ",") || strings.ContainsAny(str, "*?[") || strings.HasSuffix(str, C_binlogIndexSuffix) {
+		return true
+	}
+	ifDir, _ := CheckIsDir(str)
+	return ifDir
+}
+
+// GetBinlogFilesFromIndex returns the binlog files in the binlog index file, such as mysql-bin.index.
+// the paths in index file are relative to datadir of mysql usually, so the binlog files are also looked for
+// in the directory of the index file
+func GetBinlogFilesFromIndex(indexFile string) []string {
+	FH, err := os.Open(indexFile)
+	if err != nil {
+		log.Fatalf("fail to open binlog index file %s: %v", indexFile, err)
+	}
+	defer FH.Close()
+	var files []string
+	scanner := bufio.NewScanner(FH)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		binlog := FindBinlogFile(filepath.Dir(line), filepath.Base(line))
+		if !filepath.IsAbs(line) || !toolkits.IsFile(binlog) {
+			binlog = FindBinlogFile(filepath.Dir(indexFile), filepath.Base(line))
+		}
+		if !toolkits.IsFile(binlog) {
+			log.Warnf("%s in binlog index file %s not exists, skip it", line, indexFile)
+			continue
+		}
+		files = append(files, binlog)
+	}
+	if err = scanner.Err(); err != nil {
+		log.Fatalf("fail to read binlog index file %s: %v", indexFile, err)
+	}
+	return files
+}
+
+// GetBinlogFilesOfList returns the binlog files of -local-binlog-file sorted by sequence, the same binlog is
+// processed only once. the file not named as binlog is skipped
+func GetBinlogFilesOfList(str string) []*BinlogFileInfo {
+	var paths []string
+	for _, item := range CommaSeparatedListToArray(str) {
+		if ifDir, _ := CheckIsDir(item); ifDir {
+			names, err := toolkits.FilesUnder(item)
+			if err != nil {
+				log.Fatalf("fail to list files under %s: %v", item, err)
+			}
+			for _, name := range names {
+				if _, _, ok := GetBinlogSequence(name); ok {
+					paths = append(paths, filepath.Join(item, name))
+				}
+			}
+		} else if strings.HasSuffix(item, C_binlogIndexSuffix) {
+			paths = append(paths, GetBinlogFilesFromIndex(item)...)
+		} else if strings.ContainsAny(item, "*?[") {
+			matches, err := filepath.Glob(item)
+			if err != nil {
+				log.Fatalf("invalid glob %s: %v", item, err)
+			}
+			if len(matches) == 0 {
+				log.Warnf("no file matches %s", item)
+			}
+			for _, match := range matches {
+				if toolkits.IsFile(match) {
+					paths = append(paths, match)
+				}
+			}
+		} else if toolkits.IsFile(item) {
+			paths = append(paths, item)
+		} else {
+			log.Fatalf("%s doesnot exists nor a file\n", item)
+		}
+	}
+
+	var (
+		files     []*BinlogFileInfo
+		nameFiles map[string]string = map[string]string{}
+	)
+	for _, path := range paths {
+		baseName, seq, ok := GetBinlogSequence(path)
+		if !ok {
+			log.Warnf("%s is not named as binlog(basename.sequence), skip it", path)
+			continue
+		}
+		name := StripBinlogCompressSuffix(filepath.Base(path))
+		if orgPath, ok := nameFiles[name]; ok {
+			if orgPath != path {
+				log.Warnf("%s and %s are the same binlog %s, skip %s", orgPath, path, name, path)
+			}
+			continue
+		}
+		nameFiles[name] = path
+		files = append(files, &BinlogFileInfo{Path: path, Name: name, BaseName: baseName, Seq: seq})
+	}
+	if len(files) == 0 {
+		log.Fatalf("no binlog file found in %s", str)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].BaseName != files[j].BaseName {
+			return files[i].BaseName < files[j].BaseName
+		}
+		return files[i].Seq < files[j].Seq
+	})
+	CheckBinlogFilesContinuity(files)
+	return files
+}
+
+// CheckBinlogFilesContinuity warns the missing binlogs between the sorted binlog files
+func CheckBinlogFilesContinuity(files []*BinlogFileInfo) {
+	for i := 1; i < len(files); i++ {
+		if files[i].BaseName != files[i-1].BaseName {
+			log.Warnf("binlog files of different base names are processed one after another: %s, %s", files[i-1].Name, files[i].Name)
+		} else if files[i].Seq != files[i-1].Seq+1 {
+			log.Warnf("binlog files are not continuous, %d binlog(s) missing between %s and %s",
+				files[i].Seq-files[i-1].Seq-1, files[i-1].Name, files[i].Name)
+		}
+	}
+}
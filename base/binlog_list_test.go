@@ -0,0 +1,81 @@
+package base
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetBinlogSequence(t *testing.T) {
+	cases := []struct {
+		binlog   string
+		baseName string
+		seq      int
+		ok       bool
+	}{
+		{"mysql-bin.000123", "mysql-bin", 123, true},
+		{"mysql-bin.000123.gz", "mysql-bin", 123, true},
+		{"mysql-bin.000123.zst", "mysql-bin", 123, true},
+		{"/data/binlog/mysql-bin.000123.xz", "mysql-bin", 123, true},
+		{"my.bin.log.000001", "my.bin.log", 1, true},
+		{"relay-bin.1000000", "relay-bin", 1000000, true},
+		{"mysql-bin.index", "", 0, false},
+		{"mysql-bin.", "", 0, false},
+		{".000123", "", 0, false},
+		{"mysql-bin", "", 0, false},
+		{"mysql-bin.-1", "", 0, false},
+		// not a compression suffix of binlog
+		{"mysql-bin.000123.bz2", "", 0, false},
+	}
+	for _, c := range cases {
+		baseName, seq, ok := GetBinlogSequence(c.binlog)
+		if baseName != c.baseName || seq != c.seq || ok != c.ok {
+			t.Errorf("%s: got %q %d %v, expected %q %d %v", c.binlog, baseName, seq, ok, c.baseName, c.seq, c.ok)
+		}
+	}
+}
+
+func TestGetBinlogFilesOfList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mysql-bin.000122", "mysql-bin.000122.gz", "mysql-bin.000123.gz", "mysql-bin.000125.zst", "relay-bin.000001", "README"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the paths in index file are relative to datadir, the compressed binlog is found for the raw name
+	index := "./mysql-bin.000122\n./mysql-bin.000123\n\n./mysql-bin.000124\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "mysql-bin.index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	cases := []struct {
+		name     string
+		list     string
+		expected []string
+	}{
+		{"directory", dir, []string{"mysql-bin.000122", "mysql-bin.000123.gz", "mysql-bin.000125.zst", "relay-bin.000001"}},
+		{"glob", path("mysql-bin.00012*"), []string{"mysql-bin.000122", "mysql-bin.000123.gz", "mysql-bin.000125.zst"}},
+		{"index file", path("mysql-bin.index"), []string{"mysql-bin.000122", "mysql-bin.000123.gz"}},
+		// sorted by sequence, the first one of the same binlog is processed
+		{"list", strings.Join([]string{path("mysql-bin.000123.gz"), path("mysql-bin.000122.gz"), path("mysql-bin.000122"), path("mysql-bin.000123.gz")}, ","),
+			[]string{"mysql-bin.000122.gz", "mysql-bin.000123.gz"}},
+		{"list of glob and file", path("mysql-bin.000122*") + ", " + path("relay-bin.000001"), []string{"mysql-bin.000122", "relay-bin.000001"}},
+	}
+	for _, c := range cases {
+		var paths []string
+		for _, f := range GetBinlogFilesOfList(c.list) {
+			paths = append(paths, strings.TrimPrefix(f.Path, dir+string(filepath.Separator)))
+			if _, seq, _ := GetBinlogSequence(f.Path); f.Name != StripBinlogCompressSuffix(filepath.Base(f.Path)) || f.Seq != seq {
+				t.Errorf("%s: %+v", c.name, f)
+			}
+		}
+		if !reflect.DeepEqual(paths, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, paths, c.expected)
+		}
+	}
+}
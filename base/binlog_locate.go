@@ -0,0 +1,242 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_binlogHeadMaxEvents = 5 // FORMAT_DESCRIPTION_EVENT and PREVIOUS_GTIDS_EVENT are at the head of binlog
+)
+
+// BinlogHead is the head of binlog, the timestamp of FORMAT_DESCRIPTION_EVENT is when the binlog is created,
+// all events in the binlogs before it are logged before this time
+type BinlogHead struct {
+	Timestamp     uint32
+	PreviousGtids *mysql.MysqlGTIDSet // nil if there is no PREVIOUS_GTIDS_EVENT, ex: mariadb, mysql5.6 with gtid_mode=OFF
+}
+
+// HandleEvent records the event at the head of binlog, it returns true if the head is complete
+func (this *BinlogHead) HandleEvent(h *replication.EventHeader, e replication.Event) bool {
+	switch h.EventType {
+	case replication.ROTATE_EVENT:
+		// the artificial ROTATE_EVENT at the beginning of replication
+		return false
+	case replication.FORMAT_DESCRIPTION_EVENT:
+		if this.Timestamp == 0 {
+			this.Timestamp = h.Timestamp
+		}
+		return false
+	case replication.PREVIOUS_GTIDS_EVENT:
+		gset, err := mysql.ParseMysqlGTIDSet(e.(*replication.PreviousGTIDsEvent).GTIDSets)
+		if err != nil {
+			log.Warnf("invalid previous gtids %s: %v", e.(*replication.PreviousGTIDsEvent).GTIDSets, err)
+		} else {
+			this.PreviousGtids = gset.(*mysql.MysqlGTIDSet)
+		}
+		return true
+	}
+	return true
+}
+
+// ReadBinlogFileHead reads only the head events of local binlog file
+func ReadBinlogFileHead(name string) (*BinlogHead, error) {
+	f, err := OpenBinlogFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, len(replication.BinLogFileHeader))
+	if _, err = io.ReadFull(f, b); err != nil {
+		return nil, err
+	} else if !bytes.Equal(b, replication.BinLogFileHeader) {
+		return nil, fmt.Errorf("%s is not a valid binlog file, head 4 bytes must fe'bin'", name)
+	}
+
+	parser := replication.NewBinlogParser()
+	head := &BinlogHead{}
+	for i := 0; i < C_binlogHeadMaxEvents; i++ {
+		headBuf := make([]byte, replication.EventHeaderSize)
+		if _, err = io.ReadFull(f, headBuf); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		h, err := parser.ParseHeader(headBuf)
+		if err != nil {
+			return nil, err
+		}
+		if h.EventSize <= uint32(replication.EventHeaderSize) {
+			return nil, fmt.Errorf("invalid event header, event size is %d, too small", h.EventSize)
+		}
+		data := make([]byte, int(h.EventSize)-replication.EventHeaderSize)
+		if _, err = io.ReadFull(f, data); err != nil {
+			return nil, err
+		}
+		e, err := parser.ParseEvent(h, data, append(headBuf, data...))
+		if err != nil {
+			return nil, err
+		}
+		if head.HandleEvent(h, e) {
+			break
+		}
+	}
+	return head, nil
+}
+
+// ReadBinlogHeadFromRepl reads the timestamp of binlog on mysql by replicating from the head of it,
+// PREVIOUS_GTIDS_EVENT is not waited for, the last binlog may have no more events
+func ReadBinlogHeadFromRepl(cfg *ConfCmd, binlog string) (*BinlogHead, error) {
+	replSyncer, replStreamer, err := StartReplBinlogStreamer(cfg, mysql.Position{Name: binlog, Pos: 4})
+	if err != nil {
+		return nil, err
+	}
+	defer replSyncer.Close()
+
+	head := &BinlogHead{}
+	for i := 0; i < C_binlogHeadMaxEvents && head.Timestamp == 0; i++ {
+		ctx, cancel := context.WithTimeout(cfg.StopCtx, EventTimeout)
+		ev, err := replStreamer.GetEvent(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if head.HandleEvent(ev.Header, ev.Event) {
+			break
+		}
+	}
+	return head, nil
+}
+
+// IfLocateStartBinlog returns true if the binlog to start with can be located by -start-datetime or -start-gtid
+func IfLocateStartBinlog(cfg *ConfCmd) bool {
+	return cfg.IfSetStartDateTime || cfg.GtidState.StartGtid != "" || cfg.GtidState.StartGtidSet != nil
+}
+
+// IfStartBeforeBinlog returns true if the parsing starts after the head of binlog, so the binlogs before it can be skipped
+func IfStartBeforeBinlog(cfg *ConfCmd, head *BinlogHead) bool {
+	if cfg.IfSetStartDateTime && (head.Timestamp == 0 || head.Timestamp >= cfg.StartDatetime) {
+		// the events with the same second may be in the previous binlog
+		return false
+	}
+	if cfg.GtidState.StartGtid != "" || cfg.GtidState.StartGtidSet != nil {
+		if head.PreviousGtids == nil {
+			return false
+		}
+		if cfg.GtidState.StartGtid != "" && head.PreviousGtids.Contain(ParseGtidSetOption("-start-gtid", cfg.GtidState.StartGtid)) {
+			return false
+		}
+		if cfg.GtidState.StartGtidSet != nil && !cfg.GtidState.StartGtidSet.Contain(head.PreviousGtids) {
+			return false
+		}
+	}
+	return true
+}
+
+// LocateStartBinlog returns the index of binlog to start with among the binlogs sorted by sequence, it is the last one
+// created before -start-datetime and whose Previous_gtids does not contain -start-gtid. only the heads of log2(n)
+// binlogs are read by binary search, 0 is returned if it fails to read the head of any binlog
+func LocateStartBinlog(cfg *ConfCmd, binlogs []string, getHead func(binlog string) (*BinlogHead, error)) int {
+	var headErr error
+	idx := sort.Search(len(binlogs), func(i int) bool {
+		if headErr != nil {
+			return true
+		}
+		head, err := getHead(binlogs[i])
+		if err != nil {
+			headErr = fmt.Errorf("fail to read head of binlog %s: %v", binlogs[i], err)
+			return true
+		}
+		return !IfStartBeforeBinlog(cfg, head)
+	})
+	if headErr != nil {
+		log.Warnf("%v, start with %s", headErr, binlogs[0])
+		return 0
+	}
+	if idx > 0 {
+		idx--
+	}
+	log.Infof("%s is located to start with by -start-datetime/-start-gtid, %d binlog(s) before it are skipped", binlogs[idx], idx)
+	return idx
+}
+
+// LocateStartBinlogRepl sets -start-file to the binlog on mysql located by -start-datetime, the binlogs
+// are got by SHOW BINARY LOGS. it works without -start-file
+func LocateStartBinlogRepl(cfg *ConfCmd) {
+	if !cfg.IfSetStartDateTime || cfg.GtidState.StartGtid != "" || cfg.GtidState.StartGtidSet != nil {
+		// replicate by gtid with -start-gtid
+		return
+	}
+	var err error
+	if cfg.FromDB == nil {
+		cfg.FromDB, err = CreateMysqlCon(GetMysqlUrl(cfg))
+		if err != nil {
+			log.Fatalf("fail to connect to mysql to get binary logs: %v", err)
+		}
+	}
+	allBinlogs, err := GetBinaryLogs(cfg.FromDB)
+	if err != nil {
+		log.Fatalf("fail to get binary logs of mysql: %v", err)
+	}
+	var binlogs []string
+	for _, binlog := range allBinlogs {
+		if cfg.StartFile != "" && mysql.CompareBinlogFileName(binlog, cfg.StartFile) < 0 {
+			continue
+		}
+		if cfg.IfSetStopFilePos && mysql.CompareBinlogFileName(binlog, cfg.StopFilePos.Name) > 0 {
+			break
+		}
+		binlogs = append(binlogs, binlog)
+	}
+	if len(binlogs) == 0 {
+		if cfg.StartFile == "" {
+			log.Fatalf("no binary log found on mysql %s:%d", cfg.Host, cfg.Port)
+		}
+		return
+	}
+
+	idx := LocateStartBinlog(cfg, binlogs, func(binlog string) (*BinlogHead, error) {
+		return ReadBinlogHeadFromRepl(cfg, binlog)
+	})
+	if cfg.StartFile == "" || binlogs[idx] != cfg.StartFile {
+		cfg.StartFile = binlogs[idx]
+		cfg.StartPos = 4
+	}
+}
+
+// GetBinaryLogs returns the binlogs of mysql by SHOW BINARY LOGS
+func GetBinaryLogs(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Log_name, File_size, and Encrypted of mysql8.0
+	rowColumns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var binlogs []string
+	for rows.Next() {
+		data := make([]sql.RawBytes, len(rowColumns))
+		values := make([]interface{}, len(rowColumns))
+		for i := range values {
+			values[i] = &data[i]
+		}
+		if err = rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		binlogs = append(binlogs, string(data[0]))
+	}
+	return binlogs, rows.Err()
+}
@@ -0,0 +1,105 @@
+package base
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testBinlogHead(timestamp uint32, previousGtids string) *BinlogHead {
+	head := &BinlogHead{Timestamp: timestamp}
+	if previousGtids != "-" {
+		head.PreviousGtids = ParseGtidSetOption("previous gtids", previousGtids)
+	}
+	return head
+}
+
+func TestLocateStartBinlog(t *testing.T) {
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	binlogs := []string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003", "mysql-bin.000004"}
+	heads := map[string]*BinlogHead{
+		"mysql-bin.000001": testBinlogHead(1000, ""),
+		"mysql-bin.000002": testBinlogHead(2000, uuid+":1-10"),
+		"mysql-bin.000003": testBinlogHead(3000, uuid+":1-20"),
+		"mysql-bin.000004": testBinlogHead(4000, uuid+":1-30"),
+	}
+	cases := []struct {
+		name          string
+		startDatetime uint32
+		startGtid     string
+		startGtidSet  string
+		expected      int
+	}{
+		{"datetime before the first binlog", 500, "", "", 0},
+		{"datetime at the head of the first binlog", 1000, "", "", 0},
+		{"datetime in the first binlog", 1999, "", "", 0},
+		// the events of the same second may be in the previous binlog
+		{"datetime at the head of a binlog", 2000, "", "", 0},
+		{"datetime after the head of a binlog", 2001, "", "", 1},
+		{"datetime at the head of the last binlog", 4000, "", "", 2},
+		{"datetime after the last binlog", 5000, "", "", 3},
+		{"gtid in the first binlog", 0, uuid + ":10", "", 0},
+		{"gtid at the head of a binlog", 0, uuid + ":11", "", 1},
+		{"gtid in a binlog", 0, uuid + ":25", "", 2},
+		{"gtid after the last binlog", 0, uuid + ":31", "", 3},
+		{"gtid set of the first binlog", 0, "", uuid + ":1-9", 0},
+		{"gtid set at the head of a binlog", 0, "", uuid + ":1-20", 2},
+		{"gtid set after the last binlog", 0, "", uuid + ":1-40", 3},
+		// the binlog must satisfy both
+		{"datetime before gtid", 2500, uuid + ":25", "", 1},
+		{"gtid before datetime", 3500, uuid + ":15", "", 1},
+	}
+	for _, c := range cases {
+		cfg := &ConfCmd{IfSetStartDateTime: c.startDatetime > 0, StartDatetime: c.startDatetime}
+		cfg.GtidState.StartGtid = c.startGtid
+		if c.startGtidSet != "" {
+			cfg.GtidState.StartGtidSet = ParseGtidSetOption("-start-gtid", c.startGtidSet)
+		}
+		readCnt := 0
+		idx := LocateStartBinlog(cfg, binlogs, func(binlog string) (*BinlogHead, error) {
+			readCnt++
+			return heads[binlog], nil
+		})
+		if idx != c.expected {
+			t.Errorf("%s: start with %s, expected %s", c.name, binlogs[idx], binlogs[c.expected])
+		}
+		// binary search over 4 binlogs
+		if readCnt > 3 {
+			t.Errorf("%s: heads of %d binlogs are read", c.name, readCnt)
+		}
+	}
+
+	// start with the first binlog if any head cannot be read
+	cfg := &ConfCmd{IfSetStartDateTime: true, StartDatetime: 5000}
+	idx := LocateStartBinlog(cfg, binlogs, func(binlog string) (*BinlogHead, error) {
+		if binlog == "mysql-bin.000003" {
+			return nil, fmt.Errorf("truncated binlog")
+		}
+		return heads[binlog], nil
+	})
+	if idx != 0 {
+		t.Errorf("start with %s, expected the first binlog if fail to read head", binlogs[idx])
+	}
+}
+
+func TestIfStartBeforeBinlog(t *testing.T) {
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	cases := []struct {
+		name      string
+		head      *BinlogHead
+		startGtid string
+		expected  bool
+	}{
+		// the binlog cannot be skipped without timestamp or previous gtids
+		{"no timestamp", testBinlogHead(0, "-"), "", false},
+		{"no previous gtids", testBinlogHead(1000, "-"), uuid + ":1", false},
+		{"empty previous gtids", testBinlogHead(1000, ""), uuid + ":1", true},
+		{"gtid of other server", testBinlogHead(1000, uuid+":1-10"), "4e11fa47-71ca-11e1-9e33-c80aa9429562:1", true},
+	}
+	for _, c := range cases {
+		cfg := &ConfCmd{IfSetStartDateTime: true, StartDatetime: 2000}
+		cfg.GtidState.StartGtid = c.startGtid
+		if ifBefore := IfStartBeforeBinlog(cfg, c.head); ifBefore != c.expected {
+			t.Errorf("%s: got %v, expected %v", c.name, ifBefore, c.expected)
+		}
+	}
+}
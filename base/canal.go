@@ -0,0 +1,179 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"my2sql/dsql"
+	"github.com/siddontang/go-log/log"
+)
+
+var (
+	// java.sql.Types of mysql column types, the same as canal
+	GCanalSqlTypes map[string]int = map[string]int{
+		"bit": -7, "tinyint": -6, "smallint": 5, "mediumint": 4, "int": 4, "integer": 4, "bigint": -5,
+		"float": 7, "double": 8, "decimal": 3,
+		"char": 1, "varchar": 12, "tinytext": 2005, "text": 2005, "mediumtext": 2005, "longtext": 2005,
+		"binary": -2, "varbinary": -3, "tinyblob": 2004, "blob": 2004, "mediumblob": 2004, "longblob": 2004,
+		"date": 91, "time": 92, "datetime": 93, "timestamp": 93, "year": 12,
+		"enum": 4, "set": -7, "json": 12, "geometry": -2,
+	}
+	GCanalDdlTypes map[int]string = map[int]string{
+		dsql.SQL_TYPE_CREATE_TABLE: "CREATE",
+		dsql.SQL_TYPE_ALTER_TABLE:  "ALTER",
+		dsql.SQL_TYPE_DROP_TABLE:   "ERASE",
+		dsql.SQL_TYPE_RENAME_TABLE: "RENAME",
+	}
+)
+
+// CanalFlatMessage is one line of -output-format=canal, the same as FlatMessage of canal.
+// Id is the index of the transaction, so messages of one transaction have the same id
+type CanalFlatMessage struct {
+	Id        uint64      `json:"id"`
+	Database  string      `json:"database"`
+	Table     string      `json:"table"`
+	PkNames   []string    `json:"pkNames"`
+	IsDdl     bool        `json:"isDdl"`
+	Type      string      `json:"type"`
+	Es        int64       `json:"es"`
+	Ts        int64       `json:"ts"`
+	Sql       string      `json:"sql"`
+	SqlType   *RowImage   `json:"sqlType"`
+	MysqlType *RowImage   `json:"mysqlType"`
+	Data      []*RowImage `json:"data"`
+	Old       []*RowImage `json:"old"`
+}
+
+// GetCanalColumnValue formats column value as string like canal, binary value is decoded as ISO-8859-1
+func GetCanalColumnValue(v interface{}, colType string) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return val
+	case []byte:
+		if colType == "json" {
+			return string(val)
+		}
+		runes := make([]rune, len(val))
+		for i, b := range val {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+func GetCanalRowImage(row []interface{}, colNames []FieldInfo, colsTypeName []string, colIdx []int, present []bool) *RowImage {
+	colIdx = GetPresentColumnIdx(present, colIdx, len(row))
+	image := &RowImage{Names: make([]string, len(colIdx)), Values: make([]interface{}, len(colIdx))}
+	for i, ci := range colIdx {
+		image.Names[i] = colNames[ci].FieldName
+		image.Values[i] = GetCanalColumnValue(row[ci], colsTypeName[ci])
+	}
+	return image
+}
+
+func GetCanalMessageLine(msg *CanalFlatMessage, posStr string) string {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		log.Fatalf("fail to marshal %s of %s at %s into json: %v", msg.Type, GetAbsTableName(msg.Database, msg.Table), posStr, err)
+	}
+	return string(line)
+}
+
+// GenCanalLinesForOneRowsEvent generates one flat message for the rows event. old of update only contains changed columns,
+// the columns absent in the row image are omitted in data and old. changed columns are detected by the rows of ev,
+// the values are from renderRows, which are the masked rows with -mask-rules
+func GenCanalLinesForOneRowsEvent(cfg *ConfCmd, ev *MyBinEvent, renderRows [][]interface{}, tbInfo *TblInfoJson, colNames []FieldInfo, colsTypeName []string, imageCols *RowImageColumns) []string {
+	if renderRows == nil {
+		renderRows = ev.BinEvent.Rows
+	}
+	var (
+		colCnt int = len(colNames)
+		colIdx []int
+	)
+	msg := &CanalFlatMessage{
+		Id:        ev.TrxIndex,
+		Database:  string(ev.BinEvent.Table.Schema),
+		Table:     string(ev.BinEvent.Table.Table),
+		PkNames:   tbInfo.PrimaryKey,
+		IsDdl:     false,
+		Type:      strings.ToUpper(ev.SqlType),
+		Es:        int64(ev.Timestamp) * 1000,
+		Ts:        time.Now().UnixNano() / int64(time.Millisecond),
+		Sql:       ev.RowsQuery,
+		SqlType:   &RowImage{Names: make([]string, colCnt), Values: make([]interface{}, colCnt)},
+		MysqlType: &RowImage{Names: make([]string, colCnt), Values: make([]interface{}, colCnt)},
+	}
+	for ci, col := range colNames {
+		msg.SqlType.Names[ci] = col.FieldName
+		msg.MysqlType.Names[ci] = col.FieldName
+		sqlType, ok := GCanalSqlTypes[strings.ToLower(col.FieldType)]
+		if !ok {
+			sqlType = 1111 // java.sql.Types.OTHER
+		}
+		msg.SqlType.Values[ci] = sqlType
+		if col.IsUnsigned {
+			msg.MysqlType.Values[ci] = col.FieldType + " unsigned"
+		} else {
+			msg.MysqlType.Values[ci] = col.FieldType
+		}
+	}
+
+	if ev.SqlType == "update" {
+		msg.Old = []*RowImage{}
+		for i := 0; i < len(ev.BinEvent.Rows); i += 2 {
+			colIdx = []int{}
+			for ci := range ev.BinEvent.Rows[i] {
+				if IfColumnPresent(imageCols.Before, ci) && IfColumnPresent(imageCols.After, ci) && IfColumnUpdated(colNames[ci].FieldType, colsTypeName[ci], ev.BinEvent.Rows[i+1][ci], ev.BinEvent.Rows[i][ci]) {
+					colIdx = append(colIdx, ci)
+				}
+			}
+			// data is the whole row after update, the columns absent in the after image are unchanged ones of the before image,
+			// except the json columns logged as diffs, whose value after update is unknown
+			msg.Data = append(msg.Data, GetCanalRowImage(MergeRowImages(renderRows[i+1], imageCols.After, renderRows[i]),
+				colNames, colsTypeName, nil, OmitPartialJsonColumns(OrPresentColumns(imageCols.After, imageCols.Before), imageCols.PartialJson)))
+			msg.Old = append(msg.Old, GetCanalRowImage(renderRows[i], colNames, colsTypeName, colIdx, nil))
+		}
+	} else if ev.SqlType == "insert" {
+		for _, row := range renderRows {
+			msg.Data = append(msg.Data, GetCanalRowImage(row, colNames, colsTypeName, nil, imageCols.After))
+		}
+	} else {
+		for _, row := range renderRows {
+			msg.Data = append(msg.Data, GetCanalRowImage(row, colNames, colsTypeName, nil, imageCols.Before))
+		}
+	}
+	return []string{GetCanalMessageLine(msg, GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos))}
+}
+
+// GenCanalLinesForQuery generates flat message with isDdl=true for CREATE/ALTER/DROP/RENAME TABLE,
+// and type QUERY with isDdl=false for statement based dml
+func GenCanalLinesForQuery(ev *MyBinEvent) (string, string, []string) {
+	msg := &CanalFlatMessage{
+		Id:       ev.TrxIndex,
+		Database: ev.QuerySql.UseDatabase,
+		IsDdl:    !ev.QuerySql.IsDml(),
+		Type:     GCanalDdlTypes[ev.QuerySql.SqlType],
+		Es:       int64(ev.Timestamp) * 1000,
+		Ts:       time.Now().UnixNano() / int64(time.Millisecond),
+		Sql:      ev.OrgSql,
+	}
+	if len(ev.QuerySql.Tables) > 0 {
+		msg.Database = ev.QuerySql.Tables[0].Database
+		msg.Table = ev.QuerySql.Tables[0].Table
+	}
+	if msg.Type == "" {
+		msg.Type = "QUERY"
+	}
+	return msg.Database, msg.Table, []string{GetCanalMessageLine(msg, GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos))}
+}
@@ -0,0 +1,199 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	constvar "my2sql/constvar"
+	toolkits "my2sql/toolkits"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_checkpointInterval = 1 * time.Second
+)
+
+type CheckpointSqlFile struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	TrxOpen bool   `json:"trx_open,omitempty"` // -keep-trx, commit of the last transaction is not written yet
+	Gtid    string `json:"gtid,omitempty"`     // gtid of the last transaction
+
+	BaseFile string `json:"base_file,omitempty"` // -rotate-interval -rotate-size, file name without sequence
+	Rotated  bool   `json:"rotated,omitempty"`   // the file is closed by rotation
+}
+
+// binlog events before Binlog/Pos are processed, and sqls of them are in SqlFiles
+type ParseCheckpoint struct {
+	Binlog   string              `json:"binlog"`
+	Pos      uint32              `json:"pos"`
+	GtidSet  string              `json:"gtid_set"` // gtids of transactions parsed
+	Datetime string              `json:"datetime"`
+	SqlFiles []CheckpointSqlFile `json:"sql_files"`
+}
+
+type ParseCheckpointWriter struct {
+	cfg          *ConfCmd
+	rotator      *SqlFileRotator
+	checkpoint   ParseCheckpoint
+	gtidSet      *mysql.MysqlGTIDSet
+	lastSaveTime time.Time
+}
+
+func ReadParseCheckpoint(cpFile string) (*ParseCheckpoint, error) {
+	if !toolkits.IsFile(cpFile) {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(cpFile)
+	if err != nil {
+		return nil, err
+	}
+	cp := &ParseCheckpoint{}
+	err = json.Unmarshal(content, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// ResumeFromCheckpoint sets start position to the position of checkpoint
+func (this *ConfCmd) ResumeFromCheckpoint() {
+	cp, err := ReadParseCheckpoint(this.CheckpointFile)
+	if err != nil {
+		log.Fatalf("fail to read checkpoint file %s: %v", this.CheckpointFile, err)
+	}
+	if cp == nil {
+		log.Infof("checkpoint file %s not exists, start from the beginning", this.CheckpointFile)
+		return
+	}
+	log.Infof("resume from checkpoint %s %d, gtid set: %s", cp.Binlog, cp.Pos, cp.GtidSet)
+	this.ResumeCheckpoint = cp
+	this.StartFile = cp.Binlog
+	this.StartPos = uint(cp.Pos)
+	this.StartFilePos = mysql.Position{Name: cp.Binlog, Pos: cp.Pos}
+	this.IfSetStartFilePos = true
+	// start position of gtid is passed, replicate with file and position
+	this.GtidState.StartGtid = ""
+	this.GtidState.StartGtidSet = nil
+	this.GtidState.started = true
+	this.GtidState.skipTrx = false
+	if cp.GtidSet != "" {
+		this.GtidState.parsedGtidSet = ParseGtidSetOption("-checkpoint-file", cp.GtidSet)
+	}
+}
+
+func NewParseCheckpointWriter(cfg *ConfCmd, rotator *SqlFileRotator) *ParseCheckpointWriter {
+	cpWriter := &ParseCheckpointWriter{cfg: cfg, rotator: rotator, gtidSet: ParseGtidSetOption("", "")}
+	if cfg.ResumeCheckpoint != nil {
+		cpWriter.checkpoint = *cfg.ResumeCheckpoint
+		if cfg.ResumeCheckpoint.GtidSet != "" {
+			cpWriter.gtidSet = ParseGtidSetOption("-checkpoint-file", cfg.ResumeCheckpoint.GtidSet)
+		}
+	}
+	return cpWriter
+}
+
+// ReopenSqlFiles reopens sql files of checkpoint, content after checkpoint is discarded
+func (this *ParseCheckpointWriter) ReopenSqlFiles(fhArr map[string]*os.File, fhArrBuf map[string]*bufio.Writer) {
+	if this.cfg.ResumeCheckpoint == nil {
+		return
+	}
+	for _, sqlFile := range this.cfg.ResumeCheckpoint.SqlFiles {
+		FH, err := os.OpenFile(sqlFile.File, os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("fail to open %s: %v", sqlFile.File, err)
+		}
+		if err = FH.Truncate(sqlFile.Size); err == nil {
+			_, err = FH.Seek(sqlFile.Size, io.SeekStart)
+		}
+		if err != nil {
+			log.Fatalf("fail to truncate %s to size %d: %v", sqlFile.File, sqlFile.Size, err)
+		}
+		this.cfg.OutputSqlFiles = append(this.cfg.OutputSqlFiles, sqlFile.File)
+		baseFile := sqlFile.File
+		if sqlFile.BaseFile != "" {
+			baseFile = sqlFile.BaseFile
+		}
+		this.rotator.Reopen(baseFile, sqlFile.File, sqlFile.Size)
+		if sqlFile.Rotated {
+			// sqls after it are written into the next file
+			FH.Close()
+			this.rotator.Rotate(baseFile)
+			continue
+		}
+		fhArr[sqlFile.File] = FH
+		fhArrBuf[sqlFile.File] = bufio.NewWriter(FH)
+		if sqlFile.TrxOpen {
+			fhArrBuf[sqlFile.File].WriteString(GetTrxCommitSql(this.cfg, &ExtraSqlInfoOfPrint{gtid: sqlFile.Gtid}))
+		}
+	}
+}
+
+// Save records the position after the committed transaction of sqlInfo. if force is false,
+// checkpoint is saved at most once every C_checkpointInterval
+func (this *ParseCheckpointWriter) Save(sqlInfo *ExtraSqlInfoOfPrint, fhArr map[string]*os.File, fhArrBuf map[string]*bufio.Writer,
+	lastTrxFiles map[string]*ExtraSqlInfoOfPrint, force bool) {
+	if sqlInfo != nil {
+		this.checkpoint.Binlog = sqlInfo.binlog
+		this.checkpoint.Pos = sqlInfo.endpos
+		if sqlInfo.gtid != "" {
+			this.gtidSet.Update(sqlInfo.gtid)
+		}
+	}
+	if this.checkpoint.Binlog == "" || (!force && time.Since(this.lastSaveTime) < C_checkpointInterval) {
+		return
+	}
+	this.lastSaveTime = time.Now()
+	this.checkpoint.GtidSet = this.gtidSet.String()
+	this.checkpoint.Datetime = time.Now().Format(constvar.DATETIME_FORMAT)
+	this.checkpoint.SqlFiles = []CheckpointSqlFile{}
+	for _, fn := range this.cfg.OutputSqlFiles {
+		sqlFile := CheckpointSqlFile{File: fn}
+		if baseFile := this.rotator.GetBaseFile(fn); baseFile != fn {
+			sqlFile.BaseFile = baseFile
+		}
+		if _, ok := fhArr[fn]; !ok {
+			// closed by rotation
+			fileInfo, err := os.Stat(fn)
+			if err != nil {
+				log.Fatalf("fail to get size of %s: %v", fn, err)
+			}
+			sqlFile.Size = fileInfo.Size()
+			sqlFile.Rotated = true
+			this.checkpoint.SqlFiles = append(this.checkpoint.SqlFiles, sqlFile)
+			continue
+		}
+		err := fhArrBuf[fn].Flush()
+		if err != nil {
+			log.Fatalf("fail to write %s: %v", fn, err)
+		}
+		size, err := fhArr[fn].Seek(0, io.SeekCurrent)
+		if err != nil {
+			log.Fatalf("fail to get size of %s: %v", fn, err)
+		}
+		sqlFile.Size = size
+		if lastTrx, ok := lastTrxFiles[fn]; ok && lastTrx != nil {
+			sqlFile.TrxOpen = true
+			sqlFile.Gtid = lastTrx.gtid
+		}
+		this.checkpoint.SqlFiles = append(this.checkpoint.SqlFiles, sqlFile)
+	}
+
+	content, err := json.MarshalIndent(this.checkpoint, "", constvar.JSON_INDENT_TAB)
+	if err != nil {
+		log.Fatalf("fail to marshal checkpoint %v", err)
+	}
+	tmpFile := this.cfg.CheckpointFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, this.cfg.CheckpointFile)
+	}
+	if err != nil {
+		log.Fatalf("fail to write checkpoint file %s: %v", this.cfg.CheckpointFile, err)
+	}
+}
@@ -2,10 +2,8 @@ package base
 
 import (
 	"sync"
-	"path/filepath"
 
 	"my2sql/dsql"
-	toolkits "my2sql/toolkits"
 	"github.com/siddontang/go-log/log"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
@@ -15,6 +13,7 @@ type BinEventHandlingIndx struct {
 	EventIdx uint64
 	lock     sync.RWMutex
 	Finished bool
+	LastPrintTrx *ExtraSqlInfoOfPrint // works with -keep-trx and -output-toScreen
 }
 
 var (
@@ -33,6 +32,12 @@ type MyBinEvent struct {
 	TrxStatus   int           // 0:begin, 1: commit, 2: rollback, -1: in_progress
 	QuerySql    *dsql.SqlInfo // for ddl and binlog which is not row format
 	OrgSql      string        // for ddl and binlog which is not row format
+	Gtid        string        // gtid of the transaction, empty for anonymous transaction
+	RowsQuery   string        // original sql of rows event, empty if binlog_rows_query_log_events=OFF
+	StmtVars    []string      // SET INSERT_ID/LAST_INSERT_ID/RAND_SEED before statement based dml
+
+	MasterPos      mysql.Position // end position in master binlog with -mode=relaylog, MyPos is the position in relay log
+	MasterStartPos uint32         // start position in master binlog with -mode=relaylog
 }
 
 func (this *MyBinEvent) CheckBinEvent(cfg *ConfCmd, ev *replication.BinlogEvent, currentBinlog *string) int {
@@ -45,6 +50,25 @@ func (this *MyBinEvent) CheckBinEvent(cfg *ConfCmd, ev *replication.BinlogEvent,
 		return C_reContinue
 	}
 
+	if ev.Header.EventType == replication.GTID_EVENT || ev.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+		this.IfRowsEvent = false
+		cfg.RowsQuery = ""
+		if cfg.GtidState.CheckGtidEvent(ev) == C_reBreak {
+			return C_reBreak
+		}
+		return C_reContinue
+	}
+	if cfg.GtidState.IfSkipEvent() {
+		if ev.Header.EventType == replication.QUERY_EVENT {
+			// ddl of the skipped transaction still changes the table struct of the following events, only its sql is not output
+			this.IfRowsEvent = false
+			this.HandleDdlQuery(cfg, ev.Event.(*replication.QueryEvent))
+			this.QuerySql = nil
+			cfg.StmtVars = nil
+		}
+		return C_reContinue
+	}
+
 	if cfg.IfSetStartFilePos {
 		cmpRe := myPos.Compare(cfg.StartFilePos)
 		if cmpRe == -1 {
@@ -112,40 +136,48 @@ BinEventCheck:
 		wrEvent := ev.Event.(*replication.RowsEvent)
 		db := string(wrEvent.Table.Schema)
 		tb := string(wrEvent.Table.Table)
-		/*if !cfg.IsTargetTable(db, tb) {
+		if !cfg.IsTargetTable(db, tb) {
 			return C_reContinue
-		}*/
-
-		if len(cfg.Databases) > 0 {
-			if !toolkits.ContainsString(cfg.Databases, db) {
-				return C_reContinue
-			}
-		}
-		if len(cfg.Tables) > 0 {
-			if !toolkits.ContainsString(cfg.Tables, tb) {
-				return C_reContinue
-			}
-		}
-
-		if len(cfg.IgnoreDatabases) > 0 {
-			if toolkits.ContainsString(cfg.IgnoreDatabases, db) {
-				return C_reContinue
-			}
 		}
-
-		if len(cfg.IgnoreTables) > 0 {
-			if toolkits.ContainsString(cfg.IgnoreTables, tb) {
-				return C_reContinue
-			}
+		if !this.FilterRowsByWhere(cfg, ev, wrEvent) {
+			return C_reContinue
 		}
 
 		this.BinEvent = wrEvent
 		this.IfRowsEvent = true
+		this.RowsQuery = cfg.RowsQuery
+	case replication.ROWS_QUERY_EVENT:
+		// original sql is not output if column values are masked, it may contain the values
+		if cfg.Masker == nil {
+			cfg.RowsQuery = string(ev.Event.(*replication.RowsQueryEvent).Query)
+		}
+		this.IfRowsEvent = false
+		return C_reContinue
+	case replication.MARIADB_ANNOTATE_ROWS_EVENT:
+		if cfg.Masker == nil {
+			cfg.RowsQuery = string(ev.Event.(*replication.MariadbAnnotateRowsEvent).Query)
+		}
+		this.IfRowsEvent = false
+		return C_reContinue
+	case replication.INTVAR_EVENT, replication.RAND_EVENT:
+		// context of the following statement based dml
+		if stmtVar := GetStmtVarFromEvent(ev); stmtVar != "" {
+			cfg.StmtVars = append(cfg.StmtVars, stmtVar)
+		}
+		this.IfRowsEvent = false
+		return C_reContinue
 	case replication.QUERY_EVENT:
 		this.IfRowsEvent = false
+		cfg.RowsQuery = ""
+		this.HandleDdlQuery(cfg, ev.Event.(*replication.QueryEvent))
+		if this.QuerySql == nil {
+			this.HandleDmlQuery(cfg, ev, ev.Event.(*replication.QueryEvent))
+		}
+		cfg.StmtVars = nil
 
 	case replication.XID_EVENT:
 		this.IfRowsEvent = false
+		cfg.RowsQuery = ""
 
 	case replication.MARIADB_GTID_EVENT:
 		this.IfRowsEvent = false
@@ -154,6 +186,7 @@ BinEventCheck:
 		this.IfRowsEvent = false
 		return C_reContinue
 	}
+	this.Gtid = cfg.GtidState.CurrentGtid
 
 	return C_reProcess
 
@@ -227,7 +260,7 @@ func GetFirstBinlogPosToParse(cfg *ConfCmd) (string, int64) {
 	var binlog string
 	var pos int64
 	if cfg.StartFile != "" {
-		binlog = filepath.Join(cfg.BinlogDir, cfg.StartFile)
+		binlog = FindBinlogFile(cfg.BinlogDir, cfg.StartFile)
 	} else {
 		binlog = cfg.GivenBinlogFile
 	}
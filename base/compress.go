@@ -0,0 +1,118 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	toolkits "my2sql/toolkits"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	C_binlogReadBufSize = 4 * 1024 * 1024
+)
+
+var (
+	// suffixes of compressed binlog files, ex: mysql-bin.000123.gz
+	GBinlogCompressSuffixes []string = []string{".gz", ".zst", ".zstd", ".xz"}
+
+	gGzipMagic []byte = []byte{0x1f, 0x8b}
+	gZstdMagic []byte = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gXzMagic   []byte = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// BinlogFileReader reads binlog file, the compressed file is decompressed by the magic number at the head of it
+type BinlogFileReader struct {
+	io.Reader
+	file         *os.File
+	decompressor io.Closer
+}
+
+func (this *BinlogFileReader) Close() error {
+	if this.decompressor != nil {
+		this.decompressor.Close()
+	}
+	return this.file.Close()
+}
+
+type zstdCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (this zstdCloser) Close() error {
+	this.decoder.Close()
+	return nil
+}
+
+// OpenBinlogFile opens raw binlog file, or binlog file compressed by gzip, zstd or xz
+func OpenBinlogFile(name string) (*BinlogFileReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	reader, decompressor, err := NewBinlogReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BinlogFileReader{Reader: reader, file: f, decompressor: decompressor}, nil
+}
+
+// NewBinlogReader returns the buffered reader of raw binlog, or the decompressing reader of binlog compressed by
+// gzip, zstd or xz. the compression is detected by the magic number at the head of it
+func NewBinlogReader(r io.Reader) (io.Reader, io.Closer, error) {
+	bufReader := bufio.NewReaderSize(r, C_binlogReadBufSize)
+	// error is ignored, the file is too short to be compressed, it is checked as raw binlog file
+	magic, _ := bufReader.Peek(len(gXzMagic))
+	if bytes.HasPrefix(magic, gGzipMagic) {
+		gzReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gzReader, gzReader, nil
+	} else if bytes.HasPrefix(magic, gZstdMagic) {
+		zstdReader, err := zstd.NewReader(bufReader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zstdReader, zstdCloser{decoder: zstdReader}, nil
+	} else if bytes.HasPrefix(magic, gXzMagic) {
+		xzReader, err := xz.NewReader(bufReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return xzReader, nil, nil
+	}
+	return bufReader, nil, nil
+}
+
+// StripBinlogCompressSuffix returns the name of binlog as mysql names it, ex: mysql-bin.000123 for mysql-bin.000123.gz
+func StripBinlogCompressSuffix(name string) string {
+	for _, suffix := range GBinlogCompressSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+// FindBinlogFile returns the path of binlog in dir, it is the raw file or the compressed one.
+// the raw file path is returned if none exists
+func FindBinlogFile(dir string, binlog string) string {
+	name := filepath.Join(dir, binlog)
+	if toolkits.IsFile(name) {
+		return name
+	}
+	for _, suffix := range GBinlogCompressSuffixes {
+		if toolkits.IsFile(name + suffix) {
+			return name + suffix
+		}
+	}
+	return name
+}
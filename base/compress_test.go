@@ -0,0 +1,132 @@
+package base
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func testCompress(t *testing.T, format string, data []byte) []byte {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewBinlogReader(t *testing.T) {
+	binlog := append([]byte{}, replication.BinLogFileHeader...)
+	binlog = append(binlog, testFormatDescription()...)
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{"raw", binlog, binlog},
+		{"gzip", testCompress(t, "gzip", binlog), binlog},
+		{"zstd", testCompress(t, "zstd", binlog), binlog},
+		{"xz", testCompress(t, "xz", binlog), binlog},
+		// shorter than the longest magic number, it is read as raw binlog
+		{"short raw", []byte{0xfe, 'b'}, []byte{0xfe, 'b'}},
+	}
+	for _, c := range cases {
+		reader, closer, err := NewBinlogReader(bytes.NewReader(c.data))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !bytes.Equal(data, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, data, c.expected)
+		}
+		if closer != nil {
+			closer.Close()
+		}
+	}
+
+	// gzip magic number with corrupted header
+	if _, _, err := NewBinlogReader(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil {
+		t.Errorf("error expected for corrupted gzip")
+	}
+}
+
+func TestStripBinlogCompressSuffix(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"mysql-bin.000123", "mysql-bin.000123"},
+		{"mysql-bin.000123.gz", "mysql-bin.000123"},
+		{"mysql-bin.000123.zst", "mysql-bin.000123"},
+		{"mysql-bin.000123.zstd", "mysql-bin.000123"},
+		{"mysql-bin.000123.xz", "mysql-bin.000123"},
+		{"/data/binlog/mysql-bin.000123.gz", "/data/binlog/mysql-bin.000123"},
+		// only one suffix is stripped
+		{"mysql-bin.000123.gz.gz", "mysql-bin.000123.gz"},
+		{"mysql-bin.000123.bz2", "mysql-bin.000123.bz2"},
+		{"mysql-bin.index", "mysql-bin.index"},
+	}
+	for _, c := range cases {
+		if name := StripBinlogCompressSuffix(c.name); name != c.expected {
+			t.Errorf("%s: got %s, expected %s", c.name, name, c.expected)
+		}
+	}
+}
+
+func TestFindBinlogFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mysql-bin.000001", "mysql-bin.000001.gz", "mysql-bin.000002.zst", "mysql-bin.000003.xz"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "mysql-bin.000004"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		binlog   string
+		expected string
+	}{
+		// the raw file is preferred to the compressed one
+		{"mysql-bin.000001", "mysql-bin.000001"},
+		{"mysql-bin.000002", "mysql-bin.000002.zst"},
+		{"mysql-bin.000003", "mysql-bin.000003.xz"},
+		// the raw path is returned if none exists, or it is not a file
+		{"mysql-bin.000004", "mysql-bin.000004"},
+		{"mysql-bin.000005", "mysql-bin.000005"},
+	}
+	for _, c := range cases {
+		if path := FindBinlogFile(dir, c.binlog); path != filepath.Join(dir, c.expected) {
+			t.Errorf("%s: got %s, expected %s", c.binlog, path, filepath.Join(dir, c.expected))
+		}
+	}
+}
@@ -1,15 +1,18 @@
 package base
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	constvar "my2sql/constvar"
+	"my2sql/dsql"
 	toolkits "my2sql/toolkits"
 	"github.com/siddontang/go-log/log"
 	"github.com/go-mysql-org/go-mysql/mysql"
@@ -26,6 +29,7 @@ const (
 	C_unknownColPrefix   = "dropped_column_"
 	C_unknownColType     = "unknown_type"
 	C_unknownColTypeCode = mysql.MYSQL_TYPE_NULL
+	C_binaryCollationId  = 63
 
 	C_trxBegin    = 0
 	C_trxCommit   = 1
@@ -45,7 +49,7 @@ var (
 
 	GUseDatabase string = ""
 
-	GOptsValidMode      []string = []string{"repl", "file"}
+	GOptsValidMode      []string = []string{"repl", "file", "relaylog"}
 	GOptsValidWorkType  []string = []string{"2sql", "rollback", "stats"}
 	GOptsValidMysqlType []string = []string{"mysql", "mariadb"}
 	GOptsValidFilterSql []string = []string{"insert", "update", "delete"}
@@ -83,13 +87,12 @@ type ConfCmd struct {
 
 	Databases    []string
 	Tables       []string
-	//DatabaseRegs []*regexp.Regexp
-	//ifHasDbReg   bool
-	//TableRegs    []*regexp.Regexp
-	//ifHasTbReg   bool
 	IgnoreDatabases []string
 	IgnoreTables []string
+	TblFilter    *TableFilter
 	FilterSql    []string
+	WhereExpr    *dsql.WhereExpr
+	Masker       *ColumnMasker
 	FilterSqlLen int
 
 	StartFile         string
@@ -109,9 +112,16 @@ type ConfCmd struct {
 	IfSetStartDateTime bool
 	IfSetStopDateTime  bool
 
-	LocalBinFile string
+	GtidState GtidState
+	RowsQuery string // original sql of the following rows events, logged in ROWS_QUERY_EVENT if binlog_rows_query_log_events=ON
+	StmtVars  []string // SET statements of INTVAR_EVENT and RAND_EVENT before the following statement based dml
+
+	LocalBinFile  string
+	LocalBinFiles []*BinlogFileInfo // -local-binlog-file is a list of binlog files, directories, globs or index files
 
 	OutputToScreen bool
+	OutputFormat   string
+	BinaryEncoding string
 	PrintInterval  int
 	BigTrxRowLimit int
 	LongTrxSeconds int
@@ -124,6 +134,7 @@ type ConfCmd struct {
 	FullColumns    bool
 	InsertRows     int
 	KeepTrx        bool
+	AddGtidNext    bool
 	SqlTblPrefixDb bool
 	FilePerTable   bool
 
@@ -135,6 +146,21 @@ type ConfCmd struct {
 	OnlyColFromFile    bool
 	DumpTblDefToFile   string
 
+	ApplyToDsn          string
+	ApplyBatchSize      int
+	ApplyDryRun         bool
+	ApplyOnError        string
+	ApplyCheckpointFile string
+	OutputSqlFiles      []string // forward/rollback sql files in the order of binlog
+
+	CheckpointFile   string
+	Resume           bool
+	ResumeCheckpoint *ParseCheckpoint
+
+	Follow         bool
+	RotateInterval uint // seconds
+	RotateSize     uint // MB
+
 	BinlogDir string
 
 	GivenBinlogFile string
@@ -157,9 +183,14 @@ type ConfCmd struct {
 	StatFH    *os.File
 	//DdlFH     *os.File
 	BiglongFH *os.File
+	IrreversibleReport *IrreversibleReport // -work-type=rollback, sqls cannot be reversed
 
+	BinlogSyncer   *replication.BinlogSyncer
 	BinlogStreamer *replication.BinlogStreamer
 	FromDB         *sql.DB
+
+	StopCtx  context.Context // canceled on SIGTERM/SIGINT
+	StopFunc context.CancelFunc
 }
 
 func (this *ConfCmd) ParseCmdOptions() {
@@ -171,8 +202,16 @@ func (this *ConfCmd) ParseCmdOptions() {
 		ignoreTbs 		 string
 
 		sqlTypes         string
+		whereStr         string
+		maskRules        string
+		ifMaskKeyCols    bool
+		maskHashSalt     string
 		startTime        string
 		stopTime         string
+		startGtid        string
+		stopGtid         string
+		includeGtids     string
+		excludeGtids     string
 		err              error
 		doNotAddPrifixDb bool
 	)
@@ -182,7 +221,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 	}
 
 	flag.BoolVar(&version, "v", false, "print version")
-	flag.StringVar(&this.Mode, "mode", "repl", StrSliceToString(GOptsValidMode, C_joinSepComma, C_validOptMsg)+". repl: as a slave to get binlogs from master. file: get binlogs from local filesystem. default repl")
+	flag.StringVar(&this.Mode, "mode", "repl", StrSliceToString(GOptsValidMode, C_joinSepComma, C_validOptMsg)+". repl: as a slave to get binlogs from master. file: get binlogs from local filesystem. relaylog: get relay logs of slave from local filesystem, the same as file, positions of master binlog are also reported. default repl")
 	flag.StringVar(&this.WorkType, "work-type", "2sql", StrSliceToString(GOptsValidWorkType, C_joinSepComma, C_validOptMsg)+". 2sql: convert binlog to sqls, rollback: generate rollback sqls, stats: analyze transactions. default: 2sql")
 	flag.StringVar(&this.MysqlType, "mysql-type", "mysql", StrSliceToString(GOptsValidMysqlType, C_joinSepComma, C_validOptMsg)+". server of binlog, mysql or mariadb, default mysql")
 
@@ -192,26 +231,47 @@ func (this *ConfCmd) ParseCmdOptions() {
 	flag.StringVar(&this.Passwd, "password", "", "mysql user password.")
 	flag.UintVar(&this.ServerId, "server-id", 1113306, "this program replicates from mysql as slave to read binlogs. Must set this server id unique from other slaves, default 1113306")
 
-	flag.StringVar(&dbs, "databases", "", "only parse these databases, comma seperated, default all.")
-	flag.StringVar(&tbs, "tables", "", "only parse these tables, comma seperated, DONOT prefix with schema, default all.")
-	flag.StringVar(&ignoreDbs, "ignore-databases", "","ignore parse these databases, comma seperated, default null")
-	flag.StringVar(&ignoreTbs, "ignore-tables", "","ignore parse these tables, comma seperated, default null")
+	flag.StringVar(&dbs, "databases", "", "only parse these databases, comma seperated, default all. Glob(order_db*) and regex enclosed with /(/^tenant_\\d+$/) are supported")
+	flag.StringVar(&tbs, "tables", "", "only parse these tables, comma seperated, default all. Table without schema prefix matches tables of all databases, db.table matches only table of the db. Glob(db1.order_*) and regex enclosed with /(/^tenant_\\d+$/./^order_\\d{4}$/) are supported")
+	flag.StringVar(&ignoreDbs, "ignore-databases", "","ignore parse these databases, comma seperated, default null. Glob and regex are supported as -databases")
+	flag.StringVar(&ignoreTbs, "ignore-tables", "","ignore parse these tables, comma seperated, default null. db.table, glob and regex are supported as -tables")
 	flag.StringVar(&sqlTypes, "sql", "", StrSliceToString(GOptsValidFilterSql, C_joinSepComma, C_validOptMsg)+". only parse these types of sql, comma seperated, valid types are: insert, update, delete; default is all(insert,update,delete)")
+	flag.StringVar(&whereStr, "where", "", "only parse rows matching this expression, evaluated on before and after images of rows. "+
+		"Supports =, !=, <>, <, <=, >, >=, <=>, [NOT] IN (...), IS [NOT] NULL, AND, OR, NOT, changed(col). "+
+		"col is the after image of insert/update and the before image of delete, old.col/new.col is the before/after image. "+
+		"ex: \"tenant_id=42 and changed(status) and new.status='cancelled'\". It applies to 2sql, rollback and stats")
+	flag.StringVar(&maskRules, "mask-rules", "", "mask column values in output, comma seperated rules of [db.][table.]column=strategy, "+
+		"db, table and column can be glob or regex enclosed with / like -tables. strategy: "+strings.Join(GOptsValidMaskStrategy, C_joinSepComma)+
+		". ex: *.users.email=hash,phone=keep-last:4,crm.*.id_no=fixed:***")
+	flag.BoolVar(&ifMaskKeyCols, "mask-key-columns", false, "also mask primary/unique key columns and the other columns used to build where condition, the generated update/delete sqls cannot be applied then. default false")
+	flag.StringVar(&maskHashSalt, "mask-hash-salt", "", "salt prepended to column value for mask strategy hash, hash is sha256 in hex")
 	flag.BoolVar(&this.IgnorePrimaryKeyForInsert, "ignore-primaryKey-forInsert", false, "for insert statement when -workType=2sql, ignore primary key")
 
 	flag.StringVar(&this.StartFile, "start-file", "", "binlog file to start reading")
 	flag.UintVar(&this.StartPos, "start-pos", 4, "start reading the binlog at position")
 	flag.StringVar(&this.StopFile, "stop-file", "", "binlog file to stop reading")
 	flag.UintVar(&this.StopPos, "stop-pos", 4, "Stop reading the binlog at position")
-	flag.StringVar(&this.LocalBinFile, "local-binlog-file", "", "local binlog files to process, It works with -mode=file ")
+	flag.StringVar(&this.LocalBinFile, "local-binlog-file", "", "local binlog files to process, It works with -mode=file. "+
+		"the binlog file to start with, or comma separated list of binlog files, directories, globs and binlog index files. "+
+		"- or a named pipe to read concatenated binlog files from stream, -start-file is the name of the first binlog in it")
 
 	flag.StringVar(&this.BinlogTimeLocation, "tl", "Local", "time location to parse timestamp/datetime column in binlog, such as Asia/Shanghai. default Local")
-	flag.StringVar(&startTime, "start-datetime", "", "Start reading the binlog at first event having a datetime equal or posterior to the argument, it should be like this: \"2020-01-01 01:00:00\"")
+	flag.StringVar(&startTime, "start-datetime", "", "Start reading the binlog at first event having a datetime equal or posterior to the argument, it should be like this: \"2020-01-01 01:00:00\". "+
+		"the binlog to start with is located by the timestamp at the head of binlogs, -start-file is optional with -mode=repl")
 	flag.StringVar(&stopTime, "stop-datetime", "", "Stop reading the binlog at first event having a datetime equal or posterior to the argument, it should be like this: \"2020-12-30 01:00:00\"")
 
+	flag.StringVar(&startGtid, "start-gtid", "", "Start reading the binlog at the transaction with this gtid, ex: 3E11FA47-71CA-11E1-9E33-C80AA9429562:23. If it is a gtid set, start at the first transaction not contained in it. In repl mode, it is used to replicate from mysql with gtid auto-positioning")
+	flag.StringVar(&stopGtid, "stop-gtid", "", "Stop reading the binlog after the transaction with this gtid. If it is a gtid set, stop after all transactions in it are parsed")
+	flag.StringVar(&includeGtids, "include-gtids", "", "only parse transactions in this gtid set, ex: 3E11FA47-71CA-11E1-9E33-C80AA9429562:23-25,3E11FA47-71CA-11E1-9E33-C80AA9429563:1")
+	flag.StringVar(&excludeGtids, "exclude-gtids", "", "do not parse transactions in this gtid set")
+
 	flag.BoolVar(&this.OutputToScreen, "output-toScreen", false, "Just output to screen,do not write to file")
+	flag.StringVar(&this.OutputFormat, "output-format", C_outputFormatSql, StrSliceToString(GOptsValidOutputFormat, C_joinSepComma, C_validOptMsg)+". Works with -work-type=2sql. sql: sqls, jsonl: one json object per row change with database, table, type, before, after, primary_key, binlog, start_pos, end_pos, timestamp, trx_index, gtid. csv|tsv: row images of each table in its own file with the header of columns, the first column is op(insert, delete, update_before, update_after), NULL is \\N. debezium: one debezium change event(before, after, source, op, ts_ms) per row change. canal: one canal flat message per rows event or ddl, id is the index of transaction. default sql")
+	flag.StringVar(&this.BinaryEncoding, "binary-encoding", "hex", StrSliceToString(GOptsValidBinaryEncoding, C_joinSepComma, C_validOptMsg)+". Works with -output-format=csv|tsv. encoding of binary values, such as blob. default hex")
 	flag.BoolVar(&this.PrintExtraInfo, "add-extraInfo", false, "Works with -work-type=2sql|rollback. Print database/table/datetime/binlogposition...info on the line before sql, default false")
 
+	flag.BoolVar(&this.KeepTrx, "keep-trx", false, "Works with -work-type=2sql|rollback. wrap sqls of one transaction with begin/commit as the original transaction. default false")
+	flag.BoolVar(&this.AddGtidNext, "add-gtid-next", false, "Works with -work-type=2sql and -keep-trx. Add SET GTID_NEXT with the original gtid before begin of each transaction, so the gtid history is kept when replaying forward sqls. default false")
 	flag.BoolVar(&this.FullColumns, "full-columns", false, "For update sql, include unchanged columns. for update and delete, use all columns to build where condition.\t\ndefault false, this is, use changed columns to build set part, use primary/unique key to build where condition")
 	flag.BoolVar(&doNotAddPrifixDb, "do-not-add-prifixDb", false, "Prefix table name witch database name in sql,ex: insert into db1.tb1 (x1, x1) values (y1, y1). ")
 	flag.BoolVar(&this.UseUniqueKeyFirst, "U", false, "prefer to use unique key instead of primary key to build where condition for delete/update sql")
@@ -222,6 +282,23 @@ func (this *ConfCmd) ParseCmdOptions() {
 	flag.IntVar(&this.BigTrxRowLimit, "big-trx-row-limit", this.GetDefaultValueOfRange("BigTrxRowLimit"), "transaction with affected rows greater or equal to this value is considerated as big transaction. "+this.GetDefaultAndRangeValueMsg("BigTrxRowLimit"))
 	flag.IntVar(&this.LongTrxSeconds, "long-trx-seconds", this.GetDefaultValueOfRange("LongTrxSeconds"), "transaction with duration greater or equal to this value is considerated as long transaction. "+this.GetDefaultAndRangeValueMsg("LongTrxSeconds"))
 
+	flag.StringVar(&this.ReadTblDefJsonFile, "read-tbl-def-file", "", "read table definitions(columns, primary key, unique keys) from this json file instead of querying mysql. Tables not found in it are still queried from mysql unless -only-tbl-def-from-file is set")
+	flag.BoolVar(&this.OnlyColFromFile, "only-tbl-def-from-file", false, "Works with -read-tbl-def-file. Only use table definitions from the json file, never connect to mysql. default false")
+	flag.StringVar(&this.DumpTblDefToFile, "dump-tbl-def-file", "", "dump table definitions of mysql into this json file and exit, -databases -tables -ignore-databases -ignore-tables are respected. The file can be used by -read-tbl-def-file")
+
+	flag.StringVar(&this.ApplyToDsn, "apply-to", "", "Works with -work-type=2sql|rollback. execute the generated sqls against this mysql after parsing, ex: \"user:password@tcp(127.0.0.1:3306)/?charset=utf8mb4\". Forward sqls are executed in the order of binlog, rollback sqls in the reversed order")
+	flag.IntVar(&this.ApplyBatchSize, "apply-batch-size", 100, "Works with -apply-to. execute this many sqls in one transaction. If -keep-trx is set, sqls of one original transaction are executed in one transaction. default 100")
+	flag.BoolVar(&this.ApplyDryRun, "apply-dry-run", false, "Works with -apply-to. only print the sqls and transactions to apply, do not execute them. default false")
+	flag.StringVar(&this.ApplyOnError, "apply-on-error", "stop", StrSliceToString(GOptsValidApplyOnError, C_joinSepComma, C_validOptMsg)+". Works with -apply-to. stop: rollback the transaction and exit, skip: skip the failed sql and write it into apply_failed.sql. default stop")
+	flag.StringVar(&this.ApplyCheckpointFile, "apply-checkpoint-file", "", "Works with -apply-to. checkpoint file of applying, rerun with the same options to resume an interrupted apply. default apply_checkpoint.json in -output-dir")
+
+	flag.StringVar(&this.CheckpointFile, "checkpoint-file", "", "Works with -work-type=2sql. record the last processed binlog position, gtid set and sql files written into this file at commit of transactions")
+	flag.BoolVar(&this.Resume, "resume", false, "Works with -checkpoint-file. start from the position of checkpoint and append sqls to the sql files of checkpoint. default false")
+
+	flag.BoolVar(&this.Follow, "follow", false, "Works with -mode=repl. keep streaming binlog from master forever, reconnect with backoff when master restarts or the connection is broken. Send SIGTERM/SIGINT to stop it, sqls parsed are flushed before exiting. default false")
+	flag.UintVar(&this.RotateInterval, "rotate-interval", 0, "Works with -work-type=2sql. switch to a new sql file if the current one is opened for this many seconds, ex: forward.N.sql, forward.N.1.sql, forward.N.2.sql. Sqls of one transaction are in the same file. default 0, not rotate by time")
+	flag.UintVar(&this.RotateSize, "rotate-size", 0, "Works with -work-type=2sql. switch to a new sql file if the current one reaches this size in MB. default 0, not rotate by size")
+
 	flag.UintVar(&this.Threads, "threads", uint(this.GetDefaultValueOfRange("Threads")), "Works with -workType=2sql|rollback. threads to run")
 
 	flag.Parse()
@@ -231,8 +308,8 @@ func (this *ConfCmd) ParseCmdOptions() {
 		os.Exit(0)
 	}
 
-	if this.Mode != "repl" && this.Mode != "file" {
-		log.Fatalf("unsupported mode=%s, valid modes: file, repl", this.Mode)
+	if this.Mode != "repl" && !this.IfLocalFileMode() {
+		log.Fatalf("unsupported mode=%s, valid modes: file, repl, relaylog", this.Mode)
 	}
 
 	// check --output-dir
@@ -267,6 +344,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 	if ignoreTbs != "" {
 		this.IgnoreTables = CommaSeparatedListToArray(ignoreTbs)
 	}
+	this.TblFilter = NewTableFilter(this.Databases, this.Tables, this.IgnoreDatabases, this.IgnoreTables)
 
 
 	if sqlTypes != "" {
@@ -279,6 +357,19 @@ func (this *ConfCmd) ParseCmdOptions() {
 		this.FilterSqlLen = 0
 	}
 
+	if maskRules != "" {
+		this.Masker = NewColumnMasker(CommaSeparatedListToArray(maskRules), ifMaskKeyCols, maskHashSalt)
+	} else if ifMaskKeyCols || maskHashSalt != "" {
+		log.Fatalf("-mask-key-columns and -mask-hash-salt must work with -mask-rules")
+	}
+
+	if whereStr != "" {
+		this.WhereExpr, err = dsql.ParseWhereExpr(whereStr)
+		if err != nil {
+			log.Fatalf("invalid -where: %v", err)
+		}
+	}
+
 	GBinlogTimeLocation, err = time.LoadLocation(this.BinlogTimeLocation)
 	if err != nil {
 		log.Fatalf("invalid time location %v"+this.BinlogTimeLocation, err)
@@ -312,9 +403,14 @@ func (this *ConfCmd) ParseCmdOptions() {
 		}
 	}
 
+	this.GtidState.ParseGtidOptions(startGtid, stopGtid, includeGtids, excludeGtids)
+	if this.GtidState.IfSetGtidFilter && this.MysqlType == "mariadb" {
+		log.Fatalf("-start-gtid -stop-gtid -include-gtids -exclude-gtids only support -mysql-type=mysql")
+	}
+
 	if this.StartFile != "" {
 		this.IfSetStartFilePos = true
-		this.StartFilePos = mysql.Position{Name: this.StartFile, Pos: uint32(this.StartPos)}
+		this.StartFilePos = mysql.Position{Name: StripBinlogCompressSuffix(filepath.Base(this.StartFile)), Pos: uint32(this.StartPos)}
 
 	} else {
 		this.IfSetStartFilePos = false
@@ -322,7 +418,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 
 	if this.StopFile != "" {
 		this.IfSetStopFilePos = true
-		this.StopFilePos = mysql.Position{Name: this.StopFile, Pos: uint32(this.StopPos)}
+		this.StopFilePos = mysql.Position{Name: StripBinlogCompressSuffix(filepath.Base(this.StopFile)), Pos: uint32(this.StopPos)}
 		this.IfSetStopParsPoint = true
 
 	} else {
@@ -331,10 +427,91 @@ func (this *ConfCmd) ParseCmdOptions() {
 	}
 
 
-	if this.Mode == "file" {
+	CheckElementOfSliceStr(GOptsValidOutputFormat, this.OutputFormat, "invalid arg for -output-format", true)
+	if this.OutputFormat != C_outputFormatSql {
+		if this.WorkType != "2sql" {
+			log.Fatalf("-output-format=%s only works with -work-type=2sql", this.OutputFormat)
+		}
+		if this.KeepTrx || this.PrintExtraInfo || this.ApplyToDsn != "" {
+			log.Fatalf("-keep-trx -add-extraInfo -apply-to only work with -output-format=sql")
+		}
+	}
+	if this.OutputFormat == C_outputFormatCsv || this.OutputFormat == C_outputFormatTsv {
+		if this.OutputToScreen {
+			log.Fatalf("-output-format=%s cannot work with -output-toScreen", this.OutputFormat)
+		}
+		// columns of tables are different, one file for one table
+		this.FilePerTable = true
+	}
+	CheckElementOfSliceStr(GOptsValidBinaryEncoding, this.BinaryEncoding, "invalid arg for -binary-encoding", true)
+
+	if this.AddGtidNext {
+		if !this.KeepTrx || this.WorkType != "2sql" {
+			log.Fatalf("-add-gtid-next must work with -keep-trx and -work-type=2sql")
+		}
+		if this.FilePerTable {
+			log.Fatalf("-add-gtid-next cannot work with -file-per-table, the same gtid cannot be used in more than one file")
+		}
+	}
+
+	if this.ApplyToDsn != "" {
+		this.CheckApplyOptions()
+	}
+
+	if this.Follow {
+		if this.Mode != "repl" {
+			log.Fatalf("-follow only works with -mode=repl")
+		}
+		if this.WorkType == "rollback" {
+			log.Fatalf("-follow cannot work with -work-type=rollback, rollback sqls are generated after all binlogs are parsed")
+		}
+		if this.ApplyToDsn != "" {
+			log.Fatalf("-follow cannot work with -apply-to")
+		}
+	}
+	if this.RotateInterval > 0 || this.RotateSize > 0 {
+		if this.WorkType != "2sql" || this.OutputToScreen {
+			log.Fatalf("-rotate-interval -rotate-size only work with -work-type=2sql and sqls written into files")
+		}
+	}
+	this.StopCtx, this.StopFunc = context.WithCancel(context.Background())
+
+	if this.Resume && this.CheckpointFile == "" {
+		log.Fatalf("-resume must work with -checkpoint-file")
+	}
+	if this.CheckpointFile != "" {
+		if this.WorkType != "2sql" || this.OutputToScreen {
+			log.Fatalf("-checkpoint-file only works with -work-type=2sql and sqls written into files")
+		}
+		if this.Resume {
+			this.ResumeFromCheckpoint()
+		}
+	}
+
+	if this.OnlyColFromFile && this.ReadTblDefJsonFile == "" {
+		log.Fatalf("-only-tbl-def-from-file must work with -read-tbl-def-file")
+	}
+
+	if this.DumpTblDefToFile != "" {
+		this.CreateDB()
+		err = G_TablesColumnsInfo.DumpTblDefsToJsonFile(this, this.DumpTblDefToFile)
+		if err != nil {
+			log.Fatalf("fail to dump table definitions into %s: %v", this.DumpTblDefToFile, err)
+		}
+		os.Exit(0)
+	}
+
+	if this.IfLocalFileMode() && IfBinlogStream(this.LocalBinFile) {
+		// -start-file is the name of the first binlog in stream, it is optional
+		if this.StartFile != "" {
+			if _, _, ok := GetBinlogSequence(this.StartFile); !ok {
+				log.Fatalf("-start-file=%s is not named as binlog(basename.sequence)", this.StartFile)
+			}
+		}
+	} else if this.IfLocalFileMode() && !IfBinlogFileList(this.LocalBinFile) {
 
 		if this.StartFile == "" {
-			log.Fatalf("missing binlog file.  -start-file must be specify when -mode=file ")
+			log.Fatalf("missing binlog file.  -start-file must be specify when -mode=%s ", this.Mode)
 		}
 		this.GivenBinlogFile = this.StartFile
 		if !toolkits.IsFile(this.GivenBinlogFile) {
@@ -344,9 +521,14 @@ func (this *ConfCmd) ParseCmdOptions() {
 		}
 	}
 
-	if this.Mode == "file" {
+	if this.IfLocalFileMode() && !IfBinlogStream(this.LocalBinFile) {
 	        if this.LocalBinFile == "" {
-	                log.Fatalf("missing binlog file.  -local-binlog-file must be specify when -mode=file ")
+	                log.Fatalf("missing binlog file.  -local-binlog-file must be specify when -mode=%s ", this.Mode)
+	        }
+	        if IfBinlogFileList(this.LocalBinFile) {
+	                // -start-file is the binlog name to start with, it is optional
+	                this.LocalBinFiles = GetBinlogFilesOfList(this.LocalBinFile)
+	                this.LocalBinFile = this.LocalBinFiles[0].Path
 	        }
 	        this.GivenBinlogFile = this.LocalBinFile
 	        if !toolkits.IsFile(this.GivenBinlogFile) {
@@ -363,10 +545,22 @@ func (this *ConfCmd) ParseCmdOptions() {
 	this.StatChan = make(chan BinEventStats, this.Threads*2)
 	this.OpenStatsResultFiles()
 	this.OpenTxResultFiles()
+	if this.WorkType == "rollback" {
+		this.IrreversibleReport = OpenIrreversibleReport(this.OutputDir)
+	}
 
 
 	this.CheckCmdOptions()
-	this.CreateDB()	
+	if this.ReadTblDefJsonFile != "" {
+		err = G_TablesColumnsInfo.ReadTblDefsFromJsonFile(this.ReadTblDefJsonFile)
+		if err != nil {
+			log.Fatalf("fail to read table definitions from %s: %v", this.ReadTblDefJsonFile, err)
+		}
+	} else if !this.IfLocalFileMode() {
+		// in file mode, connect to mysql only when table struct is needed,
+		// it is not needed if binlog_row_metadata=FULL
+		this.CreateDB()
+	}
 
 }
 
@@ -388,10 +582,10 @@ func (this *ConfCmd) CheckCmdOptions() {
 	}*/
 
 	if this.StartFile != "" {
-		this.StartFile = filepath.Base(this.StartFile)
+		this.StartFile = StripBinlogCompressSuffix(filepath.Base(this.StartFile))
 	}
 	if this.StopFile != "" {
-		this.StopFile = filepath.Base(this.StopFile)
+		this.StopFile = StripBinlogCompressSuffix(filepath.Base(this.StopFile))
 	}
 
 	//check --start-binlog --start-pos --stop-binlog --stop-pos
@@ -429,6 +623,29 @@ func (this *ConfCmd) CheckCmdOptions() {
 
 }
 
+func (this *ConfCmd) CheckApplyOptions() {
+	if this.WorkType == "stats" {
+		log.Fatalf("-apply-to only works with -work-type=2sql|rollback")
+	}
+	if this.OutputToScreen || this.FilePerTable {
+		log.Fatalf("-apply-to cannot work with -output-toScreen or -file-per-table")
+	}
+	CheckElementOfSliceStr(GOptsValidApplyOnError, this.ApplyOnError, "invalid arg for -apply-on-error", true)
+	if this.ApplyBatchSize < 1 {
+		log.Fatalf("-apply-batch-size must be greater than 0")
+	}
+	if this.ApplyCheckpointFile == "" {
+		this.ApplyCheckpointFile = filepath.Join(this.OutputDir, "apply_checkpoint.json")
+	}
+	cp, err := ReadApplyCheckpoint(this.ApplyCheckpointFile)
+	if err != nil {
+		log.Fatalf("fail to read apply checkpoint file %s: %v", this.ApplyCheckpointFile, err)
+	}
+	if cp != nil && cp.Finished && !this.ApplyDryRun {
+		log.Fatalf("sqls are applied already according to checkpoint file %s, remove it to apply again", this.ApplyCheckpointFile)
+	}
+}
+
 func (this *ConfCmd) CheckRequiredOption(v interface{}, prefix string, ifExt bool) bool {
 	// options must set, default value is not suitable
 	notOk := false
@@ -488,37 +705,9 @@ func (this *ConfCmd) GetDefaultAndRangeValueMsg(opt string) string {
 	)
 }
 
-/*func (this *ConfCmd) IsTargetTable(db, tb string) bool {
-	dbLower := strings.ToLower(db)
-	tbLower := strings.ToLower(tb)
-	if this.ifHasDbReg {
-		ifMatch := false
-		for _, oneReg := range this.DatabaseRegs {
-			if oneReg.MatchString(dbLower) {
-				ifMatch = true
-				break
-			}
-		}
-		if !ifMatch {
-			return false
-		}
-	}
-
-	if this.ifHasTbReg {
-		ifMatch := false
-		for _, oneReg := range this.TableRegs {
-			if oneReg.MatchString(tbLower) {
-				ifMatch = true
-				break
-			}
-		}
-		if !ifMatch {
-			return false
-		}
-	}
-	return true
-
-}*/
+func (this *ConfCmd) IsTargetTable(db, tb string) bool {
+	return this.TblFilter.IsTargetTable(db, tb)
+}
 
 func (this *ConfCmd) IsTargetDml(dml string) bool {
 	if this.FilterSqlLen < 1 {
@@ -531,13 +720,18 @@ func (this *ConfCmd) IsTargetDml(dml string) bool {
 	}
 }
 
+// IfLocalFileMode returns true if binlogs are read from local files, -mode=file|relaylog
+func (this *ConfCmd) IfLocalFileMode() bool {
+	return this.Mode == "file" || this.Mode == "relaylog"
+}
+
 func (this *ConfCmd) OpenStatsResultFiles() {
 	statFile := filepath.Join(this.OutputDir, "binlog_status.txt")
 	statFH, err := os.OpenFile(statFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		log.Fatalf("fail to open file %v"+statFile, err)
 	}
-	statFH.WriteString(GetStatsPrintHeaderLine(Stats_Result_Header_Column_names))
+	statFH.WriteString(GetStatsPrintHeaderLine(Stats_Result_Header_Column_names, this.Mode == "relaylog"))
 	this.StatFH = statFH
 }
 
@@ -547,7 +741,7 @@ func (this *ConfCmd) OpenTxResultFiles() {
 	if err != nil {
 		log.Fatalf("fail to open file %v"+biglongFile, err)
 	}
-	biglongFH.WriteString(GetBigLongTrxPrintHeaderLine(Stats_BigLongTrx_Header_Column_names))
+	biglongFH.WriteString(GetBigLongTrxPrintHeaderLine(Stats_BigLongTrx_Header_Column_names, this.Mode == "relaylog"))
 	this.BiglongFH = biglongFH
 }
 
@@ -555,6 +749,9 @@ func (this *ConfCmd) OpenTxResultFiles() {
 func (this *ConfCmd) CloseFH(){
 	this.StatFH.Close()
 	this.BiglongFH.Close()
+	if this.IrreversibleReport != nil {
+		this.IrreversibleReport.Close()
+	}
 }
 
 func (this *ConfCmd) CloseChan() {
@@ -0,0 +1,232 @@
+package base
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_debeziumDatetimeLayout = "2006-01-02 15:04:05.999999"
+	C_debeziumDaySeconds     = 86400
+)
+
+// DebeziumSource is the source block of debezium mysql connector
+type DebeziumSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	Gtid      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+	Query     *string `json:"query"` // original sql, null if binlog_rows_query_log_events=OFF
+}
+
+// DebeziumEnvelope is one line of -output-format=debezium, the payload of debezium change event
+// with schemas.enable=false
+type DebeziumEnvelope struct {
+	Before *RowImage      `json:"before"`
+	After  *RowImage      `json:"after"`
+	Source DebeziumSource `json:"source"`
+	Op     string         `json:"op"` // c: insert, u: update, d: delete
+	TsMs   int64          `json:"ts_ms"`
+}
+
+func GetDebeziumOp(sqlType string) string {
+	switch sqlType {
+	case "insert":
+		return "c"
+	case "update":
+		return "u"
+	case "delete":
+		return "d"
+	}
+	return ""
+}
+
+// GetRealColumnType returns the real type of MYSQL_TYPE_STRING column(enum, set, char) from column meta
+func GetRealColumnType(tp byte, meta uint16) byte {
+	if tp == mysql.MYSQL_TYPE_STRING && meta >= 256 {
+		b0 := uint8(meta >> 8)
+		if b0&0x30 != 0x30 {
+			return byte(b0 | 0x30)
+		}
+		return b0
+	}
+	return tp
+}
+
+// GetDebeziumTimeMicros converts [-]hhh:mm:ss[.ffffff] to microseconds
+func GetDebeziumTimeMicros(timeStr string) (int64, error) {
+	var (
+		sign  int64 = 1
+		micro int64 = 0
+	)
+	if strings.HasPrefix(timeStr, "-") {
+		sign = -1
+		timeStr = timeStr[1:]
+	}
+	if idx := strings.Index(timeStr, "."); idx >= 0 {
+		frac := (timeStr[idx+1:] + "000000")[:6]
+		v, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		micro = v
+		timeStr = timeStr[:idx]
+	}
+	arr := strings.Split(timeStr, ":")
+	var seconds int64 = 0
+	for _, part := range arr {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + v
+	}
+	return sign * (seconds*1000000 + micro), nil
+}
+
+// GetDebeziumColumnValue converts column value decoded from binlog into the value of debezium mysql connector
+// with decimal.handling.mode=string, time.precision.mode=adaptive_time_microseconds, binary.handling.mode=base64:
+// date: days since epoch, time: microseconds, datetime: milliseconds(fsp<=3) or microseconds since epoch,
+// timestamp: ISO-8601 string in UTC, bit(1): boolean, blob/binary: base64, zero date: null
+func GetDebeziumColumnValue(v interface{}, col FieldInfo, tp byte, meta uint16) interface{} {
+	if v == nil {
+		return nil
+	}
+	tp = GetRealColumnType(tp, meta)
+	switch tp {
+	case mysql.MYSQL_TYPE_BIT:
+		nbits := (meta>>8)*8 + meta&0xFF
+		if iv, ok := v.(int64); ok && nbits == 1 {
+			return iv != 0
+		}
+	case mysql.MYSQL_TYPE_DATE:
+		str, ok := v.(string)
+		if !ok {
+			break
+		}
+		t, err := time.ParseInLocation("2006-01-02", str, time.UTC)
+		if err != nil {
+			return nil // zero date
+		}
+		return t.Unix() / C_debeziumDaySeconds
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		str, ok := v.(string)
+		if !ok {
+			break
+		}
+		t, err := time.ParseInLocation(C_debeziumDatetimeLayout, str, time.UTC)
+		if err != nil {
+			return nil
+		}
+		if tp == mysql.MYSQL_TYPE_DATETIME2 && meta > 3 {
+			return t.UnixNano() / int64(time.Microsecond)
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		str, ok := v.(string)
+		if !ok {
+			break
+		}
+		t, err := time.ParseInLocation(C_debeziumDatetimeLayout, str, GBinlogTimeLocation)
+		if err != nil {
+			return nil
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		str, ok := v.(string)
+		if !ok {
+			break
+		}
+		micro, err := GetDebeziumTimeMicros(str)
+		if err != nil {
+			log.Errorf("invalid time value %s of column %s: %v", str, col.FieldName, err)
+			return str
+		}
+		return micro
+	case mysql.MYSQL_TYPE_JSON:
+		if bArr, ok := v.([]byte); ok {
+			return string(bArr)
+		}
+	}
+	return v
+}
+
+func GetDebeziumRowImage(ev *MyBinEvent, row []interface{}, colNames []FieldInfo, present []bool) *RowImage {
+	colIdx := GetPresentColumnIdx(present, nil, len(row))
+	image := &RowImage{Names: make([]string, len(colIdx)), Values: make([]interface{}, len(colIdx))}
+	for i, ci := range colIdx {
+		image.Names[i] = colNames[ci].FieldName
+		image.Values[i] = GetDebeziumColumnValue(row[ci], colNames[ci], ev.BinEvent.Table.ColumnType[ci], ev.BinEvent.Table.ColumnMeta[ci])
+	}
+	return image
+}
+
+// GenDebeziumLinesForOneRowsEvent generates one debezium change event for each row of the rows event,
+// the columns absent in the row image are omitted in before and after
+func GenDebeziumLinesForOneRowsEvent(cfg *ConfCmd, ev *MyBinEvent, colNames []FieldInfo, imageCols *RowImageColumns) []string {
+	var (
+		lines  []string
+		step   int = 1
+		rowIdx int = 0
+		gtid   *string
+		query  *string
+	)
+	if ev.SqlType == "update" {
+		step = 2
+	}
+	if ev.Gtid != "" {
+		gtid = &ev.Gtid
+	}
+	if ev.RowsQuery != "" {
+		query = &ev.RowsQuery
+	}
+	for i := 0; i < len(ev.BinEvent.Rows); i += step {
+		envelope := DebeziumEnvelope{
+			Op:   GetDebeziumOp(ev.SqlType),
+			TsMs: time.Now().UnixNano() / int64(time.Millisecond),
+			Source: DebeziumSource{
+				Version:   C_Version,
+				Connector: "mysql",
+				Name:      "my2sql",
+				TsMs:      int64(ev.Timestamp) * 1000,
+				Snapshot:  "false",
+				Db:        string(ev.BinEvent.Table.Schema),
+				Table:     string(ev.BinEvent.Table.Table),
+				Gtid:      gtid,
+				File:      ev.MyPos.Name,
+				Pos:       ev.StartPos,
+				Row:       rowIdx,
+				Query:     query,
+			},
+		}
+		switch ev.SqlType {
+		case "insert":
+			envelope.After = GetDebeziumRowImage(ev, ev.BinEvent.Rows[i], colNames, imageCols.After)
+		case "delete":
+			envelope.Before = GetDebeziumRowImage(ev, ev.BinEvent.Rows[i], colNames, imageCols.Before)
+		case "update":
+			envelope.Before = GetDebeziumRowImage(ev, ev.BinEvent.Rows[i], colNames, imageCols.Before)
+			envelope.After = GetDebeziumRowImage(ev, ev.BinEvent.Rows[i+1], colNames, imageCols.After)
+		}
+		line, err := json.Marshal(&envelope)
+		if err != nil {
+			log.Fatalf("fail to marshal %s row of %s at %s into json: %v", ev.SqlType, GetAbsTableName(envelope.Source.Db, envelope.Source.Table),
+				GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos), err)
+		}
+		lines = append(lines, string(line))
+		rowIdx++
+	}
+	return lines
+}
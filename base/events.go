@@ -17,19 +17,62 @@ import (
 )
 
 type ExtraSqlInfoOfPrint struct {
-	schema    string
-	table     string
-	binlog    string
-	startpos  uint32
-	endpos    uint32
-	datetime  string
-	trxIndex  uint64
-	trxStatus int
+	schema     string
+	table      string
+	binlog     string
+	startpos   uint32
+	endpos     uint32
+	datetime   string
+	trxIndex   uint64
+	trxStatus  int
+	gtid       string
+	rowsQuery  string
+	absentCols string // columns absent in the row image with binlog_row_image=MINIMAL|NOBLOB
+
+	// coordinates in master binlog with -mode=relaylog, binlog/startpos/endpos are those in relay log
+	masterBinlog   string
+	masterStartPos uint32
+	masterStopPos  uint32
 }
 
 type ForwardRollbackSqlOfPrint struct {
 	sqls    []string
 	sqlInfo ExtraSqlInfoOfPrint
+	header  string // -output-format=csv|tsv, header line of the file
+	skipped bool   // the rows event is skipped as its table definition is missing
+}
+
+// MissingTblDefs counts the rows events not output because the table definitions are missing
+type MissingTblDefs struct {
+	lock       sync.Mutex
+	rowsEvents map[string]int // key=db.tb
+}
+
+var GMissingTblDefs *MissingTblDefs = &MissingTblDefs{rowsEvents: map[string]int{}}
+
+func (this *MissingTblDefs) Add(fulltb string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.rowsEvents[fulltb]++
+}
+
+// Report logs the tables whose rows events are skipped, it returns true if any rows event is skipped
+func (this *MissingTblDefs) Report() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for fulltb, cnt := range this.rowsEvents {
+		log.Errorf("table definition missing for %s, %d rows event(s) of it are not in the output", fulltb, cnt)
+	}
+	return len(this.rowsEvents) > 0
+}
+
+// SkipRowsEventOfMissingTblDef skips the rows event whose table definition is missing, the following events
+// are still output in order. the output is incomplete, the program exits with non-zero code at last
+func SkipRowsEventOfMissingTblDef(cfg *ConfCmd, ev *MyBinEvent, fulltb string, err error) {
+	log.Errorf("table definition missing for %s, rows event at %s is not output: %v",
+		fulltb, GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos), err)
+	GMissingTblDefs.Add(fulltb)
+	SendSqlsInEventOrder(cfg, ev.EventIdx, ForwardRollbackSqlOfPrint{sqls: []string{}, skipped: true})
 }
 
 var (
@@ -56,6 +99,11 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 		ifIgnorePrimary    bool = cfg.IgnorePrimaryKeyForInsert
 		currentSqlForPrint ForwardRollbackSqlOfPrint
 		posStr             string
+		header             string
+		imageCols          *RowImageColumns
+		renderEv           MyBinEvent // ev with masked rows
+		absentCols         string
+		irreversibleReason string
 		//printStatementSql  bool = false
 	)
 	log.Infof(fmt.Sprintf("start thread %d to generate redo/rollback sql", i))
@@ -65,27 +113,43 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 
 	for ev := range cfg.EventChan {
 		if !ev.IfRowsEvent {
+			if ev.QuerySql != nil && cfg.OutputFormat == C_outputFormatCanal {
+				db, tb, sqlArr = GenCanalLinesForQuery(&ev)
+				SendSqlsInEventOrder(cfg, ev.EventIdx, ForwardRollbackSqlOfPrint{sqls: sqlArr,
+					sqlInfo: ExtraSqlInfoOfPrint{schema: db, table: tb, binlog: ev.MyPos.Name, startpos: ev.StartPos, endpos: ev.MyPos.Pos,
+						trxIndex: ev.TrxIndex, trxStatus: ev.TrxStatus, gtid: ev.Gtid,
+						masterBinlog: ev.MasterPos.Name, masterStartPos: ev.MasterStartPos, masterStopPos: ev.MasterPos.Pos}})
+			} else if ev.QuerySql != nil && ev.QuerySql.IsDml() {
+				// statement based dml, only sent with -work-type=2sql -output-format=sql
+				SendSqlsInEventOrder(cfg, ev.EventIdx, ForwardRollbackSqlOfPrint{sqls: GenSqlsForStatement(&ev),
+					sqlInfo: ExtraSqlInfoOfPrint{schema: ev.QuerySql.Tables[0].Database, table: ev.QuerySql.Tables[0].Table,
+						binlog: ev.MyPos.Name, startpos: ev.StartPos, endpos: ev.MyPos.Pos,
+						datetime: GetDatetimeStr(int64(ev.Timestamp), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
+						trxIndex: ev.TrxIndex, trxStatus: ev.TrxStatus, gtid: ev.Gtid,
+						masterBinlog: ev.MasterPos.Name, masterStartPos: ev.MasterStartPos, masterStopPos: ev.MasterPos.Pos}})
+			} else if ev.TrxStatus == C_trxCommit {
+				// transaction commits, the writer records checkpoint
+				SendSqlsInEventOrder(cfg, ev.EventIdx, ForwardRollbackSqlOfPrint{sqls: []string{},
+					sqlInfo: ExtraSqlInfoOfPrint{binlog: ev.MyPos.Name, startpos: ev.StartPos, endpos: ev.MyPos.Pos,
+						trxIndex: ev.TrxIndex, trxStatus: ev.TrxStatus, gtid: ev.Gtid,
+						masterBinlog: ev.MasterPos.Name, masterStartPos: ev.MasterStartPos, masterStopPos: ev.MasterPos.Pos}})
+			}
 			continue
 		}
 		posStr = GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos)
 		db = string(ev.BinEvent.Table.Schema)
 		tb = string(ev.BinEvent.Table.Table)
 		fulltb = GetAbsTableName(db, tb)
-		tbInfo, err = G_TablesColumnsInfo.GetTableInfoJson(db, tb)
+		colCnt = len(ev.BinEvent.Rows[0])
+		tbInfo, err = GetTblInfoForRowsEvent(ev.BinEvent, ev.MyPos)
 		if err != nil {
-			log.Errorf(fmt.Sprintf("error to found %s table structure for event", fulltb))
+			SkipRowsEventOfMissingTblDef(cfg, &ev, fulltb, err)
 			continue
 		}
-		if tbInfo == nil {
-			log.Errorf("no suitable table struct found for %s for event %s", fulltb, posStr)
-		}
-		colCnt = len(ev.BinEvent.Rows[0])
 		allColNames = GetAllFieldNamesWithDroppedFields(colCnt, tbInfo.Columns)
 		colsDef, colsTypeName = GetSqlFieldsEXpressions(colCnt, allColNames, ev.BinEvent.Table)
+		ConvertEnumSetToStr(ev.BinEvent, colsDef, allColNames)
 		colsTypeNameFromMysql := make([]string, len(colsTypeName))
-		if len(colsTypeName) > len(tbInfo.Columns) {
-			log.Fatalf("%s column count %d in binlog > in table structure %d, usually means DDL in the middle", fulltb, len(colsTypeName), len(tbInfo.Columns))
-		}
 		for ci, colType := range colsTypeName {
 			colsTypeNameFromMysql[ci] = tbInfo.Columns[ci].FieldType
 
@@ -143,57 +207,132 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 			ifIgnorePrimary = false
 		}
 
-		if ev.SqlType == "insert" {
+		imageCols = GetRowImageColumns(ev.BinEvent, ev.SqlType, colCnt)
+		absentCols = ""
+		irreversibleReason = ""
+		if (cfg.OutputFormat == C_outputFormatCsv || cfg.OutputFormat == C_outputFormatTsv) && !imageCols.IfFull() {
+			// every line has all columns, the absent ones cannot be told from NULL, nor the json diffs from json value
+			log.Fatalf("%s at %s: binlog_row_image is not FULL or binlog_row_value_options is PARTIAL_JSON, columns %s are absent or logged as json diffs in the row image, "+
+				"-output-format=%s needs the full row image, please use -output-format=sql|jsonl|debezium|canal, which omit the absent columns",
+				fulltb, posStr, imageCols.GetAbsentColumnsStr(allColNames), cfg.OutputFormat)
+		}
+		if cfg.OutputFormat != C_outputFormatSql && (imageCols.Before != nil || imageCols.After != nil) {
+			WarnRowImageOnce(fulltb, fmt.Sprintf("binlog_row_image is not FULL, the columns absent in the row image are omitted in %s", cfg.OutputFormat))
+		}
+		if cfg.OutputFormat != C_outputFormatSql && imageCols.PartialJson != nil {
+			WarnRowImageOnce(fulltb, fmt.Sprintf("binlog_row_value_options is PARTIAL_JSON, json columns logged as diffs are omitted in the after image of %s", cfg.OutputFormat))
+			imageCols.OmitPartialJson()
+		}
+		if cfg.OutputFormat == C_outputFormatSql && !imageCols.IfFull() {
+			absentCols = imageCols.GetAbsentColumnsStr(allColNames)
+			if imageCols.Before != nil || imageCols.After != nil {
+				WarnRowImageOnce(fulltb, "binlog_row_image is not FULL, sql is generated with the columns present in the row image only")
+			}
+			if imageCols.PartialJson != nil {
+				WarnRowImageOnce(fulltb, "binlog_row_value_options is PARTIAL_JSON, json columns logged as diffs are updated by json functions")
+			}
 			if ifRollback {
-				sqlArr = GenDeleteSqlsForOneRowsEventRollbackInsert(posStr, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, cfg.SqlTblPrefixDb)
+				irreversibleReason = imageCols.GetIrreversibleReason(ev.SqlType, allColNames)
+			}
+		}
+
+		// the columns to set and to build where condition are chosen by the rows not masked, masked values are only output
+		renderEv = ev
+		if cfg.Masker != nil {
+			protectedIdx := append(append([]int{}, uniqueKeyIdx...), primaryKeyIdx...)
+			if cfg.OutputFormat == C_outputFormatSql {
+				protectedIdx = append(protectedIdx, GetWhereColumnIdx(ev.SqlType, ifRollback && irreversibleReason == "", colCnt,
+					uniqueKeyIdx, cfg.FullColumns, imageCols)...)
+			}
+			renderEv.BinEvent = cfg.Masker.MaskRowsEvent(&ev, allColNames, protectedIdx)
+		}
+
+		header = ""
+		if cfg.OutputFormat == C_outputFormatCsv || cfg.OutputFormat == C_outputFormatTsv {
+			header, sqlArr = GenCsvLinesForOneRowsEvent(cfg, &renderEv, allColNames, colsTypeName)
+		} else if cfg.OutputFormat == C_outputFormatCanal {
+			sqlArr = GenCanalLinesForOneRowsEvent(cfg, &ev, renderEv.BinEvent.Rows, tbInfo, allColNames, colsTypeName, imageCols)
+		} else if cfg.OutputFormat == C_outputFormatDebezium {
+			sqlArr = GenDebeziumLinesForOneRowsEvent(cfg, &renderEv, allColNames, imageCols)
+		} else if cfg.OutputFormat != C_outputFormatSql {
+			if len(primaryKeyIdx) > 0 {
+				sqlArr = GenRowChangeLinesForOneRowsEvent(cfg, &renderEv, allColNames, colsTypeName, primaryKeyIdx, imageCols)
+			} else {
+				sqlArr = GenRowChangeLinesForOneRowsEvent(cfg, &renderEv, allColNames, colsTypeName, uniqueKeyIdx, imageCols)
+			}
+		} else if irreversibleReason != "" {
+			// the original values cannot be restored, the forward sqls are written into the report instead
+			WarnRowImageOnce(fulltb, fmt.Sprintf("%s cannot be reversed, %s. see %s", ev.SqlType, irreversibleReason, C_irreversibleFileName))
+			if ev.SqlType == "delete" {
+				sqlArr = GenDeleteSqlsForOneRowsEvent(posStr, renderEv.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb, imageCols)
 			} else {
-				sqlArr = GenInsertSqlsForOneRowsEvent(posStr, ev.BinEvent, colsDef, 1, false, cfg.SqlTblPrefixDb, ifIgnorePrimary, primaryKeyIdx)
+				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, renderEv.BinEvent.Rows, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb, imageCols)
+			}
+			for _, oneSql := range sqlArr {
+				cfg.IrreversibleReport.Add(&ev, ev.Timestamp, db, tb, irreversibleReason, oneSql)
+			}
+			sqlArr = []string{}
+		} else if ev.SqlType == "insert" {
+			if ifRollback {
+				sqlArr = GenDeleteSqlsForOneRowsEventRollbackInsert(posStr, renderEv.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, cfg.SqlTblPrefixDb, imageCols)
+			} else {
+				sqlArr = GenInsertSqlsForOneRowsEvent(posStr, renderEv.BinEvent, colsDef, 1, false, cfg.SqlTblPrefixDb, ifIgnorePrimary, primaryKeyIdx, imageCols.After)
 			}
 		} else if ev.SqlType == "delete" {
 			if ifRollback {
-				sqlArr = GenInsertSqlsForOneRowsEventRollbackDelete(posStr, ev.BinEvent, colsDef, 1, cfg.SqlTblPrefixDb)
+				sqlArr = GenInsertSqlsForOneRowsEventRollbackDelete(posStr, renderEv.BinEvent, colsDef, 1, cfg.SqlTblPrefixDb, imageCols)
 			} else {
-				sqlArr = GenDeleteSqlsForOneRowsEvent(posStr, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb)
+				sqlArr = GenDeleteSqlsForOneRowsEvent(posStr, renderEv.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb, imageCols)
 			}
 		} else if ev.SqlType == "update" {
 			if ifRollback {
-				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, true, cfg.SqlTblPrefixDb)
+				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, renderEv.BinEvent.Rows, colsDef, uniqueKeyIdx, cfg.FullColumns, true, cfg.SqlTblPrefixDb, imageCols)
 			} else {
-				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb)
+				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, renderEv.BinEvent.Rows, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb, imageCols)
 			}
 		} else {
-			fmt.Println("unsupported query type %s to generate 2sql|rollback sql, it should one of insert|update|delete. %s", ev.SqlType, ev.MyPos.String())
+			fmt.Printf("unsupported query type %s to generate 2sql|rollback sql, it should one of insert|update|delete. %s\n", ev.SqlType, ev.MyPos.String())
 			continue
 		}
 		currentSqlForPrint = ForwardRollbackSqlOfPrint{sqls: sqlArr,
 			sqlInfo: ExtraSqlInfoOfPrint{schema: db, table: tb, binlog: ev.MyPos.Name, startpos: ev.StartPos, endpos: ev.MyPos.Pos,
 				datetime: GetDatetimeStr(int64(ev.Timestamp), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
-				trxIndex: ev.TrxIndex, trxStatus: ev.TrxStatus}}
-
-		for {
-			//fmt.Println("in thread", i)
-			G_HandlingBinEventIndex.lock.Lock()
-			//fmt.Println("handing index:", G_HandlingBinEventIndex.EventIdx, "binevent index:", ev.EventIdx)
-			if G_HandlingBinEventIndex.EventIdx == ev.EventIdx {
-				if cfg.OutputToScreen {
-					for _, sql := range currentSqlForPrint.sqls {
-						fmt.Println(sql)
-					}
-				} else {
-					cfg.SqlChan <- currentSqlForPrint
+				trxIndex: ev.TrxIndex, trxStatus: ev.TrxStatus, gtid: ev.Gtid, rowsQuery: ev.RowsQuery, absentCols: absentCols,
+				masterBinlog: ev.MasterPos.Name, masterStartPos: ev.MasterStartPos, masterStopPos: ev.MasterPos.Pos}, header: header}
+
+		SendSqlsInEventOrder(cfg, ev.EventIdx, currentSqlForPrint)
+	}
+	log.Infof(fmt.Sprintf("exit thread %d to generate redo/rollback sql", i))
+}
+
+// SendSqlsInEventOrder prints or sends sqls to the writer in the order of binlog events
+func SendSqlsInEventOrder(cfg *ConfCmd, eventIdx uint64, currentSqlForPrint ForwardRollbackSqlOfPrint) {
+	for {
+		//fmt.Println("in thread", i)
+		G_HandlingBinEventIndex.lock.Lock()
+		//fmt.Println("handing index:", G_HandlingBinEventIndex.EventIdx, "binevent index:", ev.EventIdx)
+		if G_HandlingBinEventIndex.EventIdx == eventIdx {
+			if cfg.OutputToScreen {
+				if cfg.KeepTrx && len(currentSqlForPrint.sqls) > 0 {
+					fmt.Print(GetTrxBeginCommitSql(cfg, G_HandlingBinEventIndex.LastPrintTrx, currentSqlForPrint.sqlInfo))
+					G_HandlingBinEventIndex.LastPrintTrx = &currentSqlForPrint.sqlInfo
+				}
+				for _, sql := range currentSqlForPrint.sqls {
+					fmt.Println(sql)
 				}
-				G_HandlingBinEventIndex.EventIdx++
-				G_HandlingBinEventIndex.lock.Unlock()
-				//fmt.Println("handing index == binevent index, break")
-				break
+			} else {
+				cfg.SqlChan <- currentSqlForPrint
 			}
-
+			G_HandlingBinEventIndex.EventIdx++
 			G_HandlingBinEventIndex.lock.Unlock()
-			time.Sleep(1 * time.Microsecond)
-
+			//fmt.Println("handing index == binevent index, break")
+			break
 		}
+
+		G_HandlingBinEventIndex.lock.Unlock()
+		time.Sleep(1 * time.Microsecond)
+
 	}
-	log.Infof(fmt.Sprintf("exit thread %d to generate redo/rollback sql", i))
 }
 
 func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
@@ -201,6 +340,7 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 	var (
 		rollbackFileName string                   = ""
 		tmpFileName      string                   = ""
+		baseFileName     string                   = ""
 		oneSqls          string                   = ""
 		fhArr            map[string]*os.File      = map[string]*os.File{}
 		fhArrBuf         map[string]*bufio.Writer = map[string]*bufio.Writer{}
@@ -214,17 +354,57 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 		//trxCommitStr string = "commit;\n"
 		// trxCommitStrLen int = len(trxCommitStr)
 		bytesCntFiles      map[string][][]int = map[string][][]int{} //{"file1":{{8, 0}, {8 , 0}}} {length of bytes, trxIndex}
+		lastTrxFiles       map[string]*ExtraSqlInfoOfPrint = map[string]*ExtraSqlInfoOfPrint{} // works with -keep-trx, last transaction written into forward sql files
 		lastPrintPos       uint32             = 0
 		lastPrintFile      string             = ""
 		printBytesInterval uint32             = 1024 * 1024 * 10 //every 10MB print process info
+		cpWriter           *ParseCheckpointWriter
+		rotator            *SqlFileRotator = NewSqlFileRotator(cfg) // works with -rotate-interval -rotate-size
+		fileHeaders        map[string]string = map[string]string{} // works with -output-format=csv|tsv
 	)
 	log.Infof(fmt.Sprintf("start thread to write redo/rollback sql into file"))
+	if cfg.CheckpointFile != "" {
+		cpWriter = NewParseCheckpointWriter(cfg, rotator)
+		cpWriter.ReopenSqlFiles(fhArr, fhArrBuf)
+	}
 	for sc := range cfg.SqlChan {
+		if sc.skipped {
+			// the output is incomplete from here, checkpoint is not saved any more so that it is parsed again
+			if cpWriter != nil {
+				log.Errorf("checkpoint is not saved after the rows event with table definition missing")
+				cpWriter = nil
+			}
+			continue
+		}
+		if len(sc.sqls) == 0 {
+			// commit of transaction
+			if cpWriter != nil {
+				cpWriter.Save(&sc.sqlInfo, fhArr, fhArrBuf, lastTrxFiles, false)
+			}
+			continue
+		}
 		if cfg.WorkType == "rollback" {
 			tmpFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, true, sc.sqlInfo.binlog, true)
 			rollbackFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, true, sc.sqlInfo.binlog, false)
 		} else {
-			tmpFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, false, sc.sqlInfo.binlog, false)
+			baseFileName = GetOutputFileName(cfg, GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, false, sc.sqlInfo.binlog, false))
+			tmpFileName = rotator.GetFile(baseFileName)
+			// columns of the table changed, write rows into a new file with the new header
+			headerChanged := sc.header != "" && fileHeaders[tmpFileName] != "" && fileHeaders[tmpFileName] != sc.header
+			if headerChanged || rotator.IfRotate(baseFileName, sc.sqlInfo) {
+				if lastTrx, ok := lastTrxFiles[tmpFileName]; ok {
+					fhArrBuf[tmpFileName].WriteString(GetTrxCommitSql(cfg, lastTrx))
+					delete(lastTrxFiles, tmpFileName)
+				}
+				err = fhArrBuf[tmpFileName].Flush()
+				if err != nil {
+					log.Fatalf("fail to write %s: %v", tmpFileName, err)
+				}
+				fhArr[tmpFileName].Close()
+				delete(fhArrBuf, tmpFileName)
+				delete(fhArr, tmpFileName)
+				tmpFileName = rotator.Rotate(baseFileName)
+			}
 		}
 		if _, ok := fhArr[tmpFileName]; !ok {
 			FH, err = os.OpenFile(tmpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
@@ -237,12 +417,32 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 			if cfg.WorkType == "rollback" {
 				rollbackFiles = append(rollbackFiles, map[string]string{"tmp": tmpFileName, "rollback": rollbackFileName})
 				bytesCntFiles[tmpFileName] = [][]int{}
+				cfg.OutputSqlFiles = append(cfg.OutputSqlFiles, rollbackFileName)
+			} else {
+				cfg.OutputSqlFiles = append(cfg.OutputSqlFiles, tmpFileName)
+			}
+			if sc.header != "" {
+				bufFH.WriteString(sc.header + "\n")
+				fileHeaders[tmpFileName] = sc.header
 			}
 		}
 
 		//lastTrxIndex = sc.sqlInfo.trxIndex
-		oneSqls = GetForwardRollbackContentLineWithExtra(sc, cfg.PrintExtraInfo)
+		if cfg.OutputFormat == C_outputFormatSql {
+			oneSqls = GetForwardRollbackContentLineWithExtra(sc, cfg.PrintExtraInfo)
+		} else {
+			oneSqls = strings.Join(sc.sqls, "\n") + "\n"
+		}
+		if cfg.KeepTrx && cfg.WorkType != "rollback" {
+			// rollback sqls are wrapped with begin/commit when reverting tmp files
+			oneSqls = GetTrxBeginCommitSql(cfg, lastTrxFiles[tmpFileName], sc.sqlInfo) + oneSqls
+			lastSqlInfo := sc.sqlInfo
+			lastTrxFiles[tmpFileName] = &lastSqlInfo
+		}
 		fhArrBuf[tmpFileName].WriteString(oneSqls)
+		if cfg.WorkType != "rollback" {
+			rotator.AddWritten(baseFileName, sc.sqlInfo, len(oneSqls))
+		}
 		if lastPrintFile == "" {
 			lastPrintFile = sc.sqlInfo.binlog
 		}
@@ -260,6 +460,19 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 		}
 	}
 
+	if cfg.KeepTrx && cfg.WorkType != "rollback" {
+		for fn, lastTrx := range lastTrxFiles {
+			fhArrBuf[fn].WriteString(GetTrxCommitSql(cfg, lastTrx))
+		}
+		if cfg.OutputToScreen && G_HandlingBinEventIndex.LastPrintTrx != nil {
+			fmt.Print(GetTrxCommitSql(cfg, G_HandlingBinEventIndex.LastPrintTrx))
+		}
+		lastTrxFiles = map[string]*ExtraSqlInfoOfPrint{}
+	}
+	if cpWriter != nil {
+		cpWriter.Save(nil, fhArr, fhArrBuf, lastTrxFiles, true)
+	}
+
 	for fn, bufFH := range fhArrBuf {
 		bufFH.Flush()
 		fhArr[fn].Close()
@@ -320,9 +533,23 @@ func GetForwardRollbackSqlFileName(schema string, table string, filePerTable boo
 
 func GetForwardRollbackContentLineWithExtra(sq ForwardRollbackSqlOfPrint, ifExtra bool) string {
 	if ifExtra {
-		return fmt.Sprintf("# datetime=%s database=%s table=%s binlog=%s startpos=%d stoppos=%d\n%s;\n",
+		var rowsQuery string = ""
+		if sq.sqlInfo.rowsQuery != "" {
+			// every line of the original sql is commented
+			rowsQuery = "# rows_query=" + strings.Replace(sq.sqlInfo.rowsQuery, "\n", "\n# ", -1) + "\n"
+		}
+		if sq.sqlInfo.absentCols != "" {
+			rowsQuery += "# absent_columns=" + sq.sqlInfo.absentCols + "\n"
+		}
+		var masterPos string = ""
+		if sq.sqlInfo.masterBinlog != "" {
+			// -mode=relaylog
+			masterPos = fmt.Sprintf(" master_binlog=%s master_startpos=%d master_stoppos=%d",
+				sq.sqlInfo.masterBinlog, sq.sqlInfo.masterStartPos, sq.sqlInfo.masterStopPos)
+		}
+		return fmt.Sprintf("# datetime=%s database=%s table=%s binlog=%s startpos=%d stoppos=%d%s\n%s%s;\n",
 			sq.sqlInfo.datetime, sq.sqlInfo.schema, sq.sqlInfo.table, sq.sqlInfo.binlog, sq.sqlInfo.startpos,
-			sq.sqlInfo.endpos, strings.Join(sq.sqls, ";\n"))
+			sq.sqlInfo.endpos, masterPos, rowsQuery, strings.Join(sq.sqls, ";\n"))
 	} else {
 
 		str := strings.Join(sq.sqls, ";\n") + ";\n"
@@ -330,3 +557,26 @@ func GetForwardRollbackContentLineWithExtra(sq ForwardRollbackSqlOfPrint, ifExtr
 	}
 
 }
+
+// GetTrxBeginCommitSql returns the commit of the last transaction and the begin of the current transaction
+// if the sqls belong to a new transaction. lastTrx is nil if no sql is written before
+func GetTrxBeginCommitSql(cfg *ConfCmd, lastTrx *ExtraSqlInfoOfPrint, sqlInfo ExtraSqlInfoOfPrint) string {
+	var trxSql string = ""
+	if lastTrx != nil {
+		if lastTrx.trxIndex == sqlInfo.trxIndex && lastTrx.binlog == sqlInfo.binlog {
+			return trxSql
+		}
+		trxSql = GetTrxCommitSql(cfg, lastTrx)
+	}
+	if cfg.AddGtidNext && sqlInfo.gtid != "" {
+		trxSql += GetGtidNextSql(sqlInfo.gtid) + ";\n"
+	}
+	return trxSql + "begin;\n"
+}
+
+func GetTrxCommitSql(cfg *ConfCmd, lastTrx *ExtraSqlInfoOfPrint) string {
+	if cfg.AddGtidNext && lastTrx.gtid != "" {
+		return "commit;\nSET GTID_NEXT='AUTOMATIC';\n"
+	}
+	return "commit;\n"
+}
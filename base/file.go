@@ -2,8 +2,8 @@ package base
 
 import (
 	"fmt"
-	"os"
 	"io"
+	"os"
 	"bytes"
 	"strings"
 	"path/filepath"
@@ -23,20 +23,42 @@ var (
 
 
 type BinFileParser struct {
-	Parser *replication.BinlogParser
+	Parser        *replication.BinlogParser
+	Mysql8Parser *Mysql8EventParser // works with binlog_transaction_compression=ON and binlog_row_value_options=PARTIAL_JSON
+	RelayLog     *RelayLogState     // works with -mode=relaylog
 }
 
 
 func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 	defer cfg.CloseChan()
 	log.Info("start to parse binlog from local files")
+	if IfBinlogStream(cfg.LocalBinFile) {
+		this.MyParseBinlogStream(cfg)
+		log.Info("finish parsing binlog from stream")
+		return
+	}
+	if len(cfg.LocalBinFiles) > 0 {
+		this.MyParseBinlogFileList(cfg)
+		log.Info("finish parsing binlog from local files")
+		return
+	}
 	binlog, binpos := GetFirstBinlogPosToParse(cfg)
-	binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(binlog)
+	if IfLocateStartBinlog(cfg) {
+		// without stop position or stop datetime only one binlog is parsed, it is the located one
+		if located := LocateStartBinlogFile(cfg, binlog); located != binlog {
+			if !cfg.IfSetStopParsPoint && !cfg.IfSetStopDateTime {
+				log.Infof("%s is located as the binlog to start with, only it is parsed without -stop-* or -stop-datetime", located)
+			}
+			binlog = located
+			binpos = 4
+		}
+	}
+	binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(StripBinlogCompressSuffix(binlog))
 	log.Info(fmt.Sprintf("start to parse %s %d\n", binlog, binpos))
 
 	for {
 		if cfg.IfSetStopFilePos {
-			if cfg.StopFilePos.Compare(mysql.Position{Name: filepath.Base(binlog), Pos: 4}) < 1 {
+			if cfg.StopFilePos.Compare(mysql.Position{Name: StripBinlogCompressSuffix(filepath.Base(binlog)), Pos: 4}) < 1 {
 				break
 			}
 		}
@@ -55,7 +77,8 @@ func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 				//just parse one binlog
 				break
 			}
-			binlog = filepath.Join(cfg.BinlogDir, GetNextBinlog(binBaseName, binBaseIndx))
+			// the next binlog may be compressed
+			binlog = FindBinlogFile(cfg.BinlogDir, GetNextBinlog(binBaseName, binBaseIndx))
 			if !toolkits.IsFile(binlog) {
 				log.Info(fmt.Sprintf("%s not exists nor a file\n", binlog))
 				break
@@ -72,21 +95,132 @@ func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 
 }
 
-func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int, error) {
-	// process: 0, continue: 1, break: 2
-	f, err := os.Open(name)
-	if f != nil {
+// MyParseBinlogFileList parses the binlog files sorted by sequence as one continuous stream, the files before
+// -start-file and after -stop-file are skipped
+func (this BinFileParser) MyParseBinlogFileList(cfg *ConfCmd) {
+	var binFiles []*BinlogFileInfo
+	for _, binFile := range cfg.LocalBinFiles {
+		if cfg.IfSetStartFilePos && mysql.CompareBinlogFileName(binFile.Name, cfg.StartFilePos.Name) < 0 {
+			continue
+		}
+		if cfg.IfSetStopFilePos && cfg.StopFilePos.Compare(mysql.Position{Name: binFile.Name, Pos: 4}) < 1 {
+			break
+		}
+		binFiles = append(binFiles, binFile)
+	}
+	if IfLocateStartBinlog(cfg) && len(binFiles) > 1 {
+		var paths []string
+		for _, binFile := range binFiles {
+			paths = append(paths, binFile.Path)
+		}
+		binFiles = binFiles[LocateStartBinlog(cfg, paths, ReadBinlogFileHead):]
+	}
+
+	for _, binFile := range binFiles {
+		log.Info(fmt.Sprintf("start to parse %s\n", binFile.Path))
+		result, err := this.MyParseOneBinlogFile(cfg, binFile.Path)
+		if err != nil {
+			log.Error(fmt.Sprintf("error to parse binlog %s %v", binFile.Path, err))
+			break
+		}
+		if result == C_reBreak {
+			break
+		} else if result != C_reFileEnd {
+			log.Info(fmt.Sprintf("this should not happen: return value of MyParseOneBinlog is %d\n", result))
+			break
+		}
+	}
+}
+
+// LocateStartBinlogFile returns the binlog file to start with among -start-file and the binlog files after it
+// in the same directory, which are parsed one by one until stop position or stop datetime
+func LocateStartBinlogFile(cfg *ConfCmd, binlog string) string {
+	binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(StripBinlogCompressSuffix(binlog))
+	var binlogs []string
+	for toolkits.IsFile(binlog) {
+		if cfg.IfSetStopFilePos && cfg.StopFilePos.Compare(mysql.Position{Name: StripBinlogCompressSuffix(filepath.Base(binlog)), Pos: 4}) < 1 {
+			break
+		}
+		binlogs = append(binlogs, binlog)
+		binlog = FindBinlogFile(cfg.BinlogDir, GetNextBinlog(binBaseName, binBaseIndx))
+		binBaseIndx++
+	}
+	if len(binlogs) == 0 {
+		return binlog
+	}
+	return binlogs[LocateStartBinlog(cfg, binlogs, ReadBinlogFileHead)]
+}
+
+// MyParseBinlogStream parses the binlog files concatenated in stdin or named pipe. the binlog name is changed by
+// ROTATE_EVENT, or it is the next one by sequence if a binlog file ends without ROTATE_EVENT
+func (this BinFileParser) MyParseBinlogStream(cfg *ConfCmd) {
+	var src io.Reader = os.Stdin
+	if cfg.LocalBinFile != C_binlogStdin {
+		f, err := os.Open(cfg.LocalBinFile)
+		if err != nil {
+			log.Errorf("fail to open %s %v", cfg.LocalBinFile, err)
+			return
+		}
 		defer f.Close()
+		src = f
+	}
+	stream, err := NewBinlogStreamReader(src)
+	if err != nil {
+		log.Errorf("fail to read binlog stream %s %v", cfg.LocalBinFile, err)
+		return
+	}
+	defer stream.Close()
+
+	var binlog string = cfg.StartFile
+	if binlog == "" {
+		binlog = C_stdinBinlogName
+		log.Infof("-start-file is not set, the first binlog in stream is named as %s until ROTATE_EVENT", binlog)
+	}
+	for {
+		if err = stream.ReadBinlogMagic(); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("fail to read binlog stream after %s %v", binlog, err)
+			break
+		}
+		if this.RelayLog != nil {
+			this.RelayLog.StartRelayLog()
+		}
+
+		log.Info(fmt.Sprintf("start to parse %s from stream\n", binlog))
+		lastBinlog := binlog
+		result, err := this.MyParseReader(cfg, stream, &binlog)
+		if err != nil {
+			log.Error(fmt.Sprintf("error to parse binlog %s %v", binlog, err))
+			break
+		}
+		if result == C_reBreak {
+			break
+		} else if result != C_reFileEnd {
+			log.Info(fmt.Sprintf("this should not happen: return value of MyParseReader is %d\n", result))
+			break
+		}
+		if binlog == lastBinlog {
+			// no ROTATE_EVENT at the end of binlog, ex: mysqld crashed, or relay log whose ROTATE_EVENT is skipped
+			binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(binlog)
+			binlog = GetNextBinlog(binBaseName, binBaseIndx)
+		}
 	}
+}
+
+func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int, error) {
+	// process: 0, continue: 1, break: 2
+	f, err := OpenBinlogFile(name)
 	if err != nil {
 		log.Error(fmt.Sprintf("fail to open %s %v\n", name, err))
 		return C_reBreak, errors.Trace(err)
 	}
+	defer f.Close()
 
 	fileTypeBytes := int64(4)
 
 	b := make([]byte, fileTypeBytes)
-	if _, err = f.Read(b); err != nil {
+	if _, err = io.ReadFull(f, b); err != nil {
 		log.Error(fmt.Sprintf("fail to read %s %v", name, err))
 		return C_reBreak, errors.Trace(err)
 	} else if !bytes.Equal(b, replication.BinLogFileHeader) {
@@ -94,12 +228,12 @@ func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int,
 		return C_reBreak, errors.Trace(err)
 	}
 
-	// must not seek to other position, otherwise the program may panic because formatevent, table map event is skipped
-	if _, err = f.Seek(fileTypeBytes, os.SEEK_SET); err != nil {
-		log.Error(fmt.Sprintf("error seek %s to %d", name, fileTypeBytes))
-		return C_reBreak, errors.Trace(err)
+	// must not seek to other position, otherwise the program may panic because formatevent, table map event is skipped.
+	// compressed file is read from the beginning sequentially too
+	var binlog string = StripBinlogCompressSuffix(filepath.Base(name))
+	if this.RelayLog != nil {
+		this.RelayLog.StartRelayLog()
 	}
-	var binlog string = filepath.Base(name)
 	return this.MyParseReader(cfg, f, &binlog)
 }
 
@@ -117,9 +251,20 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 		trxStatus   int    = 0
 		sqlLower    string = ""
 		tbMapPos    uint32 = 0
+
+		// coordinates in master binlog with -mode=relaylog
+		masterBinlog   string = ""
+		masterStartPos uint32 = 0
+		masterStopPos  uint32 = 0
+		masterTbMapPos uint32 = 0
 	)
 
+	stream, ifStream := r.(*BinlogStreamReader)
 	for {
+		if ifStream && stream.IfNextBinlog() {
+			// the next binlog file concatenated in stream
+			return C_reFileEnd, nil
+		}
 		headBuf := make([]byte, replication.EventHeaderSize)
 
 		if _, err = io.ReadFull(r, headBuf); err == io.EOF {
@@ -140,14 +285,14 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 
 		if h.EventSize <= uint32(replication.EventHeaderSize) {
 			err = errors.Errorf("invalid event header, event size is %d, too small", h.EventSize)
-			log.Error("%v", err)
+			log.Errorf("%v", err)
 			return C_reBreak, err
 		}
 
 		var buf bytes.Buffer
 		if n, err = io.CopyN(&buf, r, int64(h.EventSize)-int64(replication.EventHeaderSize)); err != nil {
 			err = errors.Errorf("get event body err %v, need %d - %d, but got %d", err, h.EventSize, replication.EventHeaderSize, n)
-			log.Error("%v", err)
+			log.Errorf("%v", err)
 			return C_reBreak, err
 		}
 
@@ -173,94 +318,113 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 			log.Error(fmt.Sprintf("fail to parse binlog event body of %s %v",*binlog, err))
 			return C_reBreak, errors.Trace(err)
 		}
-		if h.EventType == replication.TABLE_MAP_EVENT {
-			tbMapPos = h.LogPos - h.EventSize // avoid mysqlbing mask the row event as unknown table row event
+		if this.RelayLog != nil {
+			if this.RelayLog.HandleEvent(h, e) {
+				continue
+			}
+			masterBinlog, masterStartPos, masterStopPos = this.RelayLog.MasterBinlog, this.RelayLog.MasterStartPos, this.RelayLog.MasterStopPos
 		}
-
-		//e.Dump(os.Stdout)
-		//can not advance this check, because we need to parse table map event or table may not found. Also we must seek ahead the read file position
-		chRe := CheckBinHeaderCondition(cfg, h, *binlog)
-		if chRe == C_reBreak {
-			return C_reBreak, nil
-		} else if chRe == C_reContinue {
-			continue
-		} else if chRe == C_reFileEnd {
-			return C_reFileEnd, nil
+		//binEvent := &replication.BinlogEvent{RawData: rawData, Header: h, Event: e}
+		var binEvents []*replication.BinlogEvent
+		binEvents, err = this.Mysql8Parser.GetEvents(&replication.BinlogEvent{Header: h, Event: e}, rawData) // we donnot need raw data
+		if err != nil {
+			log.Error(fmt.Sprintf("fail to parse binlog event of %s at %d %v", *binlog, h.LogPos, err))
+			return C_reBreak, errors.Trace(err)
 		}
+		// events of compressed transaction are in TRANSACTION_PAYLOAD_EVENT
+		for _, binEvent := range binEvents {
+			h = binEvent.Header
+			if h.EventType == replication.TABLE_MAP_EVENT {
+				tbMapPos = h.LogPos - h.EventSize // avoid mysqlbing mask the row event as unknown table row event
+				masterTbMapPos = masterStartPos
+			}
 
-		//binEvent := &replication.BinlogEvent{RawData: rawData, Header: h, Event: e}
-		binEvent := &replication.BinlogEvent{Header: h, Event: e} // we donnot need raw data
-		oneMyEvent := &MyBinEvent{MyPos: mysql.Position{Name: *binlog, Pos: h.LogPos},
-			StartPos: tbMapPos}
-		//StartPos: h.LogPos - h.EventSize}
-		chRe = oneMyEvent.CheckBinEvent(cfg, binEvent, binlog)
-		if chRe == C_reBreak {
-			return C_reBreak, nil
-		} else if chRe == C_reContinue {
-			continue
-		} else if chRe == C_reFileEnd {
-			return C_reFileEnd, nil
-		} 
-
-		db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(binEvent)
-		if sqlType == "query" {
-			sqlLower = strings.ToLower(sql)
-			if sqlLower == "begin" {
-				trxStatus = C_trxBegin
-				fileTrxIndex++
-			} else if sqlLower == "commit" {
-				trxStatus = C_trxCommit
-			} else if sqlLower == "rollback" {
-				trxStatus = C_trxRollback
-			} else if oneMyEvent.QuerySql != nil {
-				trxStatus = C_trxProcess
-				rowCnt = 1
+			//e.Dump(os.Stdout)
+			//can not advance this check, because we need to parse table map event or table may not found. Also we must seek ahead the read file position
+			chRe := CheckBinHeaderCondition(cfg, h, *binlog)
+			if chRe == C_reBreak {
+				return C_reBreak, nil
+			} else if chRe == C_reContinue {
+				continue
+			} else if chRe == C_reFileEnd {
+				return C_reFileEnd, nil
 			}
-		} else {
-			trxStatus = C_trxProcess
-		}
 
+			oneMyEvent := &MyBinEvent{MyPos: mysql.Position{Name: *binlog, Pos: h.LogPos},
+				StartPos: tbMapPos, MasterPos: mysql.Position{Name: masterBinlog, Pos: masterStopPos}, MasterStartPos: masterTbMapPos}
+			//StartPos: h.LogPos - h.EventSize}
+			chRe = oneMyEvent.CheckBinEvent(cfg, binEvent, binlog)
+			if chRe == C_reBreak {
+				return C_reBreak, nil
+			} else if chRe == C_reContinue {
+				continue
+			} else if chRe == C_reFileEnd {
+				return C_reFileEnd, nil
+			} 
+
+			db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(binEvent)
+			if sqlType == "query" {
+				sqlLower = strings.ToLower(sql)
+				if sqlLower == "begin" {
+					trxStatus = C_trxBegin
+					fileTrxIndex++
+				} else if sqlLower == "commit" {
+					trxStatus = C_trxCommit
+				} else if sqlLower == "rollback" {
+					trxStatus = C_trxRollback
+				} else if oneMyEvent.QuerySql != nil {
+					trxStatus = C_trxProcess
+					rowCnt = 1
+				}
+			} else {
+				trxStatus = C_trxProcess
+			}
 
-		if cfg.WorkType != "stats" {
-			ifSendEvent := false
-			if oneMyEvent.IfRowsEvent {
 
-				tbKey := GetAbsTableName(string(oneMyEvent.BinEvent.Table.Schema),
-						string(oneMyEvent.BinEvent.Table.Table))
-				_, err = G_TablesColumnsInfo.GetTableInfoJson(string(oneMyEvent.BinEvent.Table.Schema),
-						string(oneMyEvent.BinEvent.Table.Table))
-				if err != nil {
-					log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
-							tbKey, oneMyEvent.MyPos.String()))
+			if cfg.WorkType != "stats" {
+				ifSendEvent := false
+				if oneMyEvent.IfRowsEvent {
+					// the worker looks up the table struct, the rows event is skipped and reported there if it is missing
+					ifSendEvent = true
+				} else if cfg.CheckpointFile != "" && sqlType == "query" && trxStatus == C_trxCommit {
+					// checkpoint is recorded at commit of transaction
+					ifSendEvent = true
+				} else if cfg.OutputFormat == C_outputFormatCanal && oneMyEvent.QuerySql != nil {
+					// ddl is output as flat message with isDdl=true, statement based dml with isDdl=false
+					ifSendEvent = true
+				} else if oneMyEvent.QuerySql != nil && oneMyEvent.QuerySql.IsDml() {
+					// statement based dml is output as it is
+					ifSendEvent = true
 				}
-				ifSendEvent = true
-			}
 
-			if ifSendEvent {
-				fileBinEventHandlingIndex++
-				oneMyEvent.EventIdx = fileBinEventHandlingIndex
-				oneMyEvent.SqlType = sqlType
-				oneMyEvent.Timestamp = h.Timestamp
-				oneMyEvent.TrxIndex = fileTrxIndex
-				oneMyEvent.TrxStatus = trxStatus
-				cfg.EventChan <- *oneMyEvent
-			}
+				if ifSendEvent {
+					fileBinEventHandlingIndex++
+					oneMyEvent.EventIdx = fileBinEventHandlingIndex
+					oneMyEvent.SqlType = sqlType
+					oneMyEvent.Timestamp = h.Timestamp
+					oneMyEvent.TrxIndex = fileTrxIndex
+					oneMyEvent.TrxStatus = trxStatus
+					cfg.EventChan <- *oneMyEvent
+				}
 
 
-		} 
+			} 
 
-		//output analysis result whatever the WorkType is	
-		if sqlType != "" {
-			if sqlType == "query" {
-				cfg.StatChan <- BinEventStats{Timestamp: h.Timestamp, Binlog: *binlog, StartPos: h.LogPos - h.EventSize, StopPos: h.LogPos,
-					Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType}
-			} else {
-				cfg.StatChan <- BinEventStats{Timestamp: h.Timestamp, Binlog: *binlog, StartPos: tbMapPos, StopPos: h.LogPos,
-					Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType}
+			//output analysis result whatever the WorkType is	
+			if sqlType != "" {
+				if sqlType == "query" {
+					cfg.StatChan <- BinEventStats{Timestamp: h.Timestamp, Binlog: *binlog, StartPos: h.LogPos - h.EventSize, StopPos: h.LogPos,
+						Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType,
+						MasterBinlog: masterBinlog, MasterStartPos: masterStartPos, MasterStopPos: masterStopPos}
+				} else {
+					cfg.StatChan <- BinEventStats{Timestamp: h.Timestamp, Binlog: *binlog, StartPos: tbMapPos, StopPos: h.LogPos,
+						Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType, RowsQuery: oneMyEvent.RowsQuery,
+						MasterBinlog: masterBinlog, MasterStartPos: masterTbMapPos, MasterStopPos: masterStopPos}
+				}
 			}
-		}
 
 
+		}
 	}
 
 	return C_reFileEnd, nil
@@ -0,0 +1,204 @@
+package base
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"my2sql/dsql"
+	"github.com/siddontang/go-log/log"
+)
+
+// NamePattern matches database or table name. it is one of:
+// exact name: order_0001
+// glob: order_*, order_00[0-9][0-9], see path.Match
+// regex: /^order_\d{4}$/, enclosed with '/'
+type NamePattern struct {
+	Pattern string
+	re      *regexp.Regexp
+	isGlob  bool
+}
+
+// TablePattern matches table name of any database if Database is nil
+type TablePattern struct {
+	Database *NamePattern
+	Table    *NamePattern
+}
+
+// TableFilter filters tables by -databases -tables -ignore-databases -ignore-tables, it is used for rows events, ddls and stats
+type TableFilter struct {
+	Databases       []*NamePattern
+	Tables          []*TablePattern
+	IgnoreDatabases []*NamePattern
+	IgnoreTables    []*TablePattern
+
+	lock   sync.RWMutex
+	cached map[string]bool // db.tb => if target table
+}
+
+func NewNamePattern(optName string, pattern string) *NamePattern {
+	np := &NamePattern{Pattern: pattern}
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			log.Fatalf("invalid regex %s of %s: %v", pattern, optName, err)
+		}
+		np.re = re
+	} else if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("invalid pattern %s of %s: %v", pattern, optName, err)
+		}
+		np.isGlob = true
+	}
+	return np
+}
+
+func (this *NamePattern) Match(name string) bool {
+	if this.re != nil {
+		return this.re.MatchString(name)
+	}
+	if this.isGlob {
+		ifMatch, _ := path.Match(this.Pattern, name)
+		return ifMatch
+	}
+	return this.Pattern == name
+}
+
+// SplitQualifiedPattern splits db.table.column into at most n parts, the last part is the rest.
+// '.' in regex enclosed with '/' is not a separator
+func SplitQualifiedPattern(pattern string, n int) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(pattern) && len(parts) < n-1; i++ {
+		if i == start && pattern[i] == '/' {
+			// skip to the end of regex
+			for i++; i < len(pattern) && pattern[i] != '/'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+			if i+1 < len(pattern) && pattern[i+1] != '.' {
+				// not regex, ex: /abc/def
+				break
+			}
+			continue
+		}
+		if pattern[i] == '.' {
+			parts = append(parts, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, pattern[start:])
+}
+
+// SplitTablePattern splits db.table into database and table pattern, database is empty if the pattern is not qualified
+func SplitTablePattern(pattern string) (string, string) {
+	parts := SplitQualifiedPattern(pattern, 2)
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+func NewTablePattern(optName string, pattern string) *TablePattern {
+	db, tb := SplitTablePattern(pattern)
+	tp := &TablePattern{Table: NewNamePattern(optName, tb)}
+	if db != "" {
+		tp.Database = NewNamePattern(optName, db)
+	}
+	return tp
+}
+
+func (this *TablePattern) Match(db, tb string) bool {
+	if this.Database != nil && !this.Database.Match(db) {
+		return false
+	}
+	return this.Table.Match(tb)
+}
+
+func NewTableFilter(dbs, tbs, ignoreDbs, ignoreTbs []string) *TableFilter {
+	filter := &TableFilter{cached: map[string]bool{}}
+	for _, oneDb := range dbs {
+		filter.Databases = append(filter.Databases, NewNamePattern("-databases", oneDb))
+	}
+	for _, oneTb := range tbs {
+		filter.Tables = append(filter.Tables, NewTablePattern("-tables", oneTb))
+	}
+	for _, oneDb := range ignoreDbs {
+		filter.IgnoreDatabases = append(filter.IgnoreDatabases, NewNamePattern("-ignore-databases", oneDb))
+	}
+	for _, oneTb := range ignoreTbs {
+		filter.IgnoreTables = append(filter.IgnoreTables, NewTablePattern("-ignore-tables", oneTb))
+	}
+	return filter
+}
+
+func (this *TableFilter) matchTable(db, tb string) bool {
+	if len(this.Databases) > 0 {
+		ifMatch := false
+		for _, oneDb := range this.Databases {
+			if oneDb.Match(db) {
+				ifMatch = true
+				break
+			}
+		}
+		if !ifMatch {
+			return false
+		}
+	}
+	if len(this.Tables) > 0 {
+		ifMatch := false
+		for _, oneTb := range this.Tables {
+			if oneTb.Match(db, tb) {
+				ifMatch = true
+				break
+			}
+		}
+		if !ifMatch {
+			return false
+		}
+	}
+	for _, oneDb := range this.IgnoreDatabases {
+		if oneDb.Match(db) {
+			return false
+		}
+	}
+	for _, oneTb := range this.IgnoreTables {
+		if oneTb.Match(db, tb) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsTargetTable returns true if the table is not filtered out, result is cached for regex and glob
+func (this *TableFilter) IsTargetTable(db, tb string) bool {
+	key := GetAbsTableName(db, tb)
+	this.lock.RLock()
+	ifTarget, ok := this.cached[key]
+	this.lock.RUnlock()
+	if ok {
+		return ifTarget
+	}
+	ifTarget = this.matchTable(db, tb)
+	this.lock.Lock()
+	this.cached[key] = ifTarget
+	this.lock.Unlock()
+	return ifTarget
+}
+
+// IfDdlOfTargetTable returns true if any table created, altered, dropped or renamed by the ddl is not filtered out
+func IfDdlOfTargetTable(cfg *ConfCmd, sqlInfo *dsql.SqlInfo) bool {
+	for _, dbTb := range sqlInfo.Tables {
+		if cfg.IsTargetTable(dbTb.Database, dbTb.Table) {
+			return true
+		}
+	}
+	for _, spec := range sqlInfo.AlterSpecs {
+		if spec.Action == dsql.ALTER_RENAME_TABLE && cfg.IsTargetTable(spec.NewTable.Database, spec.NewTable.Table) {
+			return true
+		}
+	}
+	return false
+}
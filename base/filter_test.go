@@ -0,0 +1,114 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamePattern(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"order_0001", "order_0001", true},
+		{"order_0001", "order_00011", false},
+		{"order_0001", "ORDER_0001", false},
+		// exact name is not a regex nor a glob
+		{"order.1", "order.1", true},
+		{"order.1", "orderx1", false},
+		{"order_*", "order_0001", true},
+		{"order_*", "order_", true},
+		{"order_*", "user_0001", false},
+		{"order_00[0-9][0-9]", "order_0042", true},
+		{"order_00[0-9][0-9]", "order_004a", false},
+		{"order_?", "order_1", true},
+		{"order_?", "order_12", false},
+		// regex is not anchored unless ^ and $ are given
+		{`/^order_\d{4}$/`, "order_0001", true},
+		{`/^order_\d{4}$/`, "order_00011", false},
+		{`/order_\d+/`, "old_order_1_bak", true},
+		{`/^db\.1$/`, "db.1", true},
+		{`/^db\.1$/`, "dbx1", false},
+		{`/^db.1$/`, "dbx1", true},
+		{"/", "/", true},
+	}
+	for _, c := range cases {
+		if ifMatch := NewNamePattern("-tables", c.pattern).Match(c.name); ifMatch != c.expected {
+			t.Errorf("pattern %s matches %s: got %v, expected %v", c.pattern, c.name, ifMatch, c.expected)
+		}
+	}
+}
+
+func TestSplitTablePattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		db      string
+		tb      string
+	}{
+		{"t1", "", "t1"},
+		{"db1.t1", "db1", "t1"},
+		{"db_*.order_*", "db_*", "order_*"},
+		{"*.t1", "*", "t1"},
+		// the table part is the rest after the first '.'
+		{"db1.t1.c1", "db1", "t1.c1"},
+		{`/^db\.1$/.t1`, `/^db\.1$/`, "t1"},
+		{`/^db.\d+$/./^t.1$/`, `/^db.\d+$/`, `/^t.1$/`},
+		{`/^db\/1$/.t1`, `/^db\/1$/`, "t1"},
+		{`/^t.1$/`, "", `/^t.1$/`},
+		{"db1./^t.1$/", "db1", "/^t.1$/"},
+		// not a regex since '/' is not followed by '.'
+		{"/abc/def", "", "/abc/def"},
+	}
+	for _, c := range cases {
+		db, tb := SplitTablePattern(c.pattern)
+		if db != c.db || tb != c.tb {
+			t.Errorf("%s: got %q %q, expected %q %q", c.pattern, db, tb, c.db, c.tb)
+		}
+	}
+}
+
+func TestSplitQualifiedPattern(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"c1", []string{"c1"}},
+		{"t1.c1", []string{"t1", "c1"}},
+		{"db1.t1.c1", []string{"db1", "t1", "c1"}},
+		{"db1.t1.c1.x", []string{"db1", "t1", "c1.x"}},
+		{`/^db\.\d$/.t_*./^c.1$/`, []string{`/^db\.\d$/`, "t_*", `/^c.1$/`}},
+	}
+	for _, c := range cases {
+		if parts := SplitQualifiedPattern(c.pattern, 3); !reflect.DeepEqual(parts, c.expected) {
+			t.Errorf("%s: got %q, expected %q", c.pattern, parts, c.expected)
+		}
+	}
+}
+
+func TestTableFilter(t *testing.T) {
+	filter := NewTableFilter([]string{"db_*", "/^shop\\d$/"}, []string{"order_*", "db_a.user"},
+		[]string{"db_tmp"}, []string{"*.order_bak"})
+	cases := []struct {
+		db       string
+		tb       string
+		expected bool
+	}{
+		{"db_a", "order_1", true},
+		{"shop1", "order_1", true},
+		{"shop12", "order_1", false},
+		{"other", "order_1", false},
+		{"db_a", "user", true},
+		{"db_b", "user", false},
+		{"db_tmp", "order_1", false},
+		{"db_a", "order_bak", false},
+	}
+	for _, c := range cases {
+		// twice to check the cached result
+		for i := 0; i < 2; i++ {
+			if ifTarget := filter.IsTargetTable(c.db, c.tb); ifTarget != c.expected {
+				t.Errorf("%s.%s: got %v, expected %v", c.db, c.tb, ifTarget, c.expected)
+			}
+		}
+	}
+}
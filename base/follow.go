@@ -0,0 +1,206 @@
+package base
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_followHeartbeatPeriod = 30 * time.Second
+	C_followReadTimeout     = 90 * time.Second // no event nor heartbeat in this time, master is considered as gone
+	C_reconnectMinWait      = 1 * time.Second
+	C_reconnectMaxWait      = 60 * time.Second
+)
+
+// HandleStopSignal cancels cfg.StopCtx on SIGTERM/SIGINT, so the binlog reader stops and
+// sqls already parsed are flushed into files. A second signal kills the process
+func (this *ConfCmd) HandleStopSignal() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			log.Infof("receive signal %v, stop to get binlog and flush sqls parsed", sig)
+			this.StopFunc()
+		case <-this.StopCtx.Done():
+		}
+		signal.Stop(sigChan)
+	}()
+}
+
+// ReplSyncPosition tracks positions of -follow mode to restart replication from after the connection is broken
+type ReplSyncPosition struct {
+	SyncPos      mysql.Position // end of the last transaction, replication restarts here
+	LastEventPos mysql.Position // events before and at this position are handled, skip them after restarting
+	inTrx        bool
+}
+
+func (this *ReplSyncPosition) Update(ev *replication.BinlogEvent, currentBinlog string) {
+	switch ev.Header.EventType {
+	case replication.ROTATE_EVENT:
+		rotatEvent := ev.Event.(*replication.RotateEvent)
+		this.SyncPos = mysql.Position{Name: string(rotatEvent.NextLogName), Pos: uint32(rotatEvent.Position)}
+		this.inTrx = false
+		return
+	case replication.HEARTBEAT_EVENT:
+		return
+	}
+	if ev.Header.LogPos == 0 {
+		// fake event sent by master when replication starts
+		return
+	}
+	myPos := mysql.Position{Name: currentBinlog, Pos: ev.Header.LogPos}
+	this.LastEventPos = myPos
+
+	switch ev.Header.EventType {
+	case replication.XID_EVENT:
+		this.inTrx = false
+		this.SyncPos = myPos
+	case replication.QUERY_EVENT:
+		query := strings.ToLower(string(ev.Event.(*replication.QueryEvent).Query))
+		if query == "begin" {
+			this.inTrx = true
+		} else if query == "commit" || query == "rollback" || !this.inTrx {
+			// ddl out of transaction is committed implicitly
+			this.inTrx = false
+			this.SyncPos = myPos
+		}
+	}
+}
+
+// ReconnectReplBinlogStreamer restarts replication from the end of the last transaction, retries with
+// backoff until it succeeds or the program is stopping. It returns false if the program is stopping
+func ReconnectReplBinlogStreamer(cfg *ConfCmd, replPos *ReplSyncPosition) bool {
+	var wait time.Duration = C_reconnectMinWait
+	cfg.BinlogSyncer.Close()
+	for {
+		log.Infof("reconnect to master %s:%d in %v, restart replication from %s", cfg.Host, cfg.Port, wait, replPos.SyncPos.String())
+		select {
+		case <-cfg.StopCtx.Done():
+			return false
+		case <-time.After(wait):
+		}
+		replSyncer, replStreamer, err := StartReplBinlogStreamer(cfg, replPos.SyncPos)
+		if err == nil {
+			cfg.BinlogSyncer = replSyncer
+			cfg.BinlogStreamer = replStreamer
+			break
+		}
+		replSyncer.Close()
+		log.Errorf("error replication from master %s:%d %v", cfg.Host, cfg.Port, err)
+		wait *= 2
+		if wait > C_reconnectMaxWait {
+			wait = C_reconnectMaxWait
+		}
+	}
+
+	// events of the last transaction before LastEventPos are sent again, skip them
+	if replPos.LastEventPos.Name != "" {
+		skipPos := mysql.Position{Name: replPos.LastEventPos.Name, Pos: replPos.LastEventPos.Pos + 1}
+		if !cfg.IfSetStartFilePos || skipPos.Compare(cfg.StartFilePos) > 0 {
+			cfg.StartFilePos = skipPos
+			cfg.IfSetStartFilePos = true
+		}
+	}
+	log.Infof("reconnected to master %s:%d, skip events before %s", cfg.Host, cfg.Port, cfg.StartFilePos.String())
+	return true
+}
+
+type RotateSqlFile struct {
+	File     string
+	Seq      int
+	OpenTime time.Time
+	Size     int64
+	trxIndex uint64
+	binlog   string
+}
+
+// SqlFileRotator works with -rotate-interval -rotate-size. Sqls are written into forward.N.sql first,
+// then forward.N.1.sql, forward.N.2.sql... after it is rotated. The key of files is the file name without sequence
+type SqlFileRotator struct {
+	cfg       *ConfCmd
+	files     map[string]*RotateSqlFile
+	baseFiles map[string]string // file name => file name without sequence
+}
+
+func NewSqlFileRotator(cfg *ConfCmd) *SqlFileRotator {
+	return &SqlFileRotator{cfg: cfg, files: map[string]*RotateSqlFile{}, baseFiles: map[string]string{}}
+}
+
+// GetFile returns the sql file being written for baseFile
+func (this *SqlFileRotator) GetFile(baseFile string) string {
+	if rf, ok := this.files[baseFile]; ok {
+		return rf.File
+	}
+	this.files[baseFile] = &RotateSqlFile{File: baseFile, Seq: 0, OpenTime: time.Now()}
+	this.baseFiles[baseFile] = baseFile
+	return baseFile
+}
+
+// GetBaseFile returns the file name without sequence of sqlFile
+func (this *SqlFileRotator) GetBaseFile(sqlFile string) string {
+	if baseFile, ok := this.baseFiles[sqlFile]; ok {
+		return baseFile
+	}
+	return sqlFile
+}
+
+// Reopen is called when resuming from checkpoint. files of the same baseFile are reopened in order
+func (this *SqlFileRotator) Reopen(baseFile string, sqlFile string, size int64) {
+	seq := 0
+	if rf, ok := this.files[baseFile]; ok {
+		seq = rf.Seq
+		if rf.File != sqlFile {
+			seq++
+		}
+	}
+	this.files[baseFile] = &RotateSqlFile{File: sqlFile, Seq: seq, OpenTime: time.Now(), Size: size}
+	this.baseFiles[sqlFile] = baseFile
+}
+
+// IfRotate returns true if the file reaches -rotate-interval or -rotate-size. Sqls of one transaction are
+// always written into the same file
+func (this *SqlFileRotator) IfRotate(baseFile string, sqlInfo ExtraSqlInfoOfPrint) bool {
+	rf, ok := this.files[baseFile]
+	if !ok || rf.Size == 0 {
+		return false
+	}
+	if rf.trxIndex == sqlInfo.trxIndex && rf.binlog == sqlInfo.binlog {
+		return false
+	}
+	if this.cfg.RotateInterval > 0 && time.Since(rf.OpenTime) >= time.Duration(this.cfg.RotateInterval)*time.Second {
+		return true
+	}
+	if this.cfg.RotateSize > 0 && rf.Size >= int64(this.cfg.RotateSize)*1024*1024 {
+		return true
+	}
+	return false
+}
+
+// Rotate returns the name of the next file for baseFile
+func (this *SqlFileRotator) Rotate(baseFile string) string {
+	rf := this.files[baseFile]
+	seq := rf.Seq + 1
+	ext := filepath.Ext(baseFile)
+	sqlFile := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(baseFile, ext), seq, ext)
+	this.files[baseFile] = &RotateSqlFile{File: sqlFile, Seq: seq, OpenTime: time.Now()}
+	this.baseFiles[sqlFile] = baseFile
+	log.Infof("rotate sql file %s to %s", rf.File, sqlFile)
+	return sqlFile
+}
+
+func (this *SqlFileRotator) AddWritten(baseFile string, sqlInfo ExtraSqlInfoOfPrint, size int) {
+	rf := this.files[baseFile]
+	rf.Size += int64(size)
+	rf.trxIndex = sqlInfo.trxIndex
+	rf.binlog = sqlInfo.binlog
+}
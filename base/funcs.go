@@ -16,7 +16,7 @@ import (
 func IntSliceToString(iArr []int, sep string, prefix string) string {
 	sArr := make([]string, len(iArr))
 	for _, v := range iArr {
-		sArr = append(sArr, string(v))
+		sArr = append(sArr, strconv.Itoa(v))
 	}
 
 	return prefix + " " + strings.Join(sArr, sep)
@@ -79,7 +79,7 @@ func GetBinlogBasenameAndIndex(binlog string) (string, int) {
 		log.Fatalf("parse binlog file index number error %v", err)
 	}
 	indx := int(n)
-	baseName := strings.Join(arr[0:cnt-1], "")
+	baseName := strings.Join(arr[0:cnt-1], ".")
 	return baseName, indx
 }
 
@@ -0,0 +1,196 @@
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	"github.com/siddontang/go-log/log"
+	SQL "my2sql/sqlbuilder"
+)
+
+// GtidState keeps gtid of the transaction being parsed, and filters transactions by
+// -start-gtid -stop-gtid -include-gtids -exclude-gtids
+type GtidState struct {
+	CurrentGtid string // gtid of the current transaction, empty for anonymous transaction
+
+	StartGtid      string              // -start-gtid=uuid:N, start at this transaction
+	StartGtidSet   *mysql.MysqlGTIDSet // -start-gtid=gtid set, start at the first transaction not contained in it
+	StopGtidSet    *mysql.MysqlGTIDSet // stop after all transactions in it are parsed
+	IncludeGtidSet *mysql.MysqlGTIDSet
+	ExcludeGtidSet *mysql.MysqlGTIDSet
+
+	IfSetGtidFilter bool
+	started         bool
+	skipTrx         bool
+	stopAfterTrx    bool
+	parsedGtidSet   *mysql.MysqlGTIDSet
+}
+
+func ParseGtidSetOption(optName string, gtidStr string) *mysql.MysqlGTIDSet {
+	gset, err := mysql.ParseMysqlGTIDSet(strings.TrimSpace(gtidStr))
+	if err != nil {
+		log.Fatalf("invalid gtid set %s=%s: %v", optName, gtidStr, err)
+	}
+	return gset.(*mysql.MysqlGTIDSet)
+}
+
+// IsSingleGtid returns true if the gtid set contains only one transaction, like uuid:N
+func IsSingleGtid(gset *mysql.MysqlGTIDSet) bool {
+	if len(gset.Sets) != 1 {
+		return false
+	}
+	for _, uuidSet := range gset.Sets {
+		return len(uuidSet.Intervals) == 1 && uuidSet.Intervals[0].Stop-uuidSet.Intervals[0].Start == 1
+	}
+	return false
+}
+
+func (this *GtidState) ParseGtidOptions(startGtid, stopGtid, includeGtids, excludeGtids string) {
+	if startGtid != "" {
+		gset := ParseGtidSetOption("-start-gtid", startGtid)
+		if IsSingleGtid(gset) {
+			this.StartGtid = gset.String()
+		} else {
+			this.StartGtidSet = gset
+		}
+		this.IfSetGtidFilter = true
+	} else {
+		this.started = true
+	}
+	if stopGtid != "" {
+		this.StopGtidSet = ParseGtidSetOption("-stop-gtid", stopGtid)
+		this.IfSetGtidFilter = true
+	}
+	if includeGtids != "" {
+		this.IncludeGtidSet = ParseGtidSetOption("-include-gtids", includeGtids)
+		this.IfSetGtidFilter = true
+	}
+	if excludeGtids != "" {
+		this.ExcludeGtidSet = ParseGtidSetOption("-exclude-gtids", excludeGtids)
+		this.IfSetGtidFilter = true
+	}
+	this.parsedGtidSet = ParseGtidSetOption("", "")
+	this.skipTrx = !this.started
+}
+
+// GetSyncGtidSet returns the gtid set to start replication with, that is transactions executed before -start-gtid.
+// which transactions of other server uuids are executed before -start-gtid=uuid:N is unknown, so gtid_purged of mysql
+// is merged for them, otherwise mysql refuses to replicate as they are purged. their transactions before uuid:N
+// are sent and skipped by CheckGtidEvent. gtidPurged may be nil
+func (this *GtidState) GetSyncGtidSet(gtidPurged *mysql.MysqlGTIDSet) *mysql.MysqlGTIDSet {
+	var (
+		syncGtidSet *mysql.MysqlGTIDSet
+		startSid    string = ""
+	)
+	if this.StartGtidSet != nil {
+		// StartGtidSet is used by CheckGtidEvent, it must not be changed
+		syncGtidSet = ParseGtidSetOption("-start-gtid", this.StartGtidSet.String())
+	} else {
+		syncGtidSet = ParseGtidSetOption("", "")
+		for sid, uuidSet := range ParseGtidSetOption("-start-gtid", this.StartGtid).Sets {
+			startSid = sid
+			if uuidSet.Intervals[0].Start > 1 {
+				syncGtidSet.Update(fmt.Sprintf("%s:1-%d", sid, uuidSet.Intervals[0].Start-1))
+			}
+		}
+	}
+	if gtidPurged != nil {
+		for sid, uuidSet := range gtidPurged.Sets {
+			if sid != startSid {
+				syncGtidSet.AddSet(uuidSet.Clone())
+			}
+		}
+	}
+	return syncGtidSet
+}
+
+func GetGtidFromEvent(ev *replication.BinlogEvent) string {
+	gtidEvent, ok := ev.Event.(*replication.GTIDEvent)
+	if !ok || ev.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+		return ""
+	}
+	sid, err := uuid.FromBytes(gtidEvent.SID)
+	if err != nil {
+		log.Errorf("invalid sid in gtid event %v", err)
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", sid.String(), gtidEvent.GNO)
+}
+
+func (this *GtidState) isGtidIn(gtid string, gset *mysql.MysqlGTIDSet) bool {
+	if gtid == "" {
+		return false
+	}
+	return gset.Contain(ParseGtidSetOption("", gtid))
+}
+
+// CheckGtidEvent is called for every GTID_EVENT/ANONYMOUS_GTID_EVENT, it decides whether
+// the events of this transaction are parsed or skipped
+func (this *GtidState) CheckGtidEvent(ev *replication.BinlogEvent) int {
+	this.CurrentGtid = GetGtidFromEvent(ev)
+	if !this.IfSetGtidFilter {
+		return C_reProcess
+	}
+	if this.stopAfterTrx {
+		log.Infof("stop to get event. StopGtid set. all transactions in %s are parsed", this.StopGtidSet.String())
+		return C_reBreak
+	}
+
+	if !this.started {
+		if this.StartGtid != "" {
+			this.started = this.CurrentGtid == this.StartGtid
+		} else {
+			this.started = this.CurrentGtid != "" && !this.isGtidIn(this.CurrentGtid, this.StartGtidSet)
+		}
+		if this.started {
+			log.Infof("start to parse at gtid %s", this.CurrentGtid)
+		}
+	}
+
+	this.skipTrx = false
+	if !this.started {
+		this.skipTrx = true
+	} else if this.IncludeGtidSet != nil && !this.isGtidIn(this.CurrentGtid, this.IncludeGtidSet) {
+		this.skipTrx = true
+	} else if this.ExcludeGtidSet != nil && this.isGtidIn(this.CurrentGtid, this.ExcludeGtidSet) {
+		this.skipTrx = true
+	}
+
+	if this.started && this.StopGtidSet != nil && this.CurrentGtid != "" {
+		this.parsedGtidSet.Update(this.CurrentGtid)
+		if this.parsedGtidSet.Contain(this.StopGtidSet) {
+			this.stopAfterTrx = true
+		}
+	}
+	if this.skipTrx {
+		return C_reContinue
+	}
+	return C_reProcess
+}
+
+// IfSkipEvent returns true if the event belongs to a transaction filtered out by gtid
+func (this *GtidState) IfSkipEvent() bool {
+	return this.IfSetGtidFilter && this.skipTrx
+}
+
+// GetGtidNextSql returns SET GTID_NEXT statement for gtid like uuid:N
+func GetGtidNextSql(gtid string) string {
+	idx := strings.LastIndex(gtid, ":")
+	if idx < 0 {
+		log.Fatalf("invalid gtid %s", gtid)
+	}
+	sid, err := uuid.Parse(gtid[:idx])
+	if err != nil {
+		log.Fatalf("invalid gtid %s: %v", gtid, err)
+	}
+	gno, err := strconv.ParseUint(gtid[idx+1:], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid gtid %s: %v", gtid, err)
+	}
+	gtidSql, _ := SQL.NewGtidNextStatement(sid[:], gno).String("")
+	return gtidSql
+}
@@ -0,0 +1,153 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+)
+
+const (
+	testUuidA = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	testUuidB = "5b8d1c2e-0a1f-11ee-8f3a-0242ac120002"
+)
+
+func TestGetSyncGtidSet(t *testing.T) {
+	cases := []struct {
+		name       string
+		startGtid  string
+		gtidPurged string
+		expected   string
+	}{
+		{"single gtid", testUuidA + ":23", "", testUuidA + ":1-22"},
+		{"first gtid", testUuidA + ":1", "", ""},
+		{"purged of other uuid is merged", testUuidA + ":23", testUuidB + ":1-100", testUuidA + ":1-22," + testUuidB + ":1-100"},
+		{"purged of start uuid is not merged", testUuidA + ":23", testUuidA + ":1-10," + testUuidB + ":1-5", testUuidA + ":1-22," + testUuidB + ":1-5"},
+		{"gtid set", testUuidA + ":1-22", "", testUuidA + ":1-22"},
+		{"gtid set with purged", testUuidA + ":1-22", testUuidB + ":1-100", testUuidA + ":1-22," + testUuidB + ":1-100"},
+	}
+	for _, c := range cases {
+		state := &GtidState{}
+		state.ParseGtidOptions(c.startGtid, "", "", "")
+		var gtidPurged *mysql.MysqlGTIDSet
+		if c.gtidPurged != "" {
+			gtidPurged = ParseGtidSetOption("", c.gtidPurged)
+		}
+		syncGtidSet := state.GetSyncGtidSet(gtidPurged)
+		if !syncGtidSet.Equal(ParseGtidSetOption("", c.expected)) {
+			t.Errorf("%s: sync gtid set is %s, expected %s", c.name, syncGtidSet.String(), c.expected)
+		}
+		if state.StartGtidSet != nil && !state.StartGtidSet.Equal(ParseGtidSetOption("", c.startGtid)) {
+			t.Errorf("%s: -start-gtid is changed to %s", c.name, state.StartGtidSet.String())
+		}
+	}
+}
+
+func TestParseGtidOptions(t *testing.T) {
+	cases := []struct {
+		name            string
+		startGtid       string
+		stopGtid        string
+		includeGtids    string
+		excludeGtids    string
+		expectStartGtid string
+		expectStartSet  string
+		expectFilter    bool
+	}{
+		{"no gtid option", "", "", "", "", "", "", false},
+		{"single start gtid", " " + testUuidA + ":5 ", "", "", "", testUuidA + ":5", "", true},
+		{"single start gtid as range", testUuidA + ":5-5", "", "", "", testUuidA + ":5", "", true},
+		{"start gtid range", testUuidA + ":1-5", "", "", "", "", testUuidA + ":1-5", true},
+		{"start gtid set of two uuids", testUuidA + ":5," + testUuidB + ":3", "", "", "", "", testUuidA + ":5," + testUuidB + ":3", true},
+		{"stop gtid only", "", testUuidA + ":9", "", "", "", "", true},
+		{"include gtids only", "", "", testUuidA + ":1-3", "", "", "", true},
+		{"exclude gtids only", "", "", "", testUuidA + ":1-3", "", "", true},
+	}
+	for _, c := range cases {
+		state := &GtidState{}
+		state.ParseGtidOptions(c.startGtid, c.stopGtid, c.includeGtids, c.excludeGtids)
+		if state.StartGtid != c.expectStartGtid {
+			t.Errorf("%s: start gtid is %q, expected %q", c.name, state.StartGtid, c.expectStartGtid)
+		}
+		if (state.StartGtidSet == nil) != (c.expectStartSet == "") ||
+			(state.StartGtidSet != nil && !state.StartGtidSet.Equal(ParseGtidSetOption("", c.expectStartSet))) {
+			t.Errorf("%s: start gtid set is %v, expected %q", c.name, state.StartGtidSet, c.expectStartSet)
+		}
+		if state.IfSetGtidFilter != c.expectFilter {
+			t.Errorf("%s: gtid filter is %v, expected %v", c.name, state.IfSetGtidFilter, c.expectFilter)
+		}
+	}
+}
+
+// testGtidEvent returns GTID_EVENT of uuid:gno, or ANONYMOUS_GTID_EVENT if gtid is empty
+func testGtidEvent(t *testing.T, gtid string) *replication.BinlogEvent {
+	if gtid == "" {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.ANONYMOUS_GTID_EVENT},
+			Event: &replication.GTIDEvent{}}
+	}
+	idx := strings.LastIndex(gtid, ":")
+	sid, err := uuid.Parse(gtid[:idx])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gno int64
+	fmt.Sscanf(gtid[idx+1:], "%d", &gno)
+	return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+		Event: &replication.GTIDEvent{SID: sid[:], GNO: gno}}
+}
+
+func TestCheckGtidEvent(t *testing.T) {
+	// transactions in binlog order, A:1-6 interleaved with B:1-2 and an anonymous one
+	gtids := []string{testUuidA + ":1", testUuidA + ":2", testUuidB + ":1", testUuidA + ":3", "",
+		testUuidA + ":4", testUuidB + ":2", testUuidA + ":5", testUuidA + ":6"}
+	// p: processed, s: skipped, b: break before the transaction
+	cases := []struct {
+		name         string
+		startGtid    string
+		stopGtid     string
+		includeGtids string
+		excludeGtids string
+		expected     string
+	}{
+		{"no filter", "", "", "", "", "ppppppppp"},
+		{"start at single gtid", testUuidA + ":3", "", "", "", "sss pppppp"},
+		{"start at gtid of other uuid", testUuidB + ":2", "", "", "", "ssssssppp"},
+		{"start after gtid set", testUuidA + ":1-3," + testUuidB + ":1", "", "", "", "sssss pppp"},
+		{"start after gtid set of one uuid", testUuidA + ":1-2", "", "", "", "ssppppppp"},
+		{"stop after gtid", "", testUuidA + ":4", "", "", "ppppppbbb"},
+		{"stop after gtid set", "", testUuidA + ":2," + testUuidB + ":1", "", "", "pppbbbbbb"},
+		{"start and stop", testUuidA + ":2", testUuidA + ":2-4", "", "", "sppppp bbb"},
+		{"include gtids", "", "", testUuidA + ":2-4", "", "spsps psss"},
+		{"exclude gtids", "", "", "", testUuidA + ":2-4," + testUuidB + ":1-2", "psssp sspp"},
+		{"include and exclude", "", "", testUuidA + ":1-5", testUuidA + ":3", "ppsss psps"},
+		{"start and include", testUuidA + ":3", "", testUuidA + ":1-4," + testUuidB + ":2", "", "sssps ppss"},
+	}
+	for _, c := range cases {
+		expected := strings.Replace(c.expected, " ", "", -1)
+		state := &GtidState{}
+		state.ParseGtidOptions(c.startGtid, c.stopGtid, c.includeGtids, c.excludeGtids)
+		var got []byte
+		for _, gtid := range gtids {
+			re := state.CheckGtidEvent(testGtidEvent(t, gtid))
+			switch {
+			case re == C_reBreak:
+				got = append(got, 'b')
+			case re == C_reContinue && state.IfSkipEvent():
+				got = append(got, 's')
+			case re == C_reProcess && !state.IfSkipEvent():
+				got = append(got, 'p')
+			default:
+				t.Errorf("%s: CheckGtidEvent returns %d but IfSkipEvent is %v at %q", c.name, re, state.IfSkipEvent(), gtid)
+			}
+		}
+		if len(expected) != len(gtids) {
+			t.Fatalf("%s: expected %q is not for %d transactions", c.name, c.expected, len(gtids))
+		}
+		if string(got) != expected {
+			t.Errorf("%s: got %s, expected %s", c.name, got, expected)
+		}
+	}
+}
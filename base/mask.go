@@ -0,0 +1,212 @@
+package base
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	toolkits "my2sql/toolkits"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_maskHash     = "hash"
+	C_maskNull     = "null"
+	C_maskFixed    = "fixed"
+	C_maskKeepLast = "keep-last"
+
+	C_maskChar = "*"
+)
+
+var (
+	GOptsValidMaskStrategy []string = []string{C_maskHash, C_maskNull, C_maskFixed + ":str", C_maskKeepLast + ":N"}
+)
+
+// MaskRule masks values of columns matching [db.][table.]column
+type MaskRule struct {
+	Pattern  string
+	Database *NamePattern // nil means any database
+	Table    *NamePattern // nil means any table
+	Column   *NamePattern
+	Strategy string
+	Fixed    string
+	KeepLast int
+}
+
+// ColumnMasker finds the mask rule of columns, the first matching rule is used
+type ColumnMasker struct {
+	Rules         []*MaskRule
+	IfMaskKeyCols bool
+	HashSalt      string
+
+	lock       sync.RWMutex
+	cached     map[string]*MaskRule // db.tb.col => rule, nil if no rule matches
+	warnedKeys map[string]bool      // db.tb.col of key columns not masked, warned already
+}
+
+// ParseMaskRule parses [db.][table.]column=strategy, strategy is one of hash, null, fixed:str, keep-last:N
+func ParseMaskRule(ruleStr string) *MaskRule {
+	idx := strings.Index(ruleStr, "=")
+	if idx <= 0 {
+		log.Fatalf("invalid mask rule %s, it should be [db.][table.]column=strategy", ruleStr)
+	}
+	rule := &MaskRule{Pattern: ruleStr[:idx]}
+	parts := SplitQualifiedPattern(rule.Pattern, 3)
+	rule.Column = NewNamePattern("-mask-rules", parts[len(parts)-1])
+	if len(parts) >= 2 {
+		rule.Table = NewNamePattern("-mask-rules", parts[len(parts)-2])
+	}
+	if len(parts) == 3 {
+		rule.Database = NewNamePattern("-mask-rules", parts[0])
+	}
+
+	strategy := ruleStr[idx+1:]
+	rule.Strategy = strategy
+	switch {
+	case strategy == C_maskHash, strategy == C_maskNull:
+	case strings.HasPrefix(strategy, C_maskFixed+":"):
+		rule.Strategy = C_maskFixed
+		rule.Fixed = strategy[len(C_maskFixed)+1:]
+	case strings.HasPrefix(strategy, C_maskKeepLast+":"):
+		rule.Strategy = C_maskKeepLast
+		n, err := strconv.Atoi(strategy[len(C_maskKeepLast)+1:])
+		if err != nil || n < 0 {
+			log.Fatalf("invalid mask rule %s, N of keep-last:N must be a non-negative integer", ruleStr)
+		}
+		rule.KeepLast = n
+	default:
+		log.Fatalf("invalid mask strategy %s of rule %s, valid strategies: %s", strategy, ruleStr, strings.Join(GOptsValidMaskStrategy, ", "))
+	}
+	return rule
+}
+
+func NewColumnMasker(rulesStr []string, ifMaskKeyCols bool, hashSalt string) *ColumnMasker {
+	masker := &ColumnMasker{IfMaskKeyCols: ifMaskKeyCols, HashSalt: hashSalt,
+		cached: map[string]*MaskRule{}, warnedKeys: map[string]bool{}}
+	for _, oneRule := range rulesStr {
+		masker.Rules = append(masker.Rules, ParseMaskRule(oneRule))
+	}
+	return masker
+}
+
+func (this *MaskRule) Match(db, tb, col string) bool {
+	if this.Database != nil && !this.Database.Match(db) {
+		return false
+	}
+	if this.Table != nil && !this.Table.Match(tb) {
+		return false
+	}
+	return this.Column.Match(col)
+}
+
+func (this *ColumnMasker) GetColumnRule(db, tb, col string) *MaskRule {
+	key := fmt.Sprintf("%s.%s", GetAbsTableName(db, tb), col)
+	this.lock.RLock()
+	rule, ok := this.cached[key]
+	this.lock.RUnlock()
+	if ok {
+		return rule
+	}
+	rule = nil
+	for _, oneRule := range this.Rules {
+		if oneRule.Match(db, tb, col) {
+			rule = oneRule
+			break
+		}
+	}
+	this.lock.Lock()
+	this.cached[key] = rule
+	this.lock.Unlock()
+	return rule
+}
+
+// MaskValue masks one column value, NULL is kept as NULL. the masked value of []byte is []byte, others are string
+func (this *ColumnMasker) MaskValue(rule *MaskRule, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	var str string
+	bArr, isBytes := v.([]byte)
+	if isBytes {
+		str = string(bArr)
+	} else if s, ok := v.(string); ok {
+		str = s
+	} else {
+		str = fmt.Sprintf("%v", v)
+	}
+
+	var masked string
+	switch rule.Strategy {
+	case C_maskNull:
+		return nil
+	case C_maskHash:
+		sum := sha256.Sum256([]byte(this.HashSalt + str))
+		masked = hex.EncodeToString(sum[:])
+	case C_maskFixed:
+		masked = rule.Fixed
+	case C_maskKeepLast:
+		runes := []rune(str)
+		if len(runes) > rule.KeepLast {
+			masked = strings.Repeat(C_maskChar, len(runes)-rule.KeepLast) + string(runes[len(runes)-rule.KeepLast:])
+		} else {
+			masked = str
+		}
+	}
+	if isBytes {
+		return []byte(masked)
+	}
+	return masked
+}
+
+// MaskRowsEvent returns the rows event with column values masked, the rows of ev are not changed. sqls are generated
+// from the rows of ev, the masked values are only output. protectedIdx are columns of primary/unique key and the columns
+// used to build where condition, they are masked only if -mask-key-columns is set
+func (this *ColumnMasker) MaskRowsEvent(ev *MyBinEvent, colNames []FieldInfo, protectedIdx []int) *replication.RowsEvent {
+	db := string(ev.BinEvent.Table.Schema)
+	tb := string(ev.BinEvent.Table.Table)
+	var maskedRows [][]interface{}
+	for ci, col := range colNames {
+		if ci >= len(ev.BinEvent.Rows[0]) {
+			break
+		}
+		rule := this.GetColumnRule(db, tb, col.FieldName)
+		if rule == nil {
+			continue
+		}
+		if !this.IfMaskKeyCols && toolkits.ContainsInt(protectedIdx, ci) {
+			this.WarnKeyColumnNotMasked(db, tb, col.FieldName, rule)
+			continue
+		}
+		if maskedRows == nil {
+			maskedRows = make([][]interface{}, len(ev.BinEvent.Rows))
+			for ri, row := range ev.BinEvent.Rows {
+				maskedRows[ri] = append([]interface{}{}, row...)
+			}
+		}
+		for ri := range maskedRows {
+			maskedRows[ri][ci] = this.MaskValue(rule, maskedRows[ri][ci])
+		}
+	}
+	if maskedRows == nil {
+		return ev.BinEvent
+	}
+	maskedEv := *ev.BinEvent
+	maskedEv.Rows = maskedRows
+	return &maskedEv
+}
+
+func (this *ColumnMasker) WarnKeyColumnNotMasked(db, tb, col string, rule *MaskRule) {
+	key := fmt.Sprintf("%s.%s", GetAbsTableName(db, tb), col)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.warnedKeys[key] {
+		return
+	}
+	this.warnedKeys[key] = true
+	log.Warnf("%s matches mask rule %s, but it is not masked because it is key column or used in where condition of the generated sqls. "+
+		"set -mask-key-columns to mask it", key, rule.Pattern)
+}
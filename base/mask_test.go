@@ -0,0 +1,81 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// testMaskSqls generates sqls of the rows event of db1.t1(id int, email varchar) like the worker with -mask-rules
+func testMaskSqls(t *testing.T, rules []string, sqlType string, ifRollback bool, uniKey []int, rows [][]interface{}) []string {
+	tbMap := &replication.TableMapEvent{Schema: []byte("db1"), Table: []byte("t1"), ColumnCount: 2,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR}, ColumnMeta: []uint16{0, 128}}
+	colNames := []FieldInfo{{FieldName: "id", FieldType: "int"}, {FieldName: "email", FieldType: "varchar"}}
+	colsDef, colsTypeName := GetSqlFieldsEXpressions(2, colNames, tbMap)
+	ev := &MyBinEvent{BinEvent: &replication.RowsEvent{Table: tbMap, Rows: rows}, SqlType: sqlType}
+	imageCols := &RowImageColumns{}
+
+	masker := NewColumnMasker(rules, false, "")
+	protectedIdx := append(append([]int{}, uniKey...), GetWhereColumnIdx(sqlType, ifRollback, 2, uniKey, false, imageCols)...)
+	maskedEv := masker.MaskRowsEvent(ev, colNames, protectedIdx)
+	if !reflect.DeepEqual(ev.BinEvent.Rows, rows) {
+		t.Errorf("rows of the event are changed by mask: %v", ev.BinEvent.Rows)
+	}
+	switch {
+	case sqlType == "insert" && !ifRollback:
+		return GenInsertSqlsForOneRowsEvent("", maskedEv, colsDef, 1, false, true, false, nil, nil)
+	case sqlType == "insert":
+		return GenDeleteSqlsForOneRowsEventRollbackInsert("", maskedEv, colsDef, uniKey, false, true, imageCols)
+	case sqlType == "delete" && !ifRollback:
+		return GenDeleteSqlsForOneRowsEvent("", maskedEv, colsDef, uniKey, false, false, true, imageCols)
+	case sqlType == "delete":
+		return GenInsertSqlsForOneRowsEventRollbackDelete("", maskedEv, colsDef, 1, true, imageCols)
+	}
+	return GenUpdateSqlsForOneRowsEvent("", colsTypeName, colsTypeName, ev.BinEvent, maskedEv.Rows, colsDef, uniKey, false, ifRollback, true, imageCols)
+}
+
+func TestMaskRowsEventOfKeylessTable(t *testing.T) {
+	rules := []string{"email=fixed:***"}
+	row := []interface{}{int32(1), "a@x.com"}
+	cases := []struct {
+		sqlType    string
+		ifRollback bool
+		rows       [][]interface{}
+		expected   []string
+	}{
+		{"insert", false, [][]interface{}{row}, []string{"INSERT INTO `db1`.`t1` (`id`,`email`) VALUES (1,'***')"}},
+		// all columns are in where condition without key
+		{"insert", true, [][]interface{}{row}, []string{"DELETE FROM `db1`.`t1` WHERE (`id`=1 AND `email`='a@x.com')"}},
+		{"delete", false, [][]interface{}{row}, []string{"DELETE FROM `db1`.`t1` WHERE (`id`=1 AND `email`='a@x.com')"}},
+		{"delete", true, [][]interface{}{row}, []string{"INSERT INTO `db1`.`t1` (`id`,`email`) VALUES (1,'***')"}},
+		{"update", false, [][]interface{}{row, {int32(2), "a@x.com"}},
+			[]string{"UPDATE `db1`.`t1` SET `id`=2 WHERE (`id`=1 AND `email`='a@x.com')"}},
+	}
+	for _, c := range cases {
+		if sqls := testMaskSqls(t, rules, c.sqlType, c.ifRollback, nil, c.rows); !reflect.DeepEqual(sqls, c.expected) {
+			t.Errorf("%s rollback=%v:\n got      %q\n expected %q", c.sqlType, c.ifRollback, sqls, c.expected)
+		}
+	}
+}
+
+func TestMaskRowsEventUpdateMaskedColumnOnly(t *testing.T) {
+	rows := [][]interface{}{{int32(1), "a@x.com"}, {int32(1), "b@x.com"}}
+	cases := []struct {
+		rule       string
+		ifRollback bool
+		expected   string
+	}{
+		{"email=fixed:***", false, "UPDATE `db1`.`t1` SET `email`='***' WHERE `id`=1"},
+		{"email=fixed:***", true, "UPDATE `db1`.`t1` SET `email`='***' WHERE `id`=1"},
+		{"email=null", false, "UPDATE `db1`.`t1` SET `email`=null WHERE `id`=1"},
+		{"email=keep-last:6", false, "UPDATE `db1`.`t1` SET `email`='*@x.com' WHERE `id`=1"},
+	}
+	for _, c := range cases {
+		sqls := testMaskSqls(t, []string{c.rule}, "update", c.ifRollback, []int{0}, rows)
+		if !reflect.DeepEqual(sqls, []string{c.expected}) {
+			t.Errorf("%s rollback=%v:\n got      %q\n expected %q", c.rule, c.ifRollback, sqls, c.expected)
+		}
+	}
+}
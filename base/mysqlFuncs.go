@@ -4,8 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
 	"github.com/juju/errors"
 	"github.com/siddontang/go-log/log"
+	"github.com/go-mysql-org/go-mysql/mysql"
 	toolkits "my2sql/toolkits"
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -47,12 +49,15 @@ type TblInfoJson struct {
 	Columns    []FieldInfo `json:"columns"`
 	PrimaryKey KeyInfo     `json:"primary_key"`
 	UniqueKeys []KeyInfo   `json:"unique_keys"`
+	UniqueKeyNames []string `json:"unique_key_names,omitempty"` // names of UniqueKeys, used to replay DROP INDEX
 	//	DdlInfo    DdlPosInfo  `json:"ddl_info"`
 }
 
 type TablesColumnsInfo struct {
-	//lock       *sync.RWMutex
-	tableInfos map[string]*TblInfoJson //{db.tb:TblInfoJson}}
+	lock       sync.RWMutex
+	tableInfos map[string]*TblInfoJson //{db.tb:TblInfoJson}}, table definitions before any DDL in binlog
+	versions   map[string][]*TblInfoVersion //{db.tb:[version1, version2]}, table definitions changed by DDL in binlog
+	seedFromDb map[string]bool //{db.tb:true}, table definitions queried from mysql
 }
 
 type column struct {
@@ -101,6 +106,20 @@ func CreateMysqlCon(mysqlUrl string) (*sql.DB, error) {
 	return db, nil
 }
 
+// GetMysqlGtidPurged returns @@GLOBAL.gtid_purged of mysql
+func GetMysqlGtidPurged(db *sql.DB) (*mysql.MysqlGTIDSet, error) {
+	var gtidPurged string
+	if err := db.QueryRow("SELECT @@GLOBAL.gtid_purged").Scan(&gtidPurged); err != nil {
+		return nil, err
+	}
+	// the gtid set is split into lines by mysql if it is long
+	gset, err := mysql.ParseMysqlGTIDSet(strings.Replace(gtidPurged, "\n", "", -1))
+	if err != nil {
+		return nil, err
+	}
+	return gset.(*mysql.MysqlGTIDSet), nil
+}
+
 func (this *TablesColumnsInfo) GetTbDefFromDb(cfg *ConfCmd, dbname string, tbname string) {
 	//get table columns from DB
 	var err error
@@ -108,7 +127,8 @@ func (this *TablesColumnsInfo) GetTbDefFromDb(cfg *ConfCmd, dbname string, tbnam
 		sqlUrl := GetMysqlUrl(cfg)
 		cfg.FromDB, err = CreateMysqlCon(sqlUrl)
 		if err != nil {
-			log.Fatalf("fail to connect to mysql %v", err)
+			// rows events of the table cannot be output without its struct, sqls must not be incomplete silently
+			log.Fatalf("fail to connect to mysql to get table struct of %s.%s: %v", dbname, tbname, err)
 		}
 	}
 
@@ -218,6 +238,7 @@ func (this *TablesColumnsInfo) GetTableKeysInfo(db *sql.DB, dbName string, tbNam
 	}
 	this.tableInfos[tbKey].PrimaryKey = KeyInfo{}
 	this.tableInfos[tbKey].UniqueKeys = []KeyInfo{}
+	this.tableInfos[tbKey].UniqueKeyNames = []string{}
 	for kname, kcolumn := range dbTbKeysInfo[dbName][tbName] {
 		isPrimay = false
 		_, ok = primaryKeys[dbName]
@@ -234,6 +255,7 @@ func (this *TablesColumnsInfo) GetTableKeysInfo(db *sql.DB, dbName string, tbNam
 			this.tableInfos[tbKey].PrimaryKey = kcolumn
 		} else {
 			this.tableInfos[tbKey].UniqueKeys = append(this.tableInfos[tbKey].UniqueKeys, kcolumn)
+			this.tableInfos[tbKey].UniqueKeyNames = append(this.tableInfos[tbKey].UniqueKeyNames, kname)
 		}
 	}
 	return nil
@@ -307,14 +329,27 @@ func  (this *TablesColumnsInfo) GetTableColumns(db *sql.DB, dbname string, tbnam
 
 
 func (this *TablesColumnsInfo) GetTableInfoJson(schema string, table string) (*TblInfoJson, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.getTableInfoJson(schema, table)
+}
+
+func (this *TablesColumnsInfo) getTableInfoJson(schema string, table string) (*TblInfoJson, error) {
 	tbKey := GetAbsTableName(schema, table)
 	tbDefsJson, ok := this.tableInfos[tbKey]
 	if !ok {
+		if GConfCmd.OnlyColFromFile {
+			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s in %s", tbKey, GConfCmd.ReadTblDefJsonFile)
+		}
 		this.GetTbDefFromDb(GConfCmd, schema, table)
 		tbDefsJson, ok = this.tableInfos[tbKey]
 		if !ok {
 			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s, maybe it was dropped. Skip it", tbKey)
 		}
+		if this.seedFromDb == nil {
+			this.seedFromDb = map[string]bool{}
+		}
+		this.seedFromDb[tbKey] = true
 	}
 	return tbDefsJson, nil
 }
@@ -0,0 +1,257 @@
+package base
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_outputFormatSql   = "sql"
+	C_outputFormatJsonl = "jsonl"
+	C_outputFormatCsv   = "csv"
+	C_outputFormatTsv   = "tsv"
+
+	C_outputFormatDebezium = "debezium"
+	C_outputFormatCanal    = "canal"
+
+	C_csvNullStr = "\\N"
+)
+
+var (
+	GOptsValidOutputFormat   []string = []string{C_outputFormatSql, C_outputFormatJsonl, C_outputFormatCsv, C_outputFormatTsv, C_outputFormatDebezium, C_outputFormatCanal}
+	GOptsValidBinaryEncoding []string = []string{"hex", "base64"}
+)
+
+// RowImage is column values of one row, marshaled as json object in the order of columns
+type RowImage struct {
+	Names  []string
+	Values []interface{}
+}
+
+func (this *RowImage) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, name := range this.Names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(this.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(val)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// RowChangeEvent is one line of -output-format=jsonl
+type RowChangeEvent struct {
+	Database   string    `json:"database"`
+	Table      string    `json:"table"`
+	Type       string    `json:"type"` // insert, update, delete
+	Before     *RowImage `json:"before"`
+	After      *RowImage `json:"after"`
+	PrimaryKey *RowImage `json:"primary_key"` // primary key, or unique key if no primary key
+	Binlog     string    `json:"binlog"`
+	StartPos   uint32    `json:"start_pos"`
+	EndPos     uint32    `json:"end_pos"`
+	Timestamp  uint32    `json:"timestamp"`
+	TrxIndex   uint64    `json:"trx_index"`
+	Gtid       string    `json:"gtid"`
+	RowsQuery  string    `json:"rows_query"` // original sql, empty if binlog_rows_query_log_events=OFF
+}
+
+// GetOutputFileName replaces .sql of the sql file name with the extension of -output-format
+func GetOutputFileName(cfg *ConfCmd, sqlFileName string) string {
+	if cfg.OutputFormat == C_outputFormatSql {
+		return sqlFileName
+	}
+	return strings.TrimSuffix(sqlFileName, filepath.Ext(sqlFileName)) + "." + cfg.OutputFormat
+}
+
+// GetJsonColumnValue converts column value decoded from binlog into value for json,
+// json column is kept as json, other binary values are base64 encoded by encoding/json
+func GetJsonColumnValue(v interface{}, colType string) interface{} {
+	bArr, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	if colType == "json" {
+		if json.Valid(bArr) {
+			return json.RawMessage(bArr)
+		}
+		return string(bArr)
+	}
+	return bArr
+}
+
+func GetRowImage(row []interface{}, colNames []FieldInfo, colsTypeName []string, colIdx []int, present []bool) *RowImage {
+	colIdx = GetPresentColumnIdx(present, colIdx, len(row))
+	image := &RowImage{Names: make([]string, len(colIdx)), Values: make([]interface{}, len(colIdx))}
+	for i, ci := range colIdx {
+		image.Names[i] = colNames[ci].FieldName
+		image.Values[i] = GetJsonColumnValue(row[ci], colsTypeName[ci])
+	}
+	return image
+}
+
+// GenRowChangeLinesForOneRowsEvent generates one line for each row of the rows event in -output-format
+func GenRowChangeLinesForOneRowsEvent(cfg *ConfCmd, ev *MyBinEvent, colNames []FieldInfo, colsTypeName []string, keyIdx []int, imageCols *RowImageColumns) []string {
+	var (
+		lines  []string
+		step   int = 1
+		before []interface{}
+		after  []interface{}
+	)
+	if ev.SqlType == "update" {
+		step = 2
+	}
+	for i := 0; i < len(ev.BinEvent.Rows); i += step {
+		before, after = nil, nil
+		switch ev.SqlType {
+		case "insert":
+			after = ev.BinEvent.Rows[i]
+		case "delete":
+			before = ev.BinEvent.Rows[i]
+		case "update":
+			before = ev.BinEvent.Rows[i]
+			after = ev.BinEvent.Rows[i+1]
+		}
+		rowEv := RowChangeEvent{
+			Database:  string(ev.BinEvent.Table.Schema),
+			Table:     string(ev.BinEvent.Table.Table),
+			Type:      ev.SqlType,
+			Binlog:    ev.MyPos.Name,
+			StartPos:  ev.StartPos,
+			EndPos:    ev.MyPos.Pos,
+			Timestamp: ev.Timestamp,
+			TrxIndex:  ev.TrxIndex,
+			Gtid:      ev.Gtid,
+			RowsQuery: ev.RowsQuery,
+		}
+		if before != nil {
+			rowEv.Before = GetRowImage(before, colNames, colsTypeName, nil, imageCols.Before)
+		}
+		if after != nil {
+			rowEv.After = GetRowImage(after, colNames, colsTypeName, nil, imageCols.After)
+		}
+		if len(keyIdx) > 0 {
+			// the after image of update may not contain the key with binlog_row_image=MINIMAL
+			if after != nil && (before == nil || IfColumnsPresent(imageCols.After, keyIdx)) {
+				rowEv.PrimaryKey = GetRowImage(after, colNames, colsTypeName, keyIdx, imageCols.After)
+			} else {
+				rowEv.PrimaryKey = GetRowImage(before, colNames, colsTypeName, keyIdx, imageCols.Before)
+			}
+		}
+		line, err := json.Marshal(&rowEv)
+		if err != nil {
+			log.Fatalf("fail to marshal %s row of %s at %s into json: %v", ev.SqlType,
+				GetAbsTableName(rowEv.Database, rowEv.Table), GetPosStr(ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos), err)
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// GetCsvColumnValue formats column value decoded from binlog for -output-format=csv|tsv. NULL is \N,
+// binary value is encoded by -binary-encoding
+func GetCsvColumnValue(cfg *ConfCmd, v interface{}, colType string) string {
+	switch val := v.(type) {
+	case nil:
+		return C_csvNullStr
+	case string:
+		return val
+	case []byte:
+		if colType == "json" {
+			return string(val)
+		}
+		if cfg.BinaryEncoding == "base64" {
+			return base64.StdEncoding.EncodeToString(val)
+		}
+		return hex.EncodeToString(val)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// EscapeTsvField escapes value like SELECT ... INTO OUTFILE
+func EscapeTsvField(field string) string {
+	field = strings.Replace(field, "\\", "\\\\", -1)
+	field = strings.Replace(field, "\t", "\\t", -1)
+	field = strings.Replace(field, "\n", "\\n", -1)
+	return strings.Replace(field, "\r", "\\r", -1)
+}
+
+// GetCsvLine joins fields as one line of csv or tsv, fields of tsv are escaped already
+func GetCsvLine(cfg *ConfCmd, fields []string) string {
+	if cfg.OutputFormat == C_outputFormatTsv {
+		return strings.Join(fields, "\t")
+	}
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.Write(fields)
+	csvWriter.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func GetCsvRowLine(cfg *ConfCmd, op string, row []interface{}, colsTypeName []string) string {
+	fields := make([]string, len(row)+1)
+	fields[0] = op
+	for ci, v := range row {
+		fields[ci+1] = GetCsvColumnValue(cfg, v, colsTypeName[ci])
+		if cfg.OutputFormat == C_outputFormatTsv && v != nil {
+			fields[ci+1] = EscapeTsvField(fields[ci+1])
+		}
+	}
+	return GetCsvLine(cfg, fields)
+}
+
+// GenCsvLinesForOneRowsEvent returns the header line and one line for each row image of the rows event.
+// the first column is op: insert, delete, update_before, update_after
+func GenCsvLinesForOneRowsEvent(cfg *ConfCmd, ev *MyBinEvent, colNames []FieldInfo, colsTypeName []string) (string, []string) {
+	var lines []string
+	if len(colNames) > len(colsTypeName) {
+		// the table struct has more columns than the rows event, the header has the columns in the rows only
+		colNames = colNames[:len(colsTypeName)]
+	}
+	fields := make([]string, len(colNames)+1)
+	fields[0] = "op"
+	for ci, col := range colNames {
+		fields[ci+1] = col.FieldName
+		if cfg.OutputFormat == C_outputFormatTsv {
+			fields[ci+1] = EscapeTsvField(col.FieldName)
+		}
+	}
+	header := GetCsvLine(cfg, fields)
+
+	for i := 0; i < len(ev.BinEvent.Rows); i++ {
+		if ev.SqlType == "update" {
+			lines = append(lines, GetCsvRowLine(cfg, "update_before", ev.BinEvent.Rows[i], colsTypeName))
+			i++
+			lines = append(lines, GetCsvRowLine(cfg, "update_after", ev.BinEvent.Rows[i], colsTypeName))
+		} else {
+			lines = append(lines, GetCsvRowLine(cfg, ev.SqlType, ev.BinEvent.Rows[i], colsTypeName))
+		}
+	}
+	return header, lines
+}
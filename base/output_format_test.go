@@ -0,0 +1,37 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestGenCsvLinesForOneRowsEvent(t *testing.T) {
+	// the table struct has column c added after the rows event
+	colNames := []FieldInfo{{FieldName: "id"}, {FieldName: "name"}, {FieldName: "c"}}
+	colsTypeName := []string{"int", "varchar"}
+	cases := []struct {
+		format   string
+		sqlType  string
+		rows     [][]interface{}
+		header   string
+		expected []string
+	}{
+		{C_outputFormatCsv, "insert", [][]interface{}{{int32(1), "a,b"}, {int32(2), nil}},
+			"op,id,name", []string{`insert,1,"a,b"`, `insert,2,\N`}},
+		{C_outputFormatTsv, "update", [][]interface{}{{int32(1), "a\tb"}, {int32(1), "c"}},
+			"op\tid\tname", []string{"update_before\t1\ta\\tb", "update_after\t1\tc"}},
+	}
+	for _, c := range cases {
+		cfg := &ConfCmd{OutputFormat: c.format}
+		ev := &MyBinEvent{SqlType: c.sqlType, BinEvent: &replication.RowsEvent{Rows: c.rows}}
+		header, lines := GenCsvLinesForOneRowsEvent(cfg, ev, colNames, colsTypeName)
+		if header != c.header {
+			t.Errorf("%s %s: header is %q, expected %q", c.format, c.sqlType, header, c.header)
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Errorf("%s %s: lines are %q, expected %q", c.format, c.sqlType, lines, c.expected)
+		}
+	}
+}
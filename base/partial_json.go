@@ -0,0 +1,458 @@
+package base
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	SQL "my2sql/sqlbuilder"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	// PARTIAL_UPDATE_ROWS_EVENT of MySQL 8.0 with binlog_row_value_options=PARTIAL_JSON, not defined in go-mysql
+	C_partialUpdateRowsEvent replication.EventType = 39
+
+	// value_options of the after image, json columns are logged as diffs
+	C_partialJsonUpdates uint64 = 1
+
+	C_jsonDiffReplace byte = 0
+	C_jsonDiffInsert  byte = 1
+	C_jsonDiffRemove  byte = 2
+
+	// table id of the table map event made up to decode the values of json diffs, it is never used by mysql
+	C_jsonDiffTableID uint64 = 0xFFFFFFFFFFFE
+	C_tableIDSize     int    = 6
+)
+
+var (
+	GJsonDiffOperations map[byte]string = map[byte]string{
+		C_jsonDiffReplace: "replace",
+		C_jsonDiffInsert:  "insert",
+		C_jsonDiffRemove:  "remove",
+	}
+)
+
+type JsonDiff struct {
+	Operation string          `json:"op"`
+	Path      string          `json:"path"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+// JsonPartialUpdate is the value of json column in the after image of PARTIAL_UPDATE_ROWS_EVENT,
+// only the changes of the json document are logged
+type JsonPartialUpdate struct {
+	Diffs []JsonDiff
+}
+
+func (this *JsonPartialUpdate) String() string {
+	diffs, _ := json.Marshal(this.Diffs)
+	return string(diffs)
+}
+
+func (this *JsonPartialUpdate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(this.Diffs)
+}
+
+// GetJsonDiffFuncName returns the json function to apply the diff. insert into array is JSON_ARRAY_INSERT,
+// as JSON_SET appends to the end of array
+func GetJsonDiffFuncName(diff JsonDiff) string {
+	switch diff.Operation {
+	case GJsonDiffOperations[C_jsonDiffRemove]:
+		return "JSON_REMOVE"
+	case GJsonDiffOperations[C_jsonDiffInsert]:
+		if strings.HasSuffix(diff.Path, "]") {
+			return "JSON_ARRAY_INSERT"
+		}
+	}
+	return "JSON_SET"
+}
+
+// GenSetExpression returns the expression to apply the diffs to the column, ex:
+// JSON_REMOVE(JSON_SET(`doc`, '$.a', JSON_EXTRACT('{"b": 1}', '$')), '$.c').
+// the value is json text, JSON_EXTRACT(value, '$') converts it into json as CAST(value AS JSON)
+func (this *JsonPartialUpdate) GenSetExpression(col SQL.NonAliasColumn) SQL.Expression {
+	var expr SQL.Expression = col
+	for i := 0; i < len(this.Diffs); {
+		funcName := GetJsonDiffFuncName(this.Diffs[i])
+		args := []SQL.Expression{expr}
+		// paths of json function are evaluated left to right, the same as the diffs
+		for ; i < len(this.Diffs) && GetJsonDiffFuncName(this.Diffs[i]) == funcName; i++ {
+			args = append(args, SQL.Literal(this.Diffs[i].Path))
+			if funcName != "JSON_REMOVE" {
+				args = append(args, SQL.SqlFunc("JSON_EXTRACT", SQL.Literal(string(this.Diffs[i].Value)), SQL.Literal("$")))
+			}
+		}
+		expr = SQL.SqlFunc(funcName, args...)
+	}
+	return expr
+}
+
+// GetPartialJsonColumns returns the json columns logged as diffs in the after image of any row, nil if none
+func GetPartialJsonColumns(rEv *replication.RowsEvent) []bool {
+	var partial []bool
+	for i := 1; i < len(rEv.Rows); i += 2 {
+		for j, v := range rEv.Rows[i] {
+			if _, ok := v.(*JsonPartialUpdate); !ok {
+				continue
+			}
+			if partial == nil {
+				partial = make([]bool, len(rEv.Rows[i]))
+			}
+			partial[j] = true
+		}
+	}
+	return partial
+}
+
+// GetColumnValueLen returns the length of column value in rows event, the same as decodeValue of go-mysql
+func GetColumnValueLen(data []byte, tp byte, meta uint16) (int, error) {
+	var n int
+	strLen := int(meta)
+	if tp == mysql.MYSQL_TYPE_STRING && meta >= 256 {
+		b0 := uint8(meta >> 8)
+		if b0&0x30 != 0x30 {
+			strLen = int(uint16(meta&0xFF) | (uint16((b0&0x30)^0x30) << 4))
+		} else {
+			strLen = int(meta & 0xFF)
+		}
+		tp = GetRealColumnType(tp, meta)
+	}
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		n = 0
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_YEAR:
+		n = 1
+	case mysql.MYSQL_TYPE_SHORT:
+		n = 2
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		n = 3
+	case mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_TIMESTAMP:
+		n = 4
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_DATETIME:
+		n = 8
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		n = GetDecimalBinSize(int(meta>>8), int(meta&0xFF))
+	case mysql.MYSQL_TYPE_BIT:
+		n = int((meta>>8)*8+meta&0xFF+7) / 8
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		n = 4 + int(meta+1)/2
+	case mysql.MYSQL_TYPE_DATETIME2:
+		n = 5 + int(meta+1)/2
+	case mysql.MYSQL_TYPE_TIME2:
+		n = 3 + int(meta+1)/2
+	case mysql.MYSQL_TYPE_ENUM, mysql.MYSQL_TYPE_SET:
+		n = int(meta & 0xFF)
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_STRING:
+		if strLen < 256 {
+			if len(data) < 1 {
+				return 0, fmt.Errorf("incomplete value of column type %d", tp)
+			}
+			n = 1 + int(data[0])
+		} else {
+			if len(data) < 2 {
+				return 0, fmt.Errorf("incomplete value of column type %d", tp)
+			}
+			n = 2 + int(binary.LittleEndian.Uint16(data))
+		}
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_JSON:
+		if meta < 1 || meta > 4 || len(data) < int(meta) {
+			return 0, fmt.Errorf("invalid blob value of column type %d, packlen %d", tp, meta)
+		}
+		n = int(meta) + int(mysql.FixedLengthInt(data[:meta]))
+	default:
+		return 0, fmt.Errorf("unsupport column type %d", tp)
+	}
+	if n > len(data) {
+		return 0, fmt.Errorf("incomplete value of column type %d, need %d bytes but got %d", tp, n, len(data))
+	}
+	return n, nil
+}
+
+// GetDecimalBinSize returns the length of decimal(precision, scale) in binary format
+func GetDecimalBinSize(precision int, scale int) int {
+	var compressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+	integral := precision - scale
+	return integral/9*4 + compressedBytes[integral%9] + scale/9*4 + compressedBytes[scale%9]
+}
+
+func IfBitSet(bitmap []byte, i int) bool {
+	return bitmap[i>>3]&(1<<(uint(i)&7)) > 0
+}
+
+func GetBitCount(bitmap []byte, colCnt int) int {
+	cnt := 0
+	for i := 0; i < colCnt; i++ {
+		if IfBitSet(bitmap, i) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// ConvertRowImage copies one row image of rows event and returns the length of it in data. the columns in partial
+// are json diffs, they are set NULL in the copied image and the diffs are returned
+func ConvertRowImage(data []byte, tbMap *replication.TableMapEvent, bitmap []byte, partial []bool) ([]byte, map[int][]byte, int, error) {
+	colCnt := int(tbMap.ColumnCount)
+	nullBitmapLen := (GetBitCount(bitmap, colCnt) + 7) / 8
+	if len(data) < nullBitmapLen {
+		return nil, nil, 0, fmt.Errorf("incomplete null bitmap of row")
+	}
+	nullBitmap := append([]byte{}, data[:nullBitmapLen]...)
+	pos := nullBitmapLen
+	var (
+		values []byte
+		diffs  map[int][]byte
+	)
+	nullIdx := 0
+	for i := 0; i < colCnt; i++ {
+		if !IfBitSet(bitmap, i) {
+			continue
+		}
+		if IfBitSet(nullBitmap, nullIdx) {
+			nullIdx++
+			continue
+		}
+		n, err := GetColumnValueLen(data[pos:], tbMap.ColumnType[i], tbMap.ColumnMeta[i])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if partial != nil && partial[i] {
+			// length of diffs(4 bytes) and diffs
+			if diffs == nil {
+				diffs = map[int][]byte{}
+			}
+			diffs[i] = data[pos+int(tbMap.ColumnMeta[i]) : pos+n]
+			nullBitmap[nullIdx>>3] |= 1 << (uint(nullIdx) & 7)
+		} else {
+			values = append(values, data[pos:pos+n]...)
+		}
+		nullIdx++
+		pos += n
+	}
+	return append(nullBitmap, values...), diffs, pos, nil
+}
+
+// ParseJsonDiffs parses the diffs of one json column, the values are in mysql json binary format
+func ParseJsonDiffs(data []byte) ([]JsonDiff, [][]byte, error) {
+	var (
+		diffs  []JsonDiff
+		values [][]byte
+		pos    int
+	)
+	for pos < len(data) {
+		op := data[pos]
+		pos++
+		opName, ok := GJsonDiffOperations[op]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown json diff operation %d", op)
+		}
+		path, _, n, err := mysql.LengthEncodedString(data[pos:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid path of json diff: %v", err)
+		}
+		pos += n
+		diffs = append(diffs, JsonDiff{Operation: opName, Path: string(path)})
+		if op == C_jsonDiffRemove {
+			continue
+		}
+		value, _, n, err := mysql.LengthEncodedString(data[pos:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value of json diff: %v", err)
+		}
+		pos += n
+		values = append(values, value)
+	}
+	return diffs, values, nil
+}
+
+// DecodeJsonValues decodes json binary values into json text by go-mysql, with a table map event of json columns
+// and a write rows event of the values made up
+func (this *Mysql8EventParser) DecodeJsonValues(values [][]byte) ([]string, error) {
+	colCnt := len(values)
+	bitmapLen := (colCnt + 7) / 8
+	tableID := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tableID, C_jsonDiffTableID)
+
+	// table id, flags, empty schema and table name, column count, types, meta and null bitmap
+	tbMapData := append([]byte{}, tableID[:C_tableIDSize]...)
+	tbMapData = append(tbMapData, 0, 0, 0, 0, 0, 0)
+	tbMapData = append(tbMapData, mysql.PutLengthEncodedInt(uint64(colCnt))...)
+	meta := make([]byte, colCnt)
+	for i := 0; i < colCnt; i++ {
+		tbMapData = append(tbMapData, mysql.MYSQL_TYPE_JSON)
+		meta[i] = 4
+	}
+	tbMapData = append(tbMapData, mysql.PutLengthEncodedString(meta)...)
+	tbMapData = append(tbMapData, make([]byte, bitmapLen)...)
+	_, err := this.parser.ParseEvent(&replication.EventHeader{EventType: replication.TABLE_MAP_EVENT}, tbMapData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// table id, flags without STMT_END, extra data length, column count, bitmap, null bitmap and values
+	rowsData := append([]byte{}, tableID[:C_tableIDSize]...)
+	rowsData = append(rowsData, 0, 0, 2, 0)
+	rowsData = append(rowsData, mysql.PutLengthEncodedInt(uint64(colCnt))...)
+	bitmap := make([]byte, bitmapLen)
+	for i := 0; i < colCnt; i++ {
+		bitmap[i>>3] |= 1 << (uint(i) & 7)
+	}
+	rowsData = append(rowsData, bitmap...)
+	rowsData = append(rowsData, make([]byte, bitmapLen)...)
+	for _, value := range values {
+		valueLen := make([]byte, 4)
+		binary.LittleEndian.PutUint32(valueLen, uint32(len(value)))
+		rowsData = append(rowsData, valueLen...)
+		rowsData = append(rowsData, value...)
+	}
+	e, err := this.parser.ParseEvent(&replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2}, rowsData, nil)
+	if err != nil {
+		return nil, err
+	}
+	rowsEvent := e.(*replication.RowsEvent)
+	if len(rowsEvent.Rows) != 1 {
+		return nil, fmt.Errorf("fail to decode values of json diffs")
+	}
+	texts := make([]string, colCnt)
+	for i, v := range rowsEvent.Rows[0] {
+		texts[i] = fmt.Sprintf("%v", v)
+	}
+	return texts, nil
+}
+
+// ConvertPartialUpdateRowsEvent converts PARTIAL_UPDATE_ROWS_EVENT into UPDATE_ROWS_EVENT, the json columns logged
+// as diffs are *JsonPartialUpdate in the after image. the format of after image is:
+// value_options, partial_bits(one bit for each json column of the table if value_options has PARTIAL_JSON_UPDATES),
+// null bitmap and values, value of json column in partial_bits is the diffs
+func (this *Mysql8EventParser) ConvertPartialUpdateRowsEvent(h *replication.EventHeader, body []byte) (*replication.BinlogEvent, error) {
+	if len(body) < C_tableIDSize+4 {
+		return nil, fmt.Errorf("invalid partial update rows event, size %d", len(body))
+	}
+	tableID := mysql.FixedLengthInt(body[:C_tableIDSize])
+	tbMapData, ok := this.tableMaps[tableID]
+	if !ok {
+		return nil, fmt.Errorf("invalid table id %d, no corresponding table map event", tableID)
+	}
+	e, err := this.parser.ParseEvent(&replication.EventHeader{EventType: replication.TABLE_MAP_EVENT}, tbMapData, nil)
+	if err != nil {
+		return nil, err
+	}
+	tbMap := e.(*replication.TableMapEvent)
+
+	// table id, flags, extra data(the length includes itself), column count, before and after bitmap
+	pos := C_tableIDSize + 2 + int(binary.LittleEndian.Uint16(body[C_tableIDSize+2:]))
+	colCnt, _, n := mysql.LengthEncodedInt(body[pos:])
+	pos += n
+	bitmapLen := (int(colCnt) + 7) / 8
+	if colCnt != tbMap.ColumnCount || pos+2*bitmapLen > len(body) {
+		return nil, fmt.Errorf("invalid partial update rows event of table id %d, column count %d", tableID, colCnt)
+	}
+	bitmap1 := body[pos : pos+bitmapLen]
+	bitmap2 := body[pos+bitmapLen : pos+2*bitmapLen]
+	pos += 2 * bitmapLen
+
+	var jsonCols []int
+	for i, tp := range tbMap.ColumnType {
+		if tp == mysql.MYSQL_TYPE_JSON {
+			jsonCols = append(jsonCols, i)
+		}
+	}
+
+	var (
+		converted []byte = append([]byte{}, body[:pos]...)
+		rowDiffs  []map[int][]byte
+		image     []byte
+		diffs     map[int][]byte
+	)
+	for pos < len(body) {
+		image, _, n, err = ConvertRowImage(body[pos:], tbMap, bitmap1, nil)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, image...)
+		pos += n
+
+		valueOptions, _, m := mysql.LengthEncodedInt(body[pos:])
+		pos += m
+		var partial []bool
+		if valueOptions&C_partialJsonUpdates > 0 {
+			partialBitsLen := (len(jsonCols) + 7) / 8
+			if pos+partialBitsLen > len(body) {
+				return nil, fmt.Errorf("incomplete partial bits of row")
+			}
+			partial = make([]bool, colCnt)
+			for k, idx := range jsonCols {
+				partial[idx] = IfBitSet(body[pos:], k)
+			}
+			pos += partialBitsLen
+		}
+		image, diffs, n, err = ConvertRowImage(body[pos:], tbMap, bitmap2, partial)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, image...)
+		pos += n
+		rowDiffs = append(rowDiffs, diffs)
+	}
+
+	// decode json values of all diffs of the event at once, in the order of rows and json columns
+	var (
+		partialUpdates []map[int]*JsonPartialUpdate = make([]map[int]*JsonPartialUpdate, len(rowDiffs))
+		values         [][]byte
+	)
+	for r, diffs := range rowDiffs {
+		partialUpdates[r] = map[int]*JsonPartialUpdate{}
+		for _, idx := range jsonCols {
+			data, ok := diffs[idx]
+			if !ok {
+				continue
+			}
+			jsonDiffs, diffValues, err := ParseJsonDiffs(data)
+			if err != nil {
+				return nil, fmt.Errorf("fail to parse json diffs of column %d: %v", idx, err)
+			}
+			partialUpdates[r][idx] = &JsonPartialUpdate{Diffs: jsonDiffs}
+			values = append(values, diffValues...)
+		}
+	}
+	var texts []string
+	if len(values) > 0 {
+		texts, err = this.DecodeJsonValues(values)
+		if err != nil {
+			return nil, fmt.Errorf("fail to decode values of json diffs: %v", err)
+		}
+	}
+
+	updateHeader := *h
+	updateHeader.EventType = replication.UPDATE_ROWS_EVENTv2
+	e, err = this.parser.ParseEvent(&updateHeader, converted, nil)
+	if err != nil {
+		return nil, err
+	}
+	rowsEvent := e.(*replication.RowsEvent)
+	if rowsEvent.Flags&replication.RowsEventStmtEndFlag > 0 {
+		this.tableMaps = map[uint64][]byte{}
+	}
+	if len(rowsEvent.Rows) != 2*len(rowDiffs) {
+		return nil, fmt.Errorf("fail to convert partial update rows event of table id %d", tableID)
+	}
+	k := 0
+	for r := range rowDiffs {
+		for _, idx := range jsonCols {
+			if partialUpdates[r][idx] == nil {
+				continue
+			}
+			for j := range partialUpdates[r][idx].Diffs {
+				if partialUpdates[r][idx].Diffs[j].Operation != GJsonDiffOperations[C_jsonDiffRemove] {
+					partialUpdates[r][idx].Diffs[j].Value = json.RawMessage(texts[k])
+					k++
+				}
+			}
+			rowsEvent.Rows[2*r+1][idx] = partialUpdates[r][idx]
+		}
+	}
+	return &replication.BinlogEvent{Header: &updateHeader, Event: rowsEvent}, nil
+}
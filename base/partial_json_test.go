@@ -0,0 +1,209 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	SQL "my2sql/sqlbuilder"
+)
+
+// json values in mysql binary format
+var (
+	testJsonInt2     = []byte{0x05, 0x02, 0x00}                                                            // 2
+	testJsonStrX     = []byte{0x0c, 0x01, 'x'}                                                             // "x"
+	testJsonTrue     = []byte{0x04, 0x01}                                                                  // true
+	testJsonObjectK1 = []byte{0x00, 0x01, 0x00, 0x0c, 0x00, 0x0b, 0x00, 0x01, 0x00, 0x05, 0x01, 0x00, 'k'} // {"k": 1}
+)
+
+// testJsonDiff returns one json diff in the format of PARTIAL_UPDATE_ROWS_EVENT: operation, path and value
+func testJsonDiff(op byte, path string, value []byte) []byte {
+	diff := append([]byte{op}, mysql.PutLengthEncodedString([]byte(path))...)
+	if op != C_jsonDiffRemove {
+		diff = append(diff, mysql.PutLengthEncodedString(value)...)
+	}
+	return diff
+}
+
+// diffs captured from: UPDATE t SET doc=JSON_SET(doc, '$.k', 2, '$.a."b.c"', 'x'), doc=JSON_ARRAY_INSERT(doc, '$.arr[0]', true),
+// doc=JSON_REMOVE(doc, '$."it”s"')
+var testJsonDiffs = bytes.Join([][]byte{
+	testJsonDiff(C_jsonDiffReplace, "$.k", testJsonInt2),
+	testJsonDiff(C_jsonDiffInsert, `$.a."b.c"`, testJsonStrX),
+	testJsonDiff(C_jsonDiffInsert, "$.arr[0]", testJsonTrue),
+	testJsonDiff(C_jsonDiffRemove, `$."it's"`, nil),
+}, nil)
+
+// testFormatDescription returns FORMAT_DESCRIPTION_EVENT of mysql 8.0 without checksum
+func testFormatDescription() []byte {
+	data := make([]byte, 2+50+4+1)
+	binary.LittleEndian.PutUint16(data, 4)
+	copy(data[2:], "8.0.32")
+	data[2+50+4] = replication.EventHeaderSize
+	postHeaderLens := make([]byte, 41)
+	postHeaderLens[replication.TABLE_MAP_EVENT-1] = 8
+	for _, tp := range []replication.EventType{replication.WRITE_ROWS_EVENTv2, replication.UPDATE_ROWS_EVENTv2,
+		replication.DELETE_ROWS_EVENTv2, C_partialUpdateRowsEvent} {
+		postHeaderLens[tp-1] = 10
+	}
+	data = append(data, postHeaderLens...)
+	data = append(data, replication.BINLOG_CHECKSUM_ALG_OFF, 0, 0, 0, 0)
+	return append(testEventHeader(replication.FORMAT_DESCRIPTION_EVENT, len(data)), data...)
+}
+
+func testEventHeader(tp replication.EventType, dataLen int) []byte {
+	h := make([]byte, replication.EventHeaderSize)
+	h[4] = byte(tp)
+	binary.LittleEndian.PutUint32(h[9:], uint32(replication.EventHeaderSize+dataLen))
+	return h
+}
+
+func newTestMysql8EventParser(t *testing.T) *Mysql8EventParser {
+	parser := NewMysql8EventParser(nil)
+	if err := parser.SetFormatDescription(testFormatDescription()); err != nil {
+		t.Fatalf("fail to set format description: %v", err)
+	}
+	return parser
+}
+
+func TestParseJsonDiffs(t *testing.T) {
+	diffs, values, err := ParseJsonDiffs(testJsonDiffs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDiffs := []JsonDiff{
+		{Operation: "replace", Path: "$.k"},
+		{Operation: "insert", Path: `$.a."b.c"`},
+		{Operation: "insert", Path: "$.arr[0]"},
+		{Operation: "remove", Path: `$."it's"`},
+	}
+	if !reflect.DeepEqual(diffs, expectedDiffs) {
+		t.Errorf("diffs are %+v, expected %+v", diffs, expectedDiffs)
+	}
+	expectedValues := [][]byte{testJsonInt2, testJsonStrX, testJsonTrue}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("values are %v, expected %v", values, expectedValues)
+	}
+
+	for _, data := range [][]byte{{9, 1, '$'}, {C_jsonDiffReplace, 3, '$'}, testJsonDiff(C_jsonDiffReplace, "$.k", testJsonInt2)[:6]} {
+		if _, _, err = ParseJsonDiffs(data); err == nil {
+			t.Errorf("error expected for invalid diffs %v", data)
+		}
+	}
+}
+
+func TestDecodeJsonValues(t *testing.T) {
+	parser := newTestMysql8EventParser(t)
+	texts, err := parser.DecodeJsonValues([][]byte{testJsonInt2, testJsonStrX, testJsonTrue, testJsonObjectK1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2", `"x"`, "true", `{"k":1}`}
+	if !reflect.DeepEqual(texts, expected) {
+		t.Errorf("texts are %q, expected %q", texts, expected)
+	}
+}
+
+func TestGenSetExpression(t *testing.T) {
+	cases := []struct {
+		name     string
+		diffs    []JsonDiff
+		expected string
+	}{
+		{"replace", []JsonDiff{{"replace", "$.k", []byte("2")}},
+			"JSON_SET(`doc`,'$.k',JSON_EXTRACT('2','$'))"},
+		{"nested path", []JsonDiff{{"replace", "$.a.b[1].c", []byte(`{"d": null}`)}},
+			"JSON_SET(`doc`,'$.a.b[1].c',JSON_EXTRACT('{" + `\"d\": null}','$'))`},
+		{"insert into object and array", []JsonDiff{{"insert", `$.a."b.c"`, []byte(`"x"`)}, {"insert", "$.arr[0]", []byte("true")}},
+			"JSON_ARRAY_INSERT(JSON_SET(`doc`," + `'$.a.\"b.c\"',JSON_EXTRACT('\"x\"','$')),'$.arr[0]',JSON_EXTRACT('true','$'))`},
+		{"remove escaped key", []JsonDiff{{"remove", `$."it's"`, nil}, {"remove", "$.c", nil}},
+			"JSON_REMOVE(`doc`," + `'$.\"it\'s\"','$.c')`},
+		{"adjacent diffs of the same function", []JsonDiff{{"replace", "$.a", []byte("1")}, {"insert", "$.b", []byte("2")},
+			{"remove", "$.c", nil}, {"replace", "$.d", []byte(`"it's"`)}},
+			"JSON_SET(JSON_REMOVE(JSON_SET(`doc`," + `'$.a',JSON_EXTRACT('1','$'),'$.b',JSON_EXTRACT('2','$')),'$.c'),'$.d',JSON_EXTRACT('\"it\'s\"','$'))`},
+	}
+	for _, c := range cases {
+		update := &JsonPartialUpdate{Diffs: c.diffs}
+		var buf bytes.Buffer
+		if err := update.GenSetExpression(SQL.BytesColumn("doc", SQL.NotNullable)).SerializeSql(&buf); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			t.Errorf("%s:\n got      %s\n expected %s", c.name, buf.String(), c.expected)
+		}
+	}
+}
+
+func TestConvertPartialUpdateRowsEvent(t *testing.T) {
+	parser := newTestMysql8EventParser(t)
+
+	// table map of db1.t1(id int, doc json)
+	tableID := []byte{1, 0, 0, 0, 0, 0}
+	tbMapData := append(append([]byte{}, tableID...), 0, 0)
+	tbMapData = append(tbMapData, 3, 'd', 'b', '1', 0, 2, 't', '1', 0)
+	tbMapData = append(tbMapData, 2, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON)
+	tbMapData = append(tbMapData, 1, 4, 0x02)
+	tbMapRaw := append(testEventHeader(replication.TABLE_MAP_EVENT, len(tbMapData)), tbMapData...)
+	h, err := parser.parser.ParseHeader(tbMapRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbMapEvent, err := parser.parser.ParseEvent(h, tbMapData, tbMapRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parser.GetEvents(&replication.BinlogEvent{Header: h, Event: tbMapEvent}, tbMapRaw); err != nil {
+		t.Fatal(err)
+	}
+
+	// before: id=1, doc={"k": 1}. after: id=1, doc is diffs
+	jsonLen := func(v []byte) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(len(v)))
+		return append(b, v...)
+	}
+	body := append(append([]byte{}, tableID...), byte(replication.RowsEventStmtEndFlag), 0, 2, 0, 2, 0x03, 0x03)
+	body = append(body, 0x00, 1, 0, 0, 0)
+	body = append(body, jsonLen(testJsonObjectK1)...)
+	body = append(body, byte(C_partialJsonUpdates), 0x01, 0x00, 1, 0, 0, 0)
+	body = append(body, jsonLen(testJsonDiffs)...)
+	raw := append(testEventHeader(C_partialUpdateRowsEvent, len(body)), body...)
+	h, err = parser.parser.ParseHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := parser.GetEvents(&replication.BinlogEvent{Header: h, Event: &replication.GenericEvent{Data: body}}, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Header.EventType != replication.UPDATE_ROWS_EVENTv2 {
+		t.Fatalf("partial update rows event is not converted into update rows event: %+v", events)
+	}
+	rowsEvent := events[0].Event.(*replication.RowsEvent)
+	if len(rowsEvent.Rows) != 2 {
+		t.Fatalf("rows are %v, expected before and after image", rowsEvent.Rows)
+	}
+	if rowsEvent.Rows[0][0] != int32(1) || fmt.Sprintf("%v", rowsEvent.Rows[0][1]) != `{"k":1}` {
+		t.Errorf("before image is %v", rowsEvent.Rows[0])
+	}
+	update, ok := rowsEvent.Rows[1][1].(*JsonPartialUpdate)
+	if rowsEvent.Rows[1][0] != int32(1) || !ok {
+		t.Fatalf("after image is %v", rowsEvent.Rows[1])
+	}
+	expected := `[{"op":"replace","path":"$.k","value":2},{"op":"insert","path":"$.a.\"b.c\"","value":"x"},` +
+		`{"op":"insert","path":"$.arr[0]","value":true},{"op":"remove","path":"$.\"it's\""}]`
+	if update.String() != expected {
+		t.Errorf("json diffs are %s, expected %s", update.String(), expected)
+	}
+	if partial := GetPartialJsonColumns(rowsEvent); !reflect.DeepEqual(partial, []bool{false, true}) {
+		t.Errorf("partial json columns are %v", partial)
+	}
+	if len(parser.tableMaps) != 0 {
+		t.Errorf("table maps are not cleared at the end of statement")
+	}
+}
@@ -0,0 +1,194 @@
+package base
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/klauspost/compress/zstd"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	// TRANSACTION_PAYLOAD_EVENT of MySQL 8.0.20+ with binlog_transaction_compression=ON, not defined in go-mysql
+	C_trxPayloadEvent replication.EventType = 40
+
+	// fields before the payload, every field is type, length and value, all are packed integers
+	C_payloadHeaderEndMark         uint64 = 0
+	C_payloadSizeField             uint64 = 1
+	C_payloadCompressionTypeField  uint64 = 2
+	C_payloadUncompressedSizeField uint64 = 3
+
+	C_payloadCompressionZstd uint64 = 0
+	C_payloadCompressionNone uint64 = 255
+)
+
+// Mysql8EventParser parses the events of MySQL 8.0 which are not supported by go-mysql: TRANSACTION_PAYLOAD_EVENT
+// is decompressed and the events of transaction in it are parsed, PARTIAL_UPDATE_ROWS_EVENT is converted into
+// UPDATE_ROWS_EVENT. the inner and converted events are not checksummed, so they are parsed by a parser of their own
+type Mysql8EventParser struct {
+	parser      *replication.BinlogParser
+	decoder     *zstd.Decoder
+	ifFormatSet bool
+	checksumLen int
+	tableMaps   map[uint64][]byte // table id => body of table map event, PARTIAL_UPDATE_ROWS_EVENT is decoded with it
+}
+
+// NewMysql8EventParser parses the events the same as the parser of file or repl mode, tsLocation is nil for file mode
+func NewMysql8EventParser(tsLocation *time.Location) *Mysql8EventParser {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		log.Fatalf("fail to create zstd decoder: %v", err)
+	}
+	parser := replication.NewBinlogParser()
+	parser.SetParseTime(false)
+	parser.SetUseDecimal(false)
+	parser.SetVerifyChecksum(false)
+	if tsLocation != nil {
+		parser.SetTimestampStringLocation(tsLocation)
+	}
+	return &Mysql8EventParser{parser: parser, decoder: decoder, tableMaps: map[uint64][]byte{}}
+}
+
+// SetFormatDescription is called with every FORMAT_DESCRIPTION_EVENT, the checksum algorithm is changed to OFF
+func (this *Mysql8EventParser) SetFormatDescription(rawData []byte) error {
+	h, err := this.parser.ParseHeader(rawData)
+	if err != nil {
+		return err
+	}
+	data := append([]byte{}, rawData[replication.EventHeaderSize:]...)
+	this.checksumLen = 0
+	if len(data) >= 5 {
+		// checksum algorithm(1 byte) and checksum(4 bytes) are at the end of format description event
+		if data[len(data)-5] == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+			this.checksumLen = replication.BinlogChecksumLength
+		}
+		data[len(data)-5] = replication.BINLOG_CHECKSUM_ALG_OFF
+	}
+	if _, err = this.parser.ParseEvent(h, data, rawData); err != nil {
+		return err
+	}
+	this.ifFormatSet = true
+	return nil
+}
+
+// GetEvents returns the events of transaction in TRANSACTION_PAYLOAD_EVENT, or the event itself for other events.
+// a compressed transaction can only be located as a whole, so the inner events take the position and size of the payload event
+func (this *Mysql8EventParser) GetEvents(ev *replication.BinlogEvent, rawData []byte) ([]*replication.BinlogEvent, error) {
+	switch ev.Header.EventType {
+	case replication.FORMAT_DESCRIPTION_EVENT:
+		if err := this.SetFormatDescription(rawData); err != nil {
+			return nil, err
+		}
+		return []*replication.BinlogEvent{ev}, nil
+	case C_trxPayloadEvent:
+	default:
+		if len(rawData) < replication.EventHeaderSize+this.checksumLen {
+			return nil, fmt.Errorf("invalid event size %d", len(rawData))
+		}
+		oneEvent, err := this.HandleEvent(ev, rawData[replication.EventHeaderSize:len(rawData)-this.checksumLen])
+		if err != nil {
+			return nil, err
+		}
+		return []*replication.BinlogEvent{oneEvent}, nil
+	}
+	if !this.ifFormatSet {
+		return nil, fmt.Errorf("no format description event before transaction payload event")
+	}
+	genericEvent, ok := ev.Event.(*replication.GenericEvent)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transaction payload event %T", ev.Event)
+	}
+	compressionType, payload, err := ParseTrxPayloadFields(genericEvent.Data)
+	if err != nil {
+		return nil, err
+	}
+	var uncompressed []byte
+	switch compressionType {
+	case C_payloadCompressionZstd:
+		uncompressed, err = this.decoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("fail to decompress transaction payload: %v", err)
+		}
+	case C_payloadCompressionNone:
+		uncompressed = payload
+	default:
+		return nil, fmt.Errorf("unsupported compression type %d of transaction payload", compressionType)
+	}
+
+	var events []*replication.BinlogEvent
+	for pos := 0; pos < len(uncompressed); {
+		if len(uncompressed)-pos < replication.EventHeaderSize {
+			return nil, fmt.Errorf("incomplete event header in transaction payload at offset %d", pos)
+		}
+		h, err := this.parser.ParseHeader(uncompressed[pos:])
+		if err != nil {
+			return nil, err
+		}
+		eventSize := int(h.EventSize)
+		if eventSize < replication.EventHeaderSize || pos+eventSize > len(uncompressed) {
+			return nil, fmt.Errorf("invalid event size %d in transaction payload at offset %d", eventSize, pos)
+		}
+		rawData := uncompressed[pos : pos+eventSize]
+		e, err := this.parser.ParseEvent(h, rawData[replication.EventHeaderSize:], rawData)
+		if err != nil {
+			return nil, err
+		}
+		h.LogPos = ev.Header.LogPos
+		h.EventSize = ev.Header.EventSize
+		oneEvent, err := this.HandleEvent(&replication.BinlogEvent{Header: h, Event: e}, rawData[replication.EventHeaderSize:])
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, oneEvent)
+		pos += eventSize
+	}
+	return events, nil
+}
+
+// HandleEvent keeps the table map events for PARTIAL_UPDATE_ROWS_EVENT and converts it, body is the event data without checksum
+func (this *Mysql8EventParser) HandleEvent(ev *replication.BinlogEvent, body []byte) (*replication.BinlogEvent, error) {
+	switch e := ev.Event.(type) {
+	case *replication.TableMapEvent:
+		this.tableMaps[e.TableID] = append([]byte{}, body...)
+	case *replication.RowsEvent:
+		if e.Flags&replication.RowsEventStmtEndFlag > 0 {
+			// the same as go-mysql, table map events are cleared at the end of statement
+			this.tableMaps = map[uint64][]byte{}
+		}
+	case *replication.GenericEvent:
+		if ev.Header.EventType == C_partialUpdateRowsEvent {
+			return this.ConvertPartialUpdateRowsEvent(ev.Header, body)
+		}
+	}
+	return ev, nil
+}
+
+// ParseTrxPayloadFields returns the compression type and the payload
+func ParseTrxPayloadFields(data []byte) (uint64, []byte, error) {
+	var (
+		compressionType uint64 = C_payloadCompressionNone
+		pos             int
+	)
+	for pos < len(data) {
+		fieldType, _, n := mysql.LengthEncodedInt(data[pos:])
+		pos += n
+		if fieldType == C_payloadHeaderEndMark {
+			return compressionType, data[pos:], nil
+		}
+		if pos >= len(data) {
+			break
+		}
+		fieldLen, _, n := mysql.LengthEncodedInt(data[pos:])
+		pos += n
+		if pos+int(fieldLen) > len(data) {
+			break
+		}
+		if fieldType == C_payloadCompressionTypeField {
+			compressionType, _, _ = mysql.LengthEncodedInt(data[pos : pos+int(fieldLen)])
+		}
+		pos += int(fieldLen)
+	}
+	return 0, nil, fmt.Errorf("invalid transaction payload event, header end mark not found")
+}
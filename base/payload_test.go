@@ -0,0 +1,122 @@
+package base
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/klauspost/compress/zstd"
+)
+
+// testInnerEvent returns the event in transaction payload, with the timestamp of its own and log pos 0
+func testInnerEvent(tp replication.EventType, timestamp uint32, data []byte) []byte {
+	h := testEventHeader(tp, len(data))
+	binary.LittleEndian.PutUint32(h, timestamp)
+	return append(h, data...)
+}
+
+// testTrxPayload returns the transaction of db1.t1(id int, doc json): table map, insert of id=1, doc={"k": 1} and xid
+func testTrxPayload() []byte {
+	tableID := []byte{1, 0, 0, 0, 0, 0}
+	tbMapData := append(append([]byte{}, tableID...), 0, 0)
+	tbMapData = append(tbMapData, 3, 'd', 'b', '1', 0, 2, 't', '1', 0)
+	tbMapData = append(tbMapData, 2, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON)
+	tbMapData = append(tbMapData, 1, 4, 0x02)
+
+	rowsData := append(append([]byte{}, tableID...), byte(replication.RowsEventStmtEndFlag), 0, 2, 0, 2, 0x03)
+	rowsData = append(rowsData, 0x00, 1, 0, 0, 0, byte(len(testJsonObjectK1)), 0, 0, 0)
+	rowsData = append(rowsData, testJsonObjectK1...)
+
+	xidData := make([]byte, 8)
+	binary.LittleEndian.PutUint64(xidData, 99)
+
+	payload := testInnerEvent(replication.TABLE_MAP_EVENT, 1700000001, tbMapData)
+	payload = append(payload, testInnerEvent(replication.WRITE_ROWS_EVENTv2, 1700000001, rowsData)...)
+	return append(payload, testInnerEvent(replication.XID_EVENT, 1700000002, xidData)...)
+}
+
+// testTrxPayloadEventData returns the data of TRANSACTION_PAYLOAD_EVENT: payload size, compression type,
+// uncompressed size, the end mark and the payload
+func testTrxPayloadEventData(compressionType uint64, payload []byte, uncompressedSize int) []byte {
+	field := func(tp uint64, v uint64) []byte {
+		value := mysql.PutLengthEncodedInt(v)
+		return append(append(mysql.PutLengthEncodedInt(tp), mysql.PutLengthEncodedInt(uint64(len(value)))...), value...)
+	}
+	data := field(C_payloadSizeField, uint64(len(payload)))
+	data = append(data, field(C_payloadCompressionTypeField, compressionType)...)
+	data = append(data, field(C_payloadUncompressedSizeField, uint64(uncompressedSize))...)
+	data = append(data, mysql.PutLengthEncodedInt(C_payloadHeaderEndMark)...)
+	return append(data, payload...)
+}
+
+func TestGetEventsOfTrxPayload(t *testing.T) {
+	uncompressed := testTrxPayload()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := encoder.EncodeAll(uncompressed, nil)
+	encoder.Close()
+
+	cases := []struct {
+		name            string
+		compressionType uint64
+		payload         []byte
+	}{
+		{"zstd", C_payloadCompressionZstd, compressed},
+		{"not compressed", C_payloadCompressionNone, uncompressed},
+	}
+	for _, c := range cases {
+		parser := newTestMysql8EventParser(t)
+		data := testTrxPayloadEventData(c.compressionType, c.payload, len(uncompressed))
+		raw := append(testEventHeader(C_trxPayloadEvent, len(data)), data...)
+		h, err := parser.parser.ParseHeader(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the payload event ends at 1000 in the binlog
+		h.LogPos = 1000
+		events, err := parser.GetEvents(&replication.BinlogEvent{Header: h, Event: &replication.GenericEvent{Data: data}}, raw)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		expectedTypes := []replication.EventType{replication.TABLE_MAP_EVENT, replication.WRITE_ROWS_EVENTv2, replication.XID_EVENT}
+		if len(events) != len(expectedTypes) {
+			t.Fatalf("%s: %d events, expected %d", c.name, len(events), len(expectedTypes))
+		}
+		for i, ev := range events {
+			if ev.Header.EventType != expectedTypes[i] {
+				t.Errorf("%s: event %d is %s, expected %s", c.name, i, ev.Header.EventType, expectedTypes[i])
+			}
+			// the inner events are located by the payload event, with their own timestamp
+			if ev.Header.LogPos != 1000 || ev.Header.EventSize != uint32(len(raw)) {
+				t.Errorf("%s: %s ends at %d with size %d, expected 1000 and %d", c.name, ev.Header.EventType, ev.Header.LogPos, ev.Header.EventSize, len(raw))
+			}
+		}
+		if events[0].Header.Timestamp != 1700000001 || events[2].Header.Timestamp != 1700000002 {
+			t.Errorf("%s: timestamps are %d and %d, expected the ones of the inner events", c.name, events[0].Header.Timestamp, events[2].Header.Timestamp)
+		}
+
+		rowsEvent := events[1].Event.(*replication.RowsEvent)
+		if string(rowsEvent.Table.Schema) != "db1" || string(rowsEvent.Table.Table) != "t1" {
+			t.Errorf("%s: table of rows event is %s.%s", c.name, rowsEvent.Table.Schema, rowsEvent.Table.Table)
+		}
+		if len(rowsEvent.Rows) != 1 || rowsEvent.Rows[0][0] != int32(1) || fmt.Sprintf("%v", rowsEvent.Rows[0][1]) != `{"k":1}` {
+			t.Errorf("%s: rows are %v", c.name, rowsEvent.Rows)
+		}
+		if xid := events[2].Event.(*replication.XIDEvent).XID; xid != 99 {
+			t.Errorf("%s: xid is %d, expected 99", c.name, xid)
+		}
+	}
+
+	// the payload cannot be parsed without format description event
+	parser := NewMysql8EventParser(nil)
+	data := testTrxPayloadEventData(C_payloadCompressionNone, uncompressed, len(uncompressed))
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: C_trxPayloadEvent}, Event: &replication.GenericEvent{Data: data}}
+	if _, err = parser.GetEvents(ev, nil); err == nil {
+		t.Errorf("error expected without format description event")
+	}
+}
@@ -0,0 +1,58 @@
+package base
+
+import (
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	C_unknownMasterBinlog = "unknown"
+)
+
+// RelayLogState tracks the coordinates of relay log with -mode=relaylog. the end_log_pos of events copied from master
+// is the position in the binlog of master, the position in relay log is counted by event size.
+// the name of master binlog is logged in the ROTATE_EVENT from master
+type RelayLogState struct {
+	MasterBinlog   string
+	MasterStartPos uint32 // start position of the current event in master binlog
+	MasterStopPos  uint32 // end position of the current event in master binlog, 0 for events not from master
+	relayServerID  uint32 // server id of the replica, it writes the first FORMAT_DESCRIPTION_EVENT of relay log
+	relayPos       uint32
+}
+
+func NewRelayLogState() *RelayLogState {
+	return &RelayLogState{MasterBinlog: C_unknownMasterBinlog}
+}
+
+// StartRelayLog is called before parsing each relay log file, the position is after the 4 bytes magic number
+func (this *RelayLogState) StartRelayLog() {
+	this.relayServerID = 0
+	this.relayPos = uint32(len(replication.BinLogFileHeader))
+}
+
+// HandleEvent replaces the end position of event header with the position in relay log, it returns true
+// if the event is ROTATE_EVENT, which is skipped. the master one changes the master binlog, the relay one
+// rotates to the next relay log, the relay log files are parsed by sequence
+func (this *RelayLogState) HandleEvent(h *replication.EventHeader, e replication.Event) bool {
+	this.relayPos += h.EventSize
+	this.MasterStopPos = h.LogPos
+	if h.LogPos >= h.EventSize {
+		this.MasterStartPos = h.LogPos - h.EventSize
+	} else {
+		// artificial events have no position in master binlog
+		this.MasterStartPos = 0
+	}
+	h.LogPos = this.relayPos
+
+	switch h.EventType {
+	case replication.FORMAT_DESCRIPTION_EVENT:
+		if this.relayServerID == 0 {
+			this.relayServerID = h.ServerID
+		}
+	case replication.ROTATE_EVENT:
+		if h.ServerID != this.relayServerID {
+			this.MasterBinlog = string(e.(*replication.RotateEvent).NextLogName)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestRelayLogStateHandleEvent(t *testing.T) {
+	const (
+		masterID  uint32 = 1
+		replicaID uint32 = 2
+	)
+	rotate := func(next string) *replication.RotateEvent {
+		return &replication.RotateEvent{Position: 4, NextLogName: []byte(next)}
+	}
+	// two relay logs of the replica, each starts with the format description of the replica, then the rotate and
+	// format description of master, which are not in master binlog
+	steps := []struct {
+		name         string
+		relayLog     bool // a new relay log file starts
+		tp           replication.EventType
+		serverID     uint32
+		logPos       uint32
+		size         uint32
+		event        replication.Event
+		skipped      bool
+		masterBinlog string
+		masterStart  uint32
+		masterStop   uint32
+		relayPos     uint32
+	}{
+		{"relay format description", true, replication.FORMAT_DESCRIPTION_EVENT, replicaID, 0, 120, nil, false, C_unknownMasterBinlog, 0, 0, 124},
+		{"master rotate", false, replication.ROTATE_EVENT, masterID, 0, 48, rotate("mysql-bin.000005"), true, "mysql-bin.000005", 0, 0, 172},
+		{"master format description", false, replication.FORMAT_DESCRIPTION_EVENT, masterID, 0, 120, nil, false, "mysql-bin.000005", 0, 0, 292},
+		{"begin", false, replication.QUERY_EVENT, masterID, 1080, 80, nil, false, "mysql-bin.000005", 1000, 1080, 372},
+		{"rows", false, replication.WRITE_ROWS_EVENTv2, masterID, 1180, 100, nil, false, "mysql-bin.000005", 1080, 1180, 472},
+		{"xid", false, replication.XID_EVENT, masterID, 1211, 31, nil, false, "mysql-bin.000005", 1180, 1211, 503},
+		// the relay rotate does not change master binlog
+		{"relay rotate", false, replication.ROTATE_EVENT, replicaID, 547, 44, rotate("relay-bin.000002"), true, "mysql-bin.000005", 503, 547, 547},
+
+		{"next relay format description", true, replication.FORMAT_DESCRIPTION_EVENT, replicaID, 0, 120, nil, false, "mysql-bin.000005", 0, 0, 124},
+		{"master rotate at the position", false, replication.ROTATE_EVENT, masterID, 0, 48, rotate("mysql-bin.000005"), true, "mysql-bin.000005", 0, 0, 172},
+		{"rows", false, replication.WRITE_ROWS_EVENTv2, masterID, 1311, 100, nil, false, "mysql-bin.000005", 1211, 1311, 272},
+		// master rotates to the next binlog, the rotate is logged in master binlog
+		{"master rotate to next binlog", false, replication.ROTATE_EVENT, masterID, 1355, 44, rotate("mysql-bin.000006"), true, "mysql-bin.000006", 1311, 1355, 316},
+		{"rows of next binlog", false, replication.WRITE_ROWS_EVENTv2, masterID, 254, 100, nil, false, "mysql-bin.000006", 154, 254, 416},
+	}
+	state := NewRelayLogState()
+	for _, s := range steps {
+		if s.relayLog {
+			state.StartRelayLog()
+		}
+		h := &replication.EventHeader{EventType: s.tp, ServerID: s.serverID, LogPos: s.logPos, EventSize: s.size}
+		skipped := state.HandleEvent(h, s.event)
+		if skipped != s.skipped || state.MasterBinlog != s.masterBinlog || state.MasterStartPos != s.masterStart || state.MasterStopPos != s.masterStop {
+			t.Errorf("%s: got skipped=%v master=%s:%d-%d, expected skipped=%v master=%s:%d-%d", s.name, skipped,
+				state.MasterBinlog, state.MasterStartPos, state.MasterStopPos, s.skipped, s.masterBinlog, s.masterStart, s.masterStop)
+		}
+		if h.LogPos != s.relayPos {
+			t.Errorf("%s: end position in relay log is %d, expected %d", s.name, h.LogPos, s.relayPos)
+		}
+	}
+}
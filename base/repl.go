@@ -12,13 +12,24 @@ import (
 
 func ParserAllBinEventsFromRepl(cfg *ConfCmd) {
 	defer cfg.CloseChan()
-	cfg.BinlogStreamer = NewReplBinlogStreamer(cfg)
+	cfg.HandleStopSignal()
+	LocateStartBinlogRepl(cfg)
+	cfg.BinlogSyncer, cfg.BinlogStreamer = NewReplBinlogStreamer(cfg)
 	log.Info("start to get binlog from mysql")
 	SendBinlogEventRepl(cfg)
 	log.Info("finish getting binlog from mysql")
 }
 
-func NewReplBinlogStreamer(cfg *ConfCmd) *replication.BinlogStreamer {
+func NewReplBinlogStreamer(cfg *ConfCmd) (*replication.BinlogSyncer, *replication.BinlogStreamer) {
+	replSyncer, replStreamer, err := StartReplBinlogStreamer(cfg, mysql.Position{})
+	if err != nil {
+		log.Fatalf(fmt.Sprintf("error replication from master %s:%d %v", cfg.Host, cfg.Port, err))
+	}
+	return replSyncer, replStreamer
+}
+
+// StartReplBinlogStreamer replicates from syncPos, or from the start position of options if syncPos is empty
+func StartReplBinlogStreamer(cfg *ConfCmd, syncPos mysql.Position) (*replication.BinlogSyncer, *replication.BinlogStreamer, error) {
 	replCfg := replication.BinlogSyncerConfig{
 		ServerID:                uint32(cfg.ServerId),
 		Flavor:                  cfg.MysqlType,
@@ -32,15 +43,41 @@ func NewReplBinlogStreamer(cfg *ConfCmd) *replication.BinlogStreamer {
 		ParseTime:               false, //donot parse mysql datetime/time column into go time structure, take it as string
 		UseDecimal:              false, // sqlbuilder not support decimal type
 	}
+	if cfg.Follow {
+		// detect dead master by heartbeat, reconnect from the end of the last transaction by ourselves
+		replCfg.HeartbeatPeriod = C_followHeartbeatPeriod
+		replCfg.ReadTimeout = C_followReadTimeout
+		replCfg.DisableRetrySync = true
+	}
 
 	replSyncer := replication.NewBinlogSyncer(replCfg)
 
-	syncPosition := mysql.Position{Name: cfg.StartFile, Pos: uint32(cfg.StartPos)}
-	replStreamer, err := replSyncer.StartSync(syncPosition)
-	if err != nil {
-		log.Fatalf(fmt.Sprintf("error replication from master %s:%d %v", cfg.Host, cfg.Port, err))
+	var (
+		replStreamer *replication.BinlogStreamer
+		err          error
+	)
+	if syncPos.Name != "" {
+		replStreamer, err = replSyncer.StartSync(syncPos)
+	} else if cfg.GtidState.StartGtid != "" || cfg.GtidState.StartGtidSet != nil {
+		if cfg.FromDB == nil {
+			if cfg.FromDB, err = CreateMysqlCon(GetMysqlUrl(cfg)); err != nil {
+				replSyncer.Close()
+				return nil, nil, fmt.Errorf("fail to connect to mysql to get gtid_purged: %v", err)
+			}
+		}
+		var gtidPurged *mysql.MysqlGTIDSet
+		if gtidPurged, err = GetMysqlGtidPurged(cfg.FromDB); err != nil {
+			replSyncer.Close()
+			return nil, nil, fmt.Errorf("fail to get gtid_purged of mysql: %v", err)
+		}
+		syncGtidSet := cfg.GtidState.GetSyncGtidSet(gtidPurged)
+		log.Infof("start to replicate from mysql with executed gtid set: %s", syncGtidSet.String())
+		replStreamer, err = replSyncer.StartSyncGTID(syncGtidSet)
+	} else {
+		syncPosition := mysql.Position{Name: cfg.StartFile, Pos: uint32(cfg.StartPos)}
+		replStreamer, err = replSyncer.StartSync(syncPosition)
 	}
-	return replStreamer
+	return replSyncer, replStreamer, err
 }
 
 func SendBinlogEventRepl(cfg *ConfCmd) {
@@ -61,112 +98,132 @@ func SendBinlogEventRepl(cfg *ConfCmd) {
 		rowCnt  uint32 = 0
 
 		tbMapPos uint32 = 0
+		replPos  *ReplSyncPosition = &ReplSyncPosition{SyncPos: mysql.Position{Name: cfg.StartFile, Pos: uint32(cfg.StartPos)}}
+		evArr    []*replication.BinlogEvent
+		mysql8Parser  *Mysql8EventParser = NewMysql8EventParser(GBinlogTimeLocation)
 
 		//justStart   bool = true
 		//orgSqlEvent *replication.RowsQueryEvent
 	)
+EventLoop:
 	for {
 
-		if cfg.OutputToScreen {
-			ev, err = cfg.BinlogStreamer.GetEvent(context.Background())
-			if err != nil {
-				log.Fatalf(fmt.Sprintf("error to get binlog event"))
-				break
-			}
-		} else{
-			ctx, cancel := context.WithTimeout(context.Background(), EventTimeout)
+		if cfg.OutputToScreen || cfg.Follow {
+			ev, err = cfg.BinlogStreamer.GetEvent(cfg.StopCtx)
+		} else {
+			ctx, cancel := context.WithTimeout(cfg.StopCtx, EventTimeout)
 			ev, err = cfg.BinlogStreamer.GetEvent(ctx)
 			cancel()
-			if err == context.Canceled {
+		}
+		if err != nil {
+			if cfg.StopCtx.Err() != nil {
 				log.Infof("ready to quit! [%v]", err)
 				break
 			} else if err == context.DeadlineExceeded {
 				log.Infof("deadline exceeded.")
 				break
-			} else if err !=nil {
-				log.Fatalf(fmt.Sprintf("error to get binlog event %v",err))
-				break
+			} else if cfg.Follow {
+				log.Errorf("error to get binlog event %v", err)
+				if !ReconnectReplBinlogStreamer(cfg, replPos) {
+					log.Infof("ready to quit!")
+					break
+				}
+				currentBinlog = replPos.SyncPos.Name
+				continue
 			}
+			log.Fatalf(fmt.Sprintf("error to get binlog event %v", err))
+			break
 		}
 
-		if ev.Header.EventType == replication.TABLE_MAP_EVENT {
-			tbMapPos = ev.Header.LogPos - ev.Header.EventSize 
-			// avoid mysqlbing mask the row event as unknown table row event
+		// events of compressed transaction are in TRANSACTION_PAYLOAD_EVENT, PARTIAL_UPDATE_ROWS_EVENT is converted into UPDATE_ROWS_EVENT
+		evArr, err = mysql8Parser.GetEvents(ev, ev.RawData)
+		if err != nil {
+			log.Fatalf("fail to parse binlog event at %s %d: %v", currentBinlog, ev.Header.LogPos, err)
 		}
 		ev.RawData = []byte{} // we donnot need raw data
+		for _, ev = range evArr {
+			if ev.Header.EventType == replication.TABLE_MAP_EVENT {
+				tbMapPos = ev.Header.LogPos - ev.Header.EventSize 
+				// avoid mysqlbing mask the row event as unknown table row event
+			}
 
-		oneMyEvent := &MyBinEvent{MyPos: mysql.Position{Name: currentBinlog, Pos: ev.Header.LogPos}, StartPos: tbMapPos}
-		chkRe = oneMyEvent.CheckBinEvent(cfg, ev, &currentBinlog)
-		
-		if chkRe == C_reContinue {
-			continue
-		} else if chkRe == C_reBreak {
-			break
-		} else if chkRe == C_reFileEnd {
-			continue
-		}
+			oneMyEvent := &MyBinEvent{MyPos: mysql.Position{Name: currentBinlog, Pos: ev.Header.LogPos}, StartPos: tbMapPos}
+			chkRe = oneMyEvent.CheckBinEvent(cfg, ev, &currentBinlog)
+			if cfg.Follow && chkRe != C_reBreak {
+				replPos.Update(ev, currentBinlog)
+			}
 
-		db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(ev)
-		//if find := strings.Contains(db, "#"); find {
-		//	log.Fatalf(fmt.Sprintf("Unsupported database name %s contains special character '#'", db))
-		//	break
-		//}
-		//if find := strings.Contains(tb, "#"); find {
-		//	log.Fatalf(fmt.Sprintf("Unsupported table name %s.%s contains special character '#'", db, tb))
-		//	break
-		//}
+			if chkRe == C_reContinue {
+				continue
+			} else if chkRe == C_reBreak {
+				break EventLoop
+			} else if chkRe == C_reFileEnd {
+				continue
+			}
+
+			db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(ev)
+			//if find := strings.Contains(db, "#"); find {
+			//	log.Fatalf(fmt.Sprintf("Unsupported database name %s contains special character '#'", db))
+			//	break
+			//}
+			//if find := strings.Contains(tb, "#"); find {
+			//	log.Fatalf(fmt.Sprintf("Unsupported table name %s.%s contains special character '#'", db, tb))
+			//	break
+			//}
 	
-		if sqlType == "query" {
-			sqlLower = strings.ToLower(sql)
-			if sqlLower == "begin" {
-				trxStatus = C_trxBegin
-				trxIndex++
-			} else if sqlLower == "commit" {
-				trxStatus = C_trxCommit
-			} else if sqlLower == "rollback" {
-				trxStatus = C_trxRollback
-			} else if oneMyEvent.QuerySql != nil  {
+			if sqlType == "query" {
+				sqlLower = strings.ToLower(sql)
+				if sqlLower == "begin" {
+					trxStatus = C_trxBegin
+					trxIndex++
+				} else if sqlLower == "commit" {
+					trxStatus = C_trxCommit
+				} else if sqlLower == "rollback" {
+					trxStatus = C_trxRollback
+				} else if oneMyEvent.QuerySql != nil  {
+					trxStatus = C_trxProcess
+					rowCnt = 1
+				}
+
+			} else {
 				trxStatus = C_trxProcess
-				rowCnt = 1
 			}
 
-		} else {
-			trxStatus = C_trxProcess
-		}
-
-		if cfg.WorkType != "stats" {
-			ifSendEvent := false
-			if oneMyEvent.IfRowsEvent {
-
-				tbKey := GetAbsTableName(string(oneMyEvent.BinEvent.Table.Schema),
-						string(oneMyEvent.BinEvent.Table.Table))
-				_, err = G_TablesColumnsInfo.GetTableInfoJson(string(oneMyEvent.BinEvent.Table.Schema),
-						string(oneMyEvent.BinEvent.Table.Table))
-				if err != nil {
-					log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
-							tbKey, oneMyEvent.MyPos.String()))
+			if cfg.WorkType != "stats" {
+				ifSendEvent := false
+				if oneMyEvent.IfRowsEvent {
+					// the worker looks up the table struct, the rows event is skipped and reported there if it is missing
+					ifSendEvent = true
+				} else if cfg.CheckpointFile != "" && sqlType == "query" && trxStatus == C_trxCommit {
+					// checkpoint is recorded at commit of transaction
+					ifSendEvent = true
+				} else if cfg.OutputFormat == C_outputFormatCanal && oneMyEvent.QuerySql != nil {
+					// ddl is output as flat message with isDdl=true, statement based dml with isDdl=false
+					ifSendEvent = true
+				} else if oneMyEvent.QuerySql != nil && oneMyEvent.QuerySql.IsDml() {
+					// statement based dml is output as it is
+					ifSendEvent = true
 				}
-				ifSendEvent = true
-			}
-			if ifSendEvent {
-				binEventIdx++
-				oneMyEvent.EventIdx = binEventIdx
-				oneMyEvent.SqlType = sqlType
-				oneMyEvent.Timestamp = ev.Header.Timestamp
-				oneMyEvent.TrxIndex = trxIndex
-				oneMyEvent.TrxStatus = trxStatus
-				cfg.EventChan <- *oneMyEvent
-			}
-		} 
+				if ifSendEvent {
+					binEventIdx++
+					oneMyEvent.EventIdx = binEventIdx
+					oneMyEvent.SqlType = sqlType
+					oneMyEvent.Timestamp = ev.Header.Timestamp
+					oneMyEvent.TrxIndex = trxIndex
+					oneMyEvent.TrxStatus = trxStatus
+					cfg.EventChan <- *oneMyEvent
+				}
+			} 
 		
-		//output analysis result whatever the WorkType is	
-		if sqlType != "" {
-			if sqlType == "query" {
-				cfg.StatChan <- BinEventStats{Timestamp: ev.Header.Timestamp, Binlog: currentBinlog, StartPos: ev.Header.LogPos - ev.Header.EventSize, StopPos: ev.Header.LogPos,
-					Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType}
-			} else {
-				cfg.StatChan <- BinEventStats{Timestamp: ev.Header.Timestamp, Binlog: currentBinlog, StartPos: tbMapPos, StopPos: ev.Header.LogPos,
-					Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType}
+			//output analysis result whatever the WorkType is	
+			if sqlType != "" {
+				if sqlType == "query" {
+					cfg.StatChan <- BinEventStats{Timestamp: ev.Header.Timestamp, Binlog: currentBinlog, StartPos: ev.Header.LogPos - ev.Header.EventSize, StopPos: ev.Header.LogPos,
+						Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType}
+				} else {
+					cfg.StatChan <- BinEventStats{Timestamp: ev.Header.Timestamp, Binlog: currentBinlog, StartPos: tbMapPos, StopPos: ev.Header.LogPos,
+						Database: db, Table: tb, QuerySql: sql, RowCnt: rowCnt, QueryType: sqlType, RowsQuery: oneMyEvent.RowsQuery}
+				}
 			}
 		}
 		
@@ -128,7 +128,9 @@ func ReverseFileToNewFileOneByOneLineAndKeepTrxBatchRead(srcFile string, destFil
 			ji++
 
 		}
-		if keepTrx && lastTrxIdx != trxPoses[batchIdx][1] {
+		if keepTrx && batchIdx == len(trxPoses)-1 {
+			destFH.WriteString("begin;\n")
+		} else if keepTrx && lastTrxIdx != trxPoses[batchIdx][1] {
 			destFH.WriteString("commit;\nbegin;\n")
 		}
 		lastTrxIdx = trxPoses[batchIdx][1]
@@ -0,0 +1,236 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+var (
+	gRowImageWarnedLock   sync.Mutex
+	gRowImageWarnedTables map[string]bool = map[string]bool{} // db.tb:reason warned already
+)
+
+// RowImageColumns is the columns logged in the before and after image of rows event. with binlog_row_image=MINIMAL
+// or NOBLOB, the absent columns are decoded as NULL, they should not be used to generate sql.
+// nil means all columns are present, Before is nil for insert and After is nil for delete.
+// with binlog_row_value_options=PARTIAL_JSON, PartialJson is the json columns logged as diffs in the after image
+type RowImageColumns struct {
+	Before      []bool
+	After       []bool
+	PartialJson []bool
+}
+
+// GetPresentColumns returns nil if all columns are set in the bitmap
+func GetPresentColumns(bitmap []byte, colCnt int) []bool {
+	if len(bitmap)*8 < colCnt {
+		return nil
+	}
+	present := make([]bool, colCnt)
+	ifFull := true
+	for i := 0; i < colCnt; i++ {
+		present[i] = bitmap[i>>3]&(1<<(uint(i)&7)) > 0
+		if !present[i] {
+			ifFull = false
+		}
+	}
+	if ifFull {
+		return nil
+	}
+	return present
+}
+
+func GetRowImageColumns(rEv *replication.RowsEvent, sqlType string, colCnt int) *RowImageColumns {
+	imageCols := &RowImageColumns{}
+	switch sqlType {
+	case "insert":
+		imageCols.After = GetPresentColumns(rEv.ColumnBitmap1, colCnt)
+	case "delete":
+		imageCols.Before = GetPresentColumns(rEv.ColumnBitmap1, colCnt)
+	case "update":
+		imageCols.Before = GetPresentColumns(rEv.ColumnBitmap1, colCnt)
+		imageCols.After = GetPresentColumns(rEv.ColumnBitmap2, colCnt)
+		imageCols.PartialJson = GetPartialJsonColumns(rEv)
+	}
+	return imageCols
+}
+
+func IfColumnPresent(present []bool, idx int) bool {
+	return present == nil || (idx < len(present) && present[idx])
+}
+
+func IfColumnsPresent(present []bool, idxArr []int) bool {
+	for _, idx := range idxArr {
+		if !IfColumnPresent(present, idx) {
+			return false
+		}
+	}
+	return true
+}
+
+// AndPresentColumns returns columns present in both images
+func AndPresentColumns(a []bool, b []bool) []bool {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	present := make([]bool, len(a))
+	for i := range a {
+		present[i] = a[i] && IfColumnPresent(b, i)
+	}
+	return present
+}
+
+// OrPresentColumns returns columns present in either image
+func OrPresentColumns(a []bool, b []bool) []bool {
+	if a == nil || b == nil {
+		return nil
+	}
+	present := make([]bool, len(a))
+	for i := range a {
+		present[i] = a[i] || IfColumnPresent(b, i)
+	}
+	return present
+}
+
+// OmitPartialJsonColumns returns present without the json columns logged as diffs
+func OmitPartialJsonColumns(present []bool, partial []bool) []bool {
+	if partial == nil {
+		return present
+	}
+	omitted := make([]bool, len(partial))
+	for i := range partial {
+		omitted[i] = IfColumnPresent(present, i) && !partial[i]
+	}
+	return omitted
+}
+
+// OmitPartialJson makes the json columns logged as diffs absent in the after image. -output-format=jsonl|debezium|canal
+// output the value of columns, the diffs are not the value and cannot be applied without the before value of json
+func (this *RowImageColumns) OmitPartialJson() {
+	if this.PartialJson != nil {
+		this.After = OmitPartialJsonColumns(this.After, this.PartialJson)
+	}
+}
+
+// MergeRowImages returns row with the absent columns filled by the other image
+func MergeRowImages(row []interface{}, present []bool, otherRow []interface{}) []interface{} {
+	if present == nil {
+		return row
+	}
+	merged := make([]interface{}, len(row))
+	for i := range row {
+		if IfColumnPresent(present, i) {
+			merged[i] = row[i]
+		} else {
+			merged[i] = otherRow[i]
+		}
+	}
+	return merged
+}
+
+// GetPresentColumnIdx returns the columns of colIdx present in the row image, colIdx nil means all colCnt columns.
+// the absent columns are omitted in -output-format=jsonl|debezium|canal, as NULL of them is not the real value
+func GetPresentColumnIdx(present []bool, colIdx []int, colCnt int) []int {
+	if colIdx == nil {
+		colIdx = make([]int, colCnt)
+		for i := range colIdx {
+			colIdx[i] = i
+		}
+	}
+	if present == nil {
+		return colIdx
+	}
+	presentIdx := make([]int, 0, len(colIdx))
+	for _, ci := range colIdx {
+		if IfColumnPresent(present, ci) {
+			presentIdx = append(presentIdx, ci)
+		}
+	}
+	return presentIdx
+}
+
+func (this *RowImageColumns) IfFull() bool {
+	return this.Before == nil && this.After == nil && this.PartialJson == nil
+}
+
+func GetAbsentColumnNames(present []bool, colNames []FieldInfo) []string {
+	var names []string
+	for i := range present {
+		if !present[i] && i < len(colNames) {
+			names = append(names, colNames[i].FieldName)
+		}
+	}
+	return names
+}
+
+func GetPartialJsonColumnNames(partial []bool, colNames []FieldInfo) []string {
+	var names []string
+	for i := range partial {
+		if partial[i] && i < len(colNames) {
+			names = append(names, colNames[i].FieldName)
+		}
+	}
+	return names
+}
+
+// GetAbsentColumnsStr returns ex: before:c2,c3 after:c4 partial_json:c5
+func (this *RowImageColumns) GetAbsentColumnsStr(colNames []FieldInfo) string {
+	var parts []string
+	if names := GetAbsentColumnNames(this.Before, colNames); len(names) > 0 {
+		parts = append(parts, "before:"+strings.Join(names, ","))
+	}
+	if names := GetAbsentColumnNames(this.After, colNames); len(names) > 0 {
+		parts = append(parts, "after:"+strings.Join(names, ","))
+	}
+	if names := GetPartialJsonColumnNames(this.PartialJson, colNames); len(names) > 0 {
+		parts = append(parts, "partial_json:"+strings.Join(names, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// GetIrreversibleReason returns why the rows event cannot be reversed, empty if it can be.
+// rollback of delete needs the full before image, rollback of update needs the before image of all updated columns.
+// json diffs cannot be reversed, rollback of partial json update needs the full before image
+func (this *RowImageColumns) GetIrreversibleReason(sqlType string, colNames []FieldInfo) string {
+	var absent []string
+	switch sqlType {
+	case "delete":
+		absent = GetAbsentColumnNames(this.Before, colNames)
+	case "update":
+		if this.Before == nil {
+			return ""
+		}
+		if this.PartialJson != nil {
+			return fmt.Sprintf("json columns %s are logged as diffs, the full before image is needed to reverse them, absent columns: %s",
+				strings.Join(GetPartialJsonColumnNames(this.PartialJson, colNames), ","),
+				strings.Join(GetAbsentColumnNames(this.Before, colNames), ","))
+		}
+		for i := range this.Before {
+			if !this.Before[i] && IfColumnPresent(this.After, i) && i < len(colNames) {
+				absent = append(absent, colNames[i].FieldName)
+			}
+		}
+	}
+	if len(absent) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("before image is incomplete, absent columns: %s", strings.Join(absent, ","))
+}
+
+// WarnRowImageOnce warns only once for each table and message, as every rows event of the table is the same usually
+func WarnRowImageOnce(fulltb string, msg string) {
+	key := fulltb + ":" + msg
+	gRowImageWarnedLock.Lock()
+	defer gRowImageWarnedLock.Unlock()
+	if gRowImageWarnedTables[key] {
+		return
+	}
+	gRowImageWarnedTables[key] = true
+	log.Warnf("%s: %s", fulltb, msg)
+}
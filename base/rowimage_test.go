@@ -0,0 +1,83 @@
+package base
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestGetPresentColumnIdx(t *testing.T) {
+	cases := []struct {
+		name     string
+		present  []bool
+		colIdx   []int
+		expected []int
+	}{
+		{"full image", nil, nil, []int{0, 1, 2, 3}},
+		{"full image with columns", nil, []int{1, 3}, []int{1, 3}},
+		{"minimal image", []bool{true, false, false, true}, nil, []int{0, 3}},
+		{"minimal image with columns", []bool{true, false, false, true}, []int{1, 3}, []int{3}},
+		{"no column present", []bool{false, false, false, false}, []int{1, 2}, []int{}},
+	}
+	for _, c := range cases {
+		if idx := GetPresentColumnIdx(c.present, c.colIdx, 4); !reflect.DeepEqual(idx, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, idx, c.expected)
+		}
+	}
+}
+
+func TestGetRowImageAbsentColumns(t *testing.T) {
+	colNames := []FieldInfo{{FieldName: "id"}, {FieldName: "c1"}, {FieldName: "c2"}}
+	colsTypeName := []string{"int", "varchar", "varchar"}
+	row := []interface{}{int32(1), nil, nil}
+
+	// c1 is NULL in the row image, c2 is absent
+	image := GetRowImage(row, colNames, colsTypeName, nil, []bool{true, true, false})
+	line, err := json.Marshal(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != `{"id":1,"c1":null}` {
+		t.Errorf("got %s", line)
+	}
+}
+
+func TestOmitPartialJson(t *testing.T) {
+	colNames := []FieldInfo{{FieldName: "id", FieldType: "int"}, {FieldName: "doc", FieldType: "json"}, {FieldName: "c1", FieldType: "varchar"}}
+	colsTypeName := []string{"int", "json", "varchar"}
+	diff := &JsonPartialUpdate{Diffs: []JsonDiff{{Operation: "replace", Path: "$.k", Value: json.RawMessage("2")}}}
+	tbMap := &replication.TableMapEvent{Schema: []byte("db1"), Table: []byte("t1"), ColumnCount: 3}
+	ev := &MyBinEvent{SqlType: "update", BinEvent: &replication.RowsEvent{Table: tbMap,
+		Rows: [][]interface{}{{int32(1), `{"k": 1}`, "a"}, {int32(1), diff, "b"}}}}
+	imageCols := GetRowImageColumns(ev.BinEvent, ev.SqlType, 3)
+	imageCols.OmitPartialJson()
+	if !reflect.DeepEqual(imageCols.After, []bool{true, false, true}) {
+		t.Fatalf("after image is %v, the json column logged as diffs should be absent", imageCols.After)
+	}
+
+	expected := `{"database":"db1","table":"t1","type":"update","before":{"id":1,"doc":"{\"k\": 1}","c1":"a"},"after":{"id":1,"c1":"b"},` +
+		`"primary_key":{"id":1},"binlog":"","start_pos":0,"end_pos":0,"timestamp":0,"trx_index":0,"gtid":"","rows_query":""}`
+	if lines := GenRowChangeLinesForOneRowsEvent(&ConfCmd{}, ev, colNames, colsTypeName, []int{0}, imageCols); len(lines) != 1 || lines[0] != expected {
+		t.Errorf("jsonl:\n got      %q\n expected %q", lines, expected)
+	}
+
+	// the before value of json column is not the value after update in data of canal
+	lines := GenCanalLinesForOneRowsEvent(&ConfCmd{}, ev, nil, &TblInfoJson{PrimaryKey: []string{"id"}}, colNames, colsTypeName, imageCols)
+	var msg struct {
+		Data []map[string]interface{} `json:"data"`
+		Old  []map[string]interface{} `json:"old"`
+	}
+	if len(lines) != 1 {
+		t.Fatalf("canal: %d lines, expected 1", len(lines))
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	expectedData := []map[string]interface{}{{"id": "1", "c1": "b"}}
+	expectedOld := []map[string]interface{}{{"c1": "a"}}
+	if !reflect.DeepEqual(msg.Data, expectedData) || !reflect.DeepEqual(msg.Old, expectedOld) {
+		t.Errorf("canal: data is %v, old is %v, expected %v %v", msg.Data, msg.Old, expectedData, expectedOld)
+	}
+}
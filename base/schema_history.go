@@ -0,0 +1,396 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"my2sql/dsql"
+	toolkits "my2sql/toolkits"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+// table definition since binlog position ValidFrom, nil TblInfo means the table is dropped,
+// or it is created by CREATE TABLE ... SELECT and the columns are unknown if AsSelect is true
+type TblInfoVersion struct {
+	ValidFrom mysql.Position
+	TblInfo   *TblInfoJson
+	AsSelect  bool
+}
+
+func (this *TblInfoJson) Copy() *TblInfoJson {
+	tbInfo := &TblInfoJson{
+		Database:       this.Database,
+		Table:          this.Table,
+		Columns:        make([]FieldInfo, len(this.Columns)),
+		PrimaryKey:     make(KeyInfo, len(this.PrimaryKey)),
+		UniqueKeys:     make([]KeyInfo, len(this.UniqueKeys)),
+		UniqueKeyNames: make([]string, len(this.UniqueKeys)),
+	}
+	copy(tbInfo.Columns, this.Columns)
+	copy(tbInfo.PrimaryKey, this.PrimaryKey)
+	for i, oneKey := range this.UniqueKeys {
+		tbInfo.UniqueKeys[i] = make(KeyInfo, len(oneKey))
+		copy(tbInfo.UniqueKeys[i], oneKey)
+		if i < len(this.UniqueKeyNames) {
+			tbInfo.UniqueKeyNames[i] = this.UniqueKeyNames[i]
+		} else {
+			// table definition file without unique_key_names
+			tbInfo.UniqueKeyNames[i] = oneKey[0]
+		}
+	}
+	return tbInfo
+}
+
+func (this *TblInfoJson) GetColumnIndex(colName string) int {
+	for i, oneCol := range this.Columns {
+		if strings.EqualFold(oneCol.FieldName, colName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetTableInfoJsonAtPos returns the table definition which is valid at binlog position pos,
+// that is the table definition before any DDL in binlog with all DDL before pos applied
+func (this *TablesColumnsInfo) GetTableInfoJsonAtPos(schema string, table string, pos mysql.Position) (*TblInfoJson, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.getTableInfoJsonAtPos(schema, table, pos)
+}
+
+func (this *TablesColumnsInfo) getTableInfoJsonAtPos(schema string, table string, pos mysql.Position) (*TblInfoJson, error) {
+	tbKey := GetAbsTableName(schema, table)
+	tbVersions := this.versions[tbKey]
+	for i := len(tbVersions) - 1; i >= 0; i-- {
+		if tbVersions[i].ValidFrom.Compare(pos) > 0 {
+			continue
+		}
+		if tbVersions[i].AsSelect {
+			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s, it is created by CREATE TABLE ... SELECT at %s, "+
+				"binlog_row_metadata=FULL is required to get its columns from table map", tbKey, tbVersions[i].ValidFrom.String())
+		}
+		if tbVersions[i].TblInfo == nil {
+			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s, it is dropped at %s", tbKey, tbVersions[i].ValidFrom.String())
+		}
+		return tbVersions[i].TblInfo, nil
+	}
+	return this.getTableInfoJson(schema, table)
+}
+
+// GetTableInfoJsonForEvent returns the table definition valid at binlog position pos for the rows event with colCnt columns.
+// it fails if the definition has less columns than the rows event, ex: DDL before the start position. other versions
+// with the same column count are not tried, the columns may be renamed or reordered in them
+func (this *TablesColumnsInfo) GetTableInfoJsonForEvent(schema string, table string, pos mysql.Position, colCnt int) (*TblInfoJson, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	tbInfo, err := this.getTableInfoJsonAtPos(schema, table, pos)
+	if err != nil {
+		return nil, err
+	}
+	if len(tbInfo.Columns) < colCnt {
+		return nil, fmt.Errorf("column count %d in binlog > %d in table struct of %s valid at %s, usually means DDL before the start position, please use -read-tbl-def-file with the table struct at the start position",
+			colCnt, len(tbInfo.Columns), GetAbsTableName(schema, table), pos.String())
+	}
+	return tbInfo, nil
+}
+
+// GetTblInfoForRowsEvent returns the table definition to decode the rows event at binlog position pos, it is the one
+// in table map with binlog_row_metadata=FULL, or the one valid at pos. the readers, -where and the workers use it,
+// so the rows event is decoded with the same definition everywhere
+func GetTblInfoForRowsEvent(rEv *replication.RowsEvent, pos mysql.Position) (*TblInfoJson, error) {
+	if tbInfo := GetTblInfoFromTableMap(rEv.Table); tbInfo != nil {
+		return tbInfo, nil
+	}
+	colCnt := int(rEv.ColumnCount)
+	if len(rEv.Rows) > 0 {
+		colCnt = len(rEv.Rows[0])
+	}
+	return G_TablesColumnsInfo.GetTableInfoJsonForEvent(string(rEv.Table.Schema), string(rEv.Table.Table), pos, colCnt)
+}
+
+func (this *TablesColumnsInfo) addTableVersion(tbKey string, pos mysql.Position, tbInfo *TblInfoJson) *TblInfoVersion {
+	if this.versions == nil {
+		this.versions = map[string][]*TblInfoVersion{}
+	}
+	tbVersion := &TblInfoVersion{ValidFrom: pos, TblInfo: tbInfo}
+	this.versions[tbKey] = append(this.versions[tbKey], tbVersion)
+	return tbVersion
+}
+
+// ApplyDdl records the table definitions changed by the ddl, they are valid since binlog position pos.
+// DDL must be applied in the order of binlog
+func (this *TablesColumnsInfo) ApplyDdl(cfg *ConfCmd, sqlInfo *dsql.SqlInfo, pos mysql.Position) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	switch sqlInfo.SqlType {
+	case dsql.SQL_TYPE_CREATE_TABLE:
+		dbTb := sqlInfo.Tables[0]
+		if !cfg.IsTargetTable(dbTb.Database, dbTb.Table) {
+			return
+		}
+		if sqlInfo.AsSelect {
+			// rows events of the table fail with table struct not found unless table map has the column names
+			log.Warnf("columns of %s created by CREATE TABLE ... SELECT at %s are unknown: %s",
+				GetAbsTableName(dbTb.Database, dbTb.Table), pos.String(), sqlInfo.SqlStr)
+			this.addTableVersion(GetAbsTableName(dbTb.Database, dbTb.Table), pos, nil).AsSelect = true
+			return
+		}
+		var tbInfo *TblInfoJson
+		if sqlInfo.LikeTable.Table != "" {
+			likeInfo, err := this.getTableInfoJsonAtPos(sqlInfo.LikeTable.Database, sqlInfo.LikeTable.Table, pos)
+			if err != nil {
+				log.Warnf("%v, skip ddl at %s: %s", err, pos.String(), sqlInfo.SqlStr)
+				return
+			}
+			tbInfo = likeInfo.Copy()
+			tbInfo.Database = dbTb.Database
+			tbInfo.Table = dbTb.Table
+		} else {
+			tbInfo = GetTblInfoFromCreateSql(dbTb, sqlInfo)
+		}
+		this.addTableVersion(GetAbsTableName(dbTb.Database, dbTb.Table), pos, tbInfo)
+	case dsql.SQL_TYPE_DROP_TABLE:
+		for _, dbTb := range sqlInfo.Tables {
+			if !cfg.IsTargetTable(dbTb.Database, dbTb.Table) {
+				continue
+			}
+			this.addTableVersion(GetAbsTableName(dbTb.Database, dbTb.Table), pos, nil)
+		}
+	case dsql.SQL_TYPE_RENAME_TABLE:
+		for i := 0; i+1 < len(sqlInfo.Tables); i += 2 {
+			this.renameTable(cfg, sqlInfo.Tables[i], sqlInfo.Tables[i+1], pos, sqlInfo.SqlStr)
+		}
+	case dsql.SQL_TYPE_ALTER_TABLE:
+		dbTb := sqlInfo.Tables[0]
+		if !cfg.IsTargetTable(dbTb.Database, dbTb.Table) {
+			return
+		}
+		tbKey := GetAbsTableName(dbTb.Database, dbTb.Table)
+		oldInfo, err := this.getTableInfoJsonAtPos(dbTb.Database, dbTb.Table, pos)
+		if err != nil {
+			log.Warnf("%v, skip ddl at %s: %s", err, pos.String(), sqlInfo.SqlStr)
+			return
+		}
+		if this.seedFromDb[tbKey] && len(this.versions[tbKey]) == 0 {
+			log.Warnf("table struct of %s is queried from mysql, it is the current struct but not the one before %s: %s. "+
+				"Please use -read-tbl-def-file with table definitions dumped at the start position if the result is wrong",
+				tbKey, pos.String(), sqlInfo.SqlStr)
+		}
+		tbInfo := oldInfo.Copy()
+		var newTable dsql.DbTable
+		for _, spec := range sqlInfo.AlterSpecs {
+			if spec.Action == dsql.ALTER_RENAME_TABLE {
+				newTable = spec.NewTable
+				continue
+			}
+			if err = ApplyAlterSpec(tbInfo, spec); err != nil {
+				log.Warnf("%s, ignore it. ddl at %s: %s", err.Error(), pos.String(), sqlInfo.SqlStr)
+			}
+		}
+		if newTable.Table != "" && GetAbsTableName(newTable.Database, newTable.Table) != tbKey {
+			this.addTableVersion(tbKey, pos, nil)
+			if cfg.IsTargetTable(newTable.Database, newTable.Table) {
+				tbInfo.Database = newTable.Database
+				tbInfo.Table = newTable.Table
+				this.addTableVersion(GetAbsTableName(newTable.Database, newTable.Table), pos, tbInfo)
+			}
+		} else {
+			this.addTableVersion(tbKey, pos, tbInfo)
+		}
+	}
+}
+
+func (this *TablesColumnsInfo) renameTable(cfg *ConfCmd, from dsql.DbTable, to dsql.DbTable, pos mysql.Position, sqlStr string) {
+	if !cfg.IsTargetTable(from.Database, from.Table) && !cfg.IsTargetTable(to.Database, to.Table) {
+		return
+	}
+	fromInfo, err := this.getTableInfoJsonAtPos(from.Database, from.Table, pos)
+	if err != nil {
+		log.Warnf("%v, skip ddl at %s: %s", err, pos.String(), sqlStr)
+		return
+	}
+	tbInfo := fromInfo.Copy()
+	tbInfo.Database = to.Database
+	tbInfo.Table = to.Table
+	this.addTableVersion(GetAbsTableName(from.Database, from.Table), pos, nil)
+	this.addTableVersion(GetAbsTableName(to.Database, to.Table), pos, tbInfo)
+}
+
+func GetTblInfoFromCreateSql(dbTb dsql.DbTable, sqlInfo *dsql.SqlInfo) *TblInfoJson {
+	tbInfo := &TblInfoJson{Database: dbTb.Database, Table: dbTb.Table,
+		Columns: []FieldInfo{}, PrimaryKey: KeyInfo{}, UniqueKeys: []KeyInfo{}, UniqueKeyNames: []string{}}
+	for _, oneCol := range sqlInfo.Columns {
+		tbInfo.Columns = append(tbInfo.Columns, FieldInfo{FieldName: oneCol.Name, FieldType: oneCol.Type, IsUnsigned: oneCol.Unsigned})
+		if oneCol.PrimaryKey {
+			tbInfo.PrimaryKey = KeyInfo{oneCol.Name}
+		} else if oneCol.UniqueKey {
+			tbInfo.UniqueKeys = append(tbInfo.UniqueKeys, KeyInfo{oneCol.Name})
+			tbInfo.UniqueKeyNames = append(tbInfo.UniqueKeyNames, oneCol.Name)
+		}
+	}
+	for _, oneKey := range sqlInfo.Keys {
+		AddKeyToTblInfo(tbInfo, oneKey)
+	}
+	return tbInfo
+}
+
+func AddKeyToTblInfo(tbInfo *TblInfoJson, key dsql.KeyDef) {
+	if key.Primary {
+		tbInfo.PrimaryKey = KeyInfo(key.Columns)
+		return
+	}
+	tbInfo.UniqueKeys = append(tbInfo.UniqueKeys, KeyInfo(key.Columns))
+	tbInfo.UniqueKeyNames = append(tbInfo.UniqueKeyNames, key.Name)
+}
+
+func ApplyAlterSpec(tbInfo *TblInfoJson, spec dsql.AlterSpec) error {
+	switch spec.Action {
+	case dsql.ALTER_ADD_COLUMN:
+		if tbInfo.GetColumnIndex(spec.Column.Name) >= 0 {
+			return fmt.Errorf("column %s already exists in %s", spec.Column.Name, GetAbsTableName(tbInfo.Database, tbInfo.Table))
+		}
+		col := FieldInfo{FieldName: spec.Column.Name, FieldType: spec.Column.Type, IsUnsigned: spec.Column.Unsigned}
+		if err := insertColumn(tbInfo, col, spec); err != nil {
+			return err
+		}
+		if spec.Column.PrimaryKey {
+			tbInfo.PrimaryKey = KeyInfo{spec.Column.Name}
+		} else if spec.Column.UniqueKey {
+			AddKeyToTblInfo(tbInfo, dsql.KeyDef{Name: spec.Column.Name, Columns: []string{spec.Column.Name}})
+		}
+	case dsql.ALTER_DROP_COLUMN:
+		idx := tbInfo.GetColumnIndex(spec.OldName)
+		if idx < 0 {
+			return fmt.Errorf("column %s not found in %s", spec.OldName, GetAbsTableName(tbInfo.Database, tbInfo.Table))
+		}
+		tbInfo.Columns = append(tbInfo.Columns[:idx], tbInfo.Columns[idx+1:]...)
+		removeColumnFromKeys(tbInfo, spec.OldName)
+	case dsql.ALTER_CHANGE_COLUMN:
+		idx := tbInfo.GetColumnIndex(spec.OldName)
+		if idx < 0 {
+			return fmt.Errorf("column %s not found in %s", spec.OldName, GetAbsTableName(tbInfo.Database, tbInfo.Table))
+		}
+		col := FieldInfo{FieldName: spec.Column.Name, FieldType: spec.Column.Type, IsUnsigned: spec.Column.Unsigned}
+		if spec.First || spec.AfterColumn != "" {
+			tbInfo.Columns = append(tbInfo.Columns[:idx], tbInfo.Columns[idx+1:]...)
+			if err := insertColumn(tbInfo, col, spec); err != nil {
+				return err
+			}
+		} else {
+			tbInfo.Columns[idx] = col
+		}
+		renameColumnInKeys(tbInfo, spec.OldName, spec.Column.Name)
+	case dsql.ALTER_RENAME_COLUMN:
+		idx := tbInfo.GetColumnIndex(spec.OldName)
+		if idx < 0 {
+			return fmt.Errorf("column %s not found in %s", spec.OldName, GetAbsTableName(tbInfo.Database, tbInfo.Table))
+		}
+		tbInfo.Columns[idx].FieldName = spec.NewName
+		renameColumnInKeys(tbInfo, spec.OldName, spec.NewName)
+	case dsql.ALTER_ADD_KEY:
+		AddKeyToTblInfo(tbInfo, spec.Key)
+	case dsql.ALTER_DROP_PRIMARY_KEY:
+		tbInfo.PrimaryKey = KeyInfo{}
+	case dsql.ALTER_DROP_INDEX:
+		for i, kName := range tbInfo.UniqueKeyNames {
+			if strings.EqualFold(kName, spec.OldName) {
+				tbInfo.UniqueKeys = append(tbInfo.UniqueKeys[:i], tbInfo.UniqueKeys[i+1:]...)
+				tbInfo.UniqueKeyNames = append(tbInfo.UniqueKeyNames[:i], tbInfo.UniqueKeyNames[i+1:]...)
+				break
+			}
+		}
+	case dsql.ALTER_RENAME_INDEX:
+		for i, kName := range tbInfo.UniqueKeyNames {
+			if strings.EqualFold(kName, spec.OldName) {
+				tbInfo.UniqueKeyNames[i] = spec.NewName
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func insertColumn(tbInfo *TblInfoJson, col FieldInfo, spec dsql.AlterSpec) error {
+	idx := len(tbInfo.Columns)
+	if spec.First {
+		idx = 0
+	} else if spec.AfterColumn != "" {
+		idx = tbInfo.GetColumnIndex(spec.AfterColumn)
+		if idx < 0 {
+			return fmt.Errorf("column %s not found in %s", spec.AfterColumn, GetAbsTableName(tbInfo.Database, tbInfo.Table))
+		}
+		idx++
+	}
+	tbInfo.Columns = append(tbInfo.Columns, FieldInfo{})
+	copy(tbInfo.Columns[idx+1:], tbInfo.Columns[idx:])
+	tbInfo.Columns[idx] = col
+	return nil
+}
+
+func renameColumnInKeys(tbInfo *TblInfoJson, oldName string, newName string) {
+	for i, colName := range tbInfo.PrimaryKey {
+		if strings.EqualFold(colName, oldName) {
+			tbInfo.PrimaryKey[i] = newName
+		}
+	}
+	for _, oneKey := range tbInfo.UniqueKeys {
+		for i, colName := range oneKey {
+			if strings.EqualFold(colName, oldName) {
+				oneKey[i] = newName
+			}
+		}
+	}
+}
+
+// mysql removes the column from index when the column is dropped, and drops the index if no column left
+func removeColumnFromKeys(tbInfo *TblInfoJson, colName string) {
+	tbInfo.PrimaryKey = removeColumnFromKey(tbInfo.PrimaryKey, colName)
+	var uniqueKeys []KeyInfo = []KeyInfo{}
+	var uniqueKeyNames []string = []string{}
+	for i, oneKey := range tbInfo.UniqueKeys {
+		oneKey = removeColumnFromKey(oneKey, colName)
+		if len(oneKey) == 0 {
+			continue
+		}
+		uniqueKeys = append(uniqueKeys, oneKey)
+		uniqueKeyNames = append(uniqueKeyNames, tbInfo.UniqueKeyNames[i])
+	}
+	tbInfo.UniqueKeys = uniqueKeys
+	tbInfo.UniqueKeyNames = uniqueKeyNames
+}
+
+func removeColumnFromKey(key KeyInfo, colName string) KeyInfo {
+	var newKey KeyInfo = KeyInfo{}
+	for _, oneCol := range key {
+		if strings.EqualFold(oneCol, colName) {
+			continue
+		}
+		newKey = append(newKey, oneCol)
+	}
+	return newKey
+}
+
+// HandleDdlQuery parses the ddl of query event and records the table definitions changed by it
+func (this *MyBinEvent) HandleDdlQuery(cfg *ConfCmd, queryEvent *replication.QueryEvent) {
+	querySql := string(queryEvent.Query)
+	if toolkits.ContainsString([]string{"begin", "commit", "rollback"}, strings.ToLower(querySql)) {
+		return
+	}
+	sqlInfo, err := dsql.ParseDdlSql(querySql, string(queryEvent.Schema))
+	if err != nil {
+		log.Warnf("%v, table struct may be wrong after %s", err, this.MyPos.String())
+		return
+	}
+	if sqlInfo == nil {
+		return
+	}
+	G_TablesColumnsInfo.ApplyDdl(cfg, sqlInfo, this.MyPos)
+	if !IfDdlOfTargetTable(cfg, sqlInfo) {
+		return
+	}
+	this.QuerySql = sqlInfo
+	this.OrgSql = querySql
+}
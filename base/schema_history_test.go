@@ -0,0 +1,83 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"my2sql/dsql"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func testColumnNames(tbInfo *TblInfoJson) []string {
+	var names []string
+	for _, col := range tbInfo.Columns {
+		names = append(names, col.FieldName)
+	}
+	return names
+}
+
+func TestGetTableInfoJsonForEvent(t *testing.T) {
+	defer func(onlyFromFile bool) { GConfCmd.OnlyColFromFile = onlyFromFile }(GConfCmd.OnlyColFromFile)
+	GConfCmd.OnlyColFromFile = true
+	cfg := &ConfCmd{TblFilter: NewTableFilter(nil, nil, nil, nil)}
+	tbInfos := &TablesColumnsInfo{tableInfos: map[string]*TblInfoJson{
+		"db1.t1": {Database: "db1", Table: "t1", Columns: []FieldInfo{{FieldName: "id"}, {FieldName: "a"}, {FieldName: "b"}}},
+	}}
+	pos := func(p uint32) mysql.Position {
+		return mysql.Position{Name: "mysql-bin.000001", Pos: p}
+	}
+	for _, ddl := range []struct {
+		pos uint32
+		sql string
+	}{
+		{200, "alter table t1 rename column a to c"},
+		{300, "alter table t1 drop column b, add column b int after id"},
+		{400, "alter table t1 add column d int"},
+		{500, "drop table t1"},
+		{600, "create table t1 as select 1 as id"},
+	} {
+		sqlInfo, err := dsql.ParseDdlSql(ddl.sql, "db1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbInfos.ApplyDdl(cfg, sqlInfo, pos(ddl.pos))
+	}
+
+	cases := []struct {
+		name     string
+		pos      uint32
+		colCnt   int
+		expected []string // nil for error
+	}{
+		{"before ddl", 100, 3, []string{"id", "a", "b"}},
+		{"renamed", 250, 3, []string{"id", "c", "b"}},
+		{"reordered", 350, 3, []string{"id", "b", "c"}},
+		// the versions with 3 columns are not used for the event logged with 4 columns
+		{"added", 450, 4, []string{"id", "b", "c", "d"}},
+		{"more columns in binlog", 350, 4, nil},
+		// the columns after the event's are not used
+		{"less columns in binlog", 450, 3, []string{"id", "b", "c", "d"}},
+		{"dropped", 550, 3, nil},
+		{"created by select", 650, 1, nil},
+	}
+	for _, c := range cases {
+		tbInfo, err := tbInfos.GetTableInfoJsonForEvent("db1", "t1", pos(c.pos), c.colCnt)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("%s: error expected, but got %v", c.name, testColumnNames(tbInfo))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if names := testColumnNames(tbInfo); !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%s: columns are %v, expected %v", c.name, names, c.expected)
+		}
+	}
+
+	if _, err := tbInfos.GetTableInfoJsonForEvent("db1", "no_def", pos(100), 1); err == nil {
+		t.Errorf("error expected for table without struct")
+	}
+}
@@ -43,6 +43,56 @@ func GetSqlFieldsEXpressions(colCnt int, colNames []FieldInfo, tbMap *replicatio
 	return colDefExps, colTypeNames
 }
 
+// ConvertEnumSetToStr converts enum index and set bitmap in rows into string values,
+// if enum/set string values are logged in table map event(binlog_row_metadata=FULL)
+func ConvertEnumSetToStr(rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, colNames []FieldInfo) {
+	enumMap := rEv.Table.EnumStrValueMap()
+	setMap := rEv.Table.SetStrValueMap()
+	if len(enumMap) == 0 && len(setMap) == 0 {
+		return
+	}
+	for ci := range colDefs {
+		enumVals, isEnum := enumMap[ci]
+		setVals, isSet := setMap[ci]
+		if !isEnum && !isSet {
+			continue
+		}
+		for ri := range rEv.Rows {
+			if ci >= len(rEv.Rows[ri]) {
+				continue
+			}
+			idx, ok := rEv.Rows[ri][ci].(int64)
+			if !ok {
+				continue
+			}
+			if isEnum {
+				rEv.Rows[ri][ci] = GetEnumStr(enumVals, idx)
+			} else {
+				rEv.Rows[ri][ci] = GetSetStr(setVals, idx)
+			}
+		}
+		colDefs[ci] = SQL.StrColumn(colNames[ci].FieldName, SQL.UTF8, SQL.UTF8CaseInsensitive, SQL.NotNullable)
+	}
+}
+
+func GetEnumStr(enumVals []string, idx int64) string {
+	if idx > 0 && int(idx) <= len(enumVals) {
+		return enumVals[idx-1]
+	}
+	// invalid value inserted in non-strict mode
+	return ""
+}
+
+func GetSetStr(setVals []string, idx int64) string {
+	var items []string
+	for bi, oneVal := range setVals {
+		if idx&(1<<uint(bi)) != 0 {
+			items = append(items, oneVal)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
 func GetMysqlDataTypeNameAndSqlColumn(tpDef string, colName string, tp byte, meta uint16) (string, SQL.NonAliasColumn) {
 	// for unkown type, defaults to BytesColumn
 
@@ -134,7 +184,8 @@ func GetMysqlDataTypeNameAndSqlColumn(tpDef string, colName string, tp byte, met
 	}
 }
 
-func GenInsertSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, rowsPerSql int, ifRollback bool, ifprefixDb bool, ifIgnorePrimary bool, primaryIdx []int) []string {
+// GenInsertSqlsForOneRowsEvent generates insert with the columns present in the row image, present is nil for full row image
+func GenInsertSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, rowsPerSql int, ifRollback bool, ifprefixDb bool, ifIgnorePrimary bool, primaryIdx []int, present []bool) []string {
 	var (
 		insertSql  SQL.InsertStatement
 		oneSql     string
@@ -159,13 +210,13 @@ func GenInsertSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, col
 	if len(primaryIdx) == 0 {
 		ifIgnorePrimary = false
 	}
-	if ifIgnorePrimary {
-		newColDefs = GetColDefIgnorePrimary(colDefs, primaryIdx)
+	if ifIgnorePrimary || present != nil {
+		newColDefs = GetColDefsForInsert(colDefs, ifIgnorePrimary, primaryIdx, present)
 	}
 	for i = 0; i < rowCnt; i += rowsPerSql {
 		insertSql = SQL.NewTable(table, newColDefs...).Insert(newColDefs...)
 		endIndex = GetMinValue(rowCnt, i+rowsPerSql)
-		oneSql, err = GenInsertSqlForRows(rEv.Rows[i:endIndex], insertSql, schema, ifprefixDb, ifIgnorePrimary, primaryIdx)
+		oneSql, err = GenInsertSqlForRows(rEv.Rows[i:endIndex], insertSql, schema, ifprefixDb, ifIgnorePrimary, primaryIdx, present)
 		if err != nil {
 			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %v\n\trows data:%v",
 				sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[i:endIndex]))
@@ -177,7 +228,7 @@ func GenInsertSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, col
 
 	if endIndex < rowCnt {
 		insertSql = SQL.NewTable(table, newColDefs...).Insert(newColDefs...)
-		oneSql, err = GenInsertSqlForRows(rEv.Rows[endIndex:rowCnt], insertSql, schema, ifprefixDb, ifIgnorePrimary, primaryIdx)
+		oneSql, err = GenInsertSqlForRows(rEv.Rows[endIndex:rowCnt], insertSql, schema, ifprefixDb, ifIgnorePrimary, primaryIdx, present)
 		if err != nil {
 			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v",
 				sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[endIndex:rowCnt]))
@@ -190,10 +241,13 @@ func GenInsertSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, col
 
 }
 
-func GetColDefIgnorePrimary(colDefs []SQL.NonAliasColumn, primaryIdx []int) []SQL.NonAliasColumn {
+func GetColDefsForInsert(colDefs []SQL.NonAliasColumn, ifIgnorePrimary bool, primaryIdx []int, present []bool) []SQL.NonAliasColumn {
 	m := []SQL.NonAliasColumn{}
 	for i := range colDefs {
-		if toolkits.ContainsInt(primaryIdx, i) {
+		if ifIgnorePrimary && toolkits.ContainsInt(primaryIdx, i) {
+			continue
+		}
+		if !IfColumnPresent(present, i) {
 			continue
 		}
 		m = append(m, colDefs[i])
@@ -201,7 +255,7 @@ func GetColDefIgnorePrimary(colDefs []SQL.NonAliasColumn, primaryIdx []int) []SQ
 	return m
 }
 
-func ConvertRowToExpressRow(row []interface{}, ifIgnorePrimary bool, primaryIdx []int) []SQL.Expression {
+func ConvertRowToExpressRow(row []interface{}, ifIgnorePrimary bool, primaryIdx []int, present []bool) []SQL.Expression {
 
 	valueInserted := []SQL.Expression{}
 	for i, val := range row {
@@ -210,16 +264,19 @@ func ConvertRowToExpressRow(row []interface{}, ifIgnorePrimary bool, primaryIdx
 				continue
 			}
 		}
+		if !IfColumnPresent(present, i) {
+			continue
+		}
 		vExp := SQL.Literal(val)
 		valueInserted = append(valueInserted, vExp)
 	}
 	return valueInserted
 }
 
-func GenInsertSqlForRows(rows [][]interface{}, insertSql SQL.InsertStatement, schema string, ifprefixDb bool, ifIgnorePrimary bool, primaryIdx []int) (string, error) {
+func GenInsertSqlForRows(rows [][]interface{}, insertSql SQL.InsertStatement, schema string, ifprefixDb bool, ifIgnorePrimary bool, primaryIdx []int, present []bool) (string, error) {
 
 	for _, row := range rows {
-		valuesInserted := ConvertRowToExpressRow(row, ifIgnorePrimary, primaryIdx)
+		valuesInserted := ConvertRowToExpressRow(row, ifIgnorePrimary, primaryIdx, present)
 		insertSql.Add(valuesInserted...)
 	}
 	if !ifprefixDb {
@@ -230,11 +287,11 @@ func GenInsertSqlForRows(rows [][]interface{}, insertSql SQL.InsertStatement, sc
 
 }
 
-func GenDeleteSqlsForOneRowsEventRollbackInsert(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifprefixDb bool) []string {
-	return GenDeleteSqlsForOneRowsEvent(posStr, rEv, colDefs, uniKey, ifFullImage, true, ifprefixDb)
+func GenDeleteSqlsForOneRowsEventRollbackInsert(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifprefixDb bool, imageCols *RowImageColumns) []string {
+	return GenDeleteSqlsForOneRowsEvent(posStr, rEv, colDefs, uniKey, ifFullImage, true, ifprefixDb, imageCols)
 }
 
-func GenDeleteSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifRollback bool, ifprefixDb bool) []string {
+func GenDeleteSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifRollback bool, ifprefixDb bool, imageCols *RowImageColumns) []string {
 	rowCnt := len(rEv.Rows)
 	sqlArr := make([]string, rowCnt)
 	//var sqlArr []string
@@ -245,14 +302,19 @@ func GenDeleteSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, col
 		schemaInSql = ""
 	}
 
-	var sqlType string
+	var (
+		sqlType string
+		present []bool
+	)
 	if ifRollback {
 		sqlType = "delete_for_insert_rollback"
+		present = imageCols.After
 	} else {
 		sqlType = "delete"
+		present = imageCols.Before
 	}
 	for i, row := range rEv.Rows {
-		whereCond := GenEqualConditions(row, colDefs, uniKey, ifFullImage)
+		whereCond := GenEqualConditions(row, row, colDefs, uniKey, ifFullImage, present)
 
 		sql, err := SQL.NewTable(table, colDefs...).Delete().Where(SQL.And(whereCond...)).String(schemaInSql)
 		if err != nil {
@@ -266,26 +328,59 @@ func GenDeleteSqlsForOneRowsEvent(posStr string, rEv *replication.RowsEvent, col
 	return sqlArr
 }
 
-func GenEqualConditions(row []interface{}, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool) []SQL.BoolExpression {
-	if !ifFullImage && len(uniKey) > 0 {
+// GetWhereColumnIdx returns the columns GenEqualConditions uses to build where condition of the sqls generated for
+// the rows event, nil if no where condition is generated
+func GetWhereColumnIdx(sqlType string, ifRollback bool, colCnt int, uniKey []int, ifFullImage bool, imageCols *RowImageColumns) []int {
+	var present []bool
+	switch {
+	case sqlType == "delete" && !ifRollback:
+		present = imageCols.Before
+	case sqlType == "insert" && ifRollback:
+		present = imageCols.After
+	case sqlType == "update" && ifRollback:
+		present = OrPresentColumns(imageCols.After, imageCols.Before)
+	case sqlType == "update":
+		present = imageCols.Before
+	default:
+		return nil
+	}
+	if !ifFullImage && len(uniKey) > 0 && IfColumnsPresent(present, uniKey) {
+		return uniKey
+	}
+	return GetPresentColumnIdx(present, nil, colCnt)
+}
+
+// GenEqualConditions uses the unique key if it is present in the row image, otherwise all present columns.
+// the columns are chosen by row, the values are from renderRow, which is the masked row with -mask-rules
+func GenEqualConditions(row []interface{}, renderRow []interface{}, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, present []bool) []SQL.BoolExpression {
+	if !ifFullImage && len(uniKey) > 0 && IfColumnsPresent(present, uniKey) {
 		expArrs := make([]SQL.BoolExpression, len(uniKey))
 		for k, idx := range uniKey {
-			expArrs[k] = SQL.EqL(colDefs[idx], row[idx])
+			expArrs[k] = SQL.EqL(colDefs[idx], renderRow[idx])
 		}
 		return expArrs
 	}
-	expArrs := make([]SQL.BoolExpression, len(row))
+	expArrs := make([]SQL.BoolExpression, 0, len(row))
 	for i, v := range row {
-		expArrs[i] = SQL.EqL(colDefs[i], v)
+		if !IfColumnPresent(present, i) {
+			continue
+		}
+		if _, ok := v.(*JsonPartialUpdate); ok {
+			// the json document after partial update is unknown
+			continue
+		}
+		expArrs = append(expArrs, SQL.EqL(colDefs[i], renderRow[i]))
 	}
 	return expArrs
 }
 
-func GenInsertSqlsForOneRowsEventRollbackDelete(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, rowsPerSql int, ifprefixDb bool) []string {
-	return GenInsertSqlsForOneRowsEvent(posStr, rEv, colDefs, rowsPerSql, true, ifprefixDb, false, []int{})
+func GenInsertSqlsForOneRowsEventRollbackDelete(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, rowsPerSql int, ifprefixDb bool, imageCols *RowImageColumns) []string {
+	return GenInsertSqlsForOneRowsEvent(posStr, rEv, colDefs, rowsPerSql, true, ifprefixDb, false, []int{}, imageCols.Before)
 }
 
-func GenUpdateSqlsForOneRowsEvent(posStr string, colsTypeNameFromMysql []string, colsTypeName []string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifRollback bool, ifprefixDb bool) []string {
+// GenUpdateSqlsForOneRowsEvent chooses the columns to set and to build where condition by the rows of rEv,
+// the values are from renderRows, which are the masked rows with -mask-rules
+func GenUpdateSqlsForOneRowsEvent(posStr string, colsTypeNameFromMysql []string, colsTypeName []string, rEv *replication.RowsEvent, renderRows [][]interface{}, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool, ifRollback bool, ifprefixDb bool, imageCols *RowImageColumns) []string {
 	//colsTypeNameFromMysql: for text type, which is stored as blob
 	var (
 		rowCnt      int    = len(rEv.Rows)
@@ -303,6 +398,9 @@ func GenUpdateSqlsForOneRowsEvent(posStr string, colsTypeNameFromMysql []string,
 		schemaInSql = ""
 	}
 
+	if renderRows == nil {
+		renderRows = rEv.Rows
+	}
 	if ifRollback {
 		sqlType = "update_for_update_rollback"
 	} else {
@@ -311,11 +409,18 @@ func GenUpdateSqlsForOneRowsEvent(posStr string, colsTypeNameFromMysql []string,
 	for i := 0; i < rowCnt; i += 2 {
 		upSql := SQL.NewTable(table, colDefs...).Update()
 		if ifRollback {
-			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i], rEv.Rows[i+1], ifFullImage)
-			wherePart = GenEqualConditions(rEv.Rows[i+1], colDefs, uniKey, ifFullImage)
+			// columns not updated are absent in the after image of MINIMAL, their values are the same as the before image
+			setPresent := imageCols.Before
+			if !ifFullImage {
+				setPresent = AndPresentColumns(imageCols.Before, imageCols.After)
+			}
+			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i], rEv.Rows[i+1], renderRows[i], ifFullImage, setPresent, imageCols.After)
+			wherePart = GenEqualConditions(MergeRowImages(rEv.Rows[i+1], imageCols.After, rEv.Rows[i]),
+				MergeRowImages(renderRows[i+1], imageCols.After, renderRows[i]), colDefs, uniKey, ifFullImage,
+				OrPresentColumns(imageCols.After, imageCols.Before))
 		} else {
-			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i+1], rEv.Rows[i], ifFullImage)
-			wherePart = GenEqualConditions(rEv.Rows[i], colDefs, uniKey, ifFullImage)
+			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i+1], rEv.Rows[i], renderRows[i+1], ifFullImage, imageCols.After, imageCols.Before)
+			wherePart = GenEqualConditions(rEv.Rows[i], renderRows[i], colDefs, uniKey, ifFullImage, imageCols.Before)
 		}
 
 		upSql.Where(SQL.And(wherePart...))
@@ -333,49 +438,37 @@ func GenUpdateSqlsForOneRowsEvent(posStr string, colsTypeNameFromMysql []string,
 
 }
 
-func GenUpdateSetPart(colsTypeNameFromMysql []string, colTypeNames []string, updateSql SQL.UpdateStatement, colDefs []SQL.NonAliasColumn, rowAfter []interface{}, rowBefore []interface{}, ifFullImage bool) SQL.UpdateStatement {
+// GenUpdateSetPart sets the columns present in afterPresent. the column absent in the before image is taken as updated,
+// json column logged as diffs is set by json functions. changed columns are detected by rowAfter and rowBefore,
+// the values set are from renderAfter, which is the masked after image with -mask-rules
+func GenUpdateSetPart(colsTypeNameFromMysql []string, colTypeNames []string, updateSql SQL.UpdateStatement, colDefs []SQL.NonAliasColumn, rowAfter []interface{}, rowBefore []interface{}, renderAfter []interface{}, ifFullImage bool, afterPresent []bool, beforePresent []bool) SQL.UpdateStatement {
 
-	ifUpdateCol := false
 	for i, v := range rowAfter {
-		ifUpdateCol = false
 		//fmt.Printf("type: %s\nbefore: %v\nafter: %v\n", colTypeNames[i], rowBefore[i], v)
-
-		if !ifFullImage {
-			// text is stored as blob in binlog
-			if toolkits.ContainsString(G_Bytes_Column_Types, colTypeNames[i]) && !strings.Contains(strings.ToLower(colsTypeNameFromMysql[i]), "text") {
-				aArr, aOk := v.([]byte)
-				bArr, bOk := rowBefore[i].([]byte)
-				if aOk && bOk {
-					if CompareEquelByteSlice(aArr, bArr) {
-						//fmt.Println("bytes compare equal")
-						ifUpdateCol = false
-					} else {
-						ifUpdateCol = true
-						//fmt.Println("bytes compare unequal")
-					}
-				} else {
-					//fmt.Println("error to convert to []byte")
-					//should update the column
-					ifUpdateCol = true
-				}
-
-			} else {
-				if v == rowBefore[i] {
-					//fmt.Println("compare equal")
-					ifUpdateCol = false
-				} else {
-					//fmt.Println("compare unequal")
-					ifUpdateCol = true
-				}
-			}
-		} else {
-			ifUpdateCol = true
+		if !IfColumnPresent(afterPresent, i) {
+			continue
 		}
-
-		if ifUpdateCol {
-			updateSql.Set(colDefs[i], SQL.Literal(v))
+		if partialUpdate, ok := renderAfter[i].(*JsonPartialUpdate); ok {
+			updateSql.Set(colDefs[i], partialUpdate.GenSetExpression(colDefs[i]))
+		} else if ifFullImage || !IfColumnPresent(beforePresent, i) || IfColumnUpdated(colsTypeNameFromMysql[i], colTypeNames[i], v, rowBefore[i]) {
+			updateSql.Set(colDefs[i], SQL.Literal(renderAfter[i]))
 		}
 	}
 	return updateSql
 
 }
+
+// IfColumnUpdated returns true if the value of the column is changed by update
+func IfColumnUpdated(colTypeNameFromMysql string, colTypeName string, after interface{}, before interface{}) bool {
+	// text is stored as blob in binlog
+	if toolkits.ContainsString(G_Bytes_Column_Types, colTypeName) && !strings.Contains(strings.ToLower(colTypeNameFromMysql), "text") {
+		aArr, aOk := after.([]byte)
+		bArr, bOk := before.([]byte)
+		if aOk && bOk {
+			return !CompareEquelByteSlice(aArr, bArr)
+		}
+		//should update the column
+		return true
+	}
+	return after != before
+}
@@ -0,0 +1,165 @@
+package base
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"my2sql/constvar"
+	"my2sql/dsql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_irreversibleFileName = "rollback_irreversible.txt"
+)
+
+var (
+	GDmlSqlTypes map[int]string = map[int]string{
+		dsql.SQL_TYPE_INSERT: "insert",
+		dsql.SQL_TYPE_UPDATE: "update",
+		dsql.SQL_TYPE_DELETE: "delete",
+	}
+)
+
+// IrreversibleReport records the sqls which cannot be reversed by rollback, ex: statement based dml
+type IrreversibleReport struct {
+	FH   *os.File
+	Cnt  int
+	lock sync.Mutex
+}
+
+func OpenIrreversibleReport(outDir string) *IrreversibleReport {
+	reportFile := filepath.Join(outDir, C_irreversibleFileName)
+	FH, err := os.OpenFile(reportFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("fail to open file %s: %v", reportFile, err)
+	}
+	return &IrreversibleReport{FH: FH}
+}
+
+// Add writes one sql which cannot be reversed with the reason into the report
+func (this *IrreversibleReport) Add(ev *MyBinEvent, timestamp uint32, db string, tb string, reason string, sqlStr string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Cnt++
+	_, err := this.FH.WriteString(fmt.Sprintf("# datetime=%s database=%s table=%s binlog=%s startpos=%d stoppos=%d reason=%s\n%s;\n",
+		GetDatetimeStr(int64(timestamp), int64(0), constvar.DATETIME_FORMAT_NOSPACE), db, tb,
+		ev.MyPos.Name, ev.StartPos, ev.MyPos.Pos, reason, sqlStr))
+	if err != nil {
+		log.Fatalf("fail to write %s: %v", this.FH.Name(), err)
+	}
+}
+
+func (this *IrreversibleReport) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Cnt > 0 {
+		log.Warnf("%d sqls cannot be reversed, see %s", this.Cnt, this.FH.Name())
+	}
+	this.FH.Close()
+}
+
+// GetStmtVarFromEvent returns the SET statement of INTVAR_EVENT and RAND_EVENT, which is the context
+// of the following statement based dml
+func GetStmtVarFromEvent(ev *replication.BinlogEvent) string {
+	switch ev.Header.EventType {
+	case replication.INTVAR_EVENT:
+		intVarEvent := ev.Event.(*replication.IntVarEvent)
+		if intVarEvent.Type == replication.LAST_INSERT_ID {
+			return fmt.Sprintf("SET LAST_INSERT_ID=%d", intVarEvent.Value)
+		}
+		return fmt.Sprintf("SET INSERT_ID=%d", intVarEvent.Value)
+	case replication.RAND_EVENT:
+		data := ev.Event.(*replication.GenericEvent).Data
+		if len(data) < 16 {
+			return ""
+		}
+		return fmt.Sprintf("SET @@RAND_SEED1=%d, @@RAND_SEED2=%d", binary.LittleEndian.Uint64(data[0:8]), binary.LittleEndian.Uint64(data[8:16]))
+	}
+	return ""
+}
+
+// HandleDmlQuery handles INSERT/REPLACE/UPDATE/DELETE logged in query event, binlog_format is statement or mixed,
+// or the table is not transactional. the sql is output as it is for 2sql, and recorded as irreversible for rollback
+func (this *MyBinEvent) HandleDmlQuery(cfg *ConfCmd, ev *replication.BinlogEvent, queryEvent *replication.QueryEvent) {
+	querySql := string(queryEvent.Query)
+	sqlInfo, err := dsql.ParseDmlSql(querySql, string(queryEvent.Schema))
+	if err != nil {
+		log.Warnf("%v, skip it. QueryEvent position:%s", err, this.MyPos.String())
+		return
+	}
+	if sqlInfo == nil {
+		return
+	}
+	db, tb := sqlInfo.Tables[0].Database, sqlInfo.Tables[0].Table
+	if !cfg.IsTargetTable(db, tb) || !cfg.IsTargetDml(GDmlSqlTypes[sqlInfo.SqlType]) {
+		return
+	}
+	this.StartPos = ev.Header.LogPos - ev.Header.EventSize
+	if this.MasterPos.Pos >= ev.Header.EventSize {
+		// -mode=relaylog
+		this.MasterStartPos = this.MasterPos.Pos - ev.Header.EventSize
+	}
+
+	if cfg.WorkType == "rollback" {
+		log.Warnf("statement based %s on %s cannot be reversed, it is written into %s. QueryEvent position:%s",
+			GDmlSqlTypes[sqlInfo.SqlType], GetAbsTableName(db, tb), C_irreversibleFileName, this.MyPos.String())
+		cfg.IrreversibleReport.Add(this, ev.Header.Timestamp, db, tb, "statement based dml", querySql)
+		return
+	}
+	if cfg.WorkType != "2sql" {
+		return
+	}
+	if cfg.WhereExpr != nil || cfg.Masker != nil {
+		log.Warnf("statement based %s on %s is skipped, -where and -mask-rules cannot be applied to it. QueryEvent position:%s",
+			GDmlSqlTypes[sqlInfo.SqlType], GetAbsTableName(db, tb), this.MyPos.String())
+		return
+	}
+	if cfg.OutputFormat != C_outputFormatSql && cfg.OutputFormat != C_outputFormatCanal {
+		log.Warnf("statement based %s on %s is skipped, it cannot be output as %s. QueryEvent position:%s",
+			GDmlSqlTypes[sqlInfo.SqlType], GetAbsTableName(db, tb), cfg.OutputFormat, this.MyPos.String())
+		return
+	}
+	if cfg.OutputFormat == C_outputFormatSql && !cfg.SqlTblPrefixDb && sqlInfo.QualifiedSql == "" && sqlInfo.UseDatabase != "" {
+		// it needs USE, the default database cannot be restored after it for the following sqls without database
+		log.Warnf("statement based %s on %s is skipped, it may reference other tables without database and needs USE `%s`, "+
+			"which changes the database of the following sqls with -do-not-add-prifixDb. QueryEvent position:%s",
+			GDmlSqlTypes[sqlInfo.SqlType], GetAbsTableName(db, tb), sqlInfo.UseDatabase, this.MyPos.String())
+		return
+	}
+	this.QuerySql = sqlInfo
+	this.OrgSql = querySql
+	this.StmtVars = cfg.StmtVars
+}
+
+// GenSqlsForStatement returns the statement based dml with the context to execute it as it is in the source,
+// the context is reset after it, so it does not leak into the following sqls and the -apply-to session
+func GenSqlsForStatement(ev *MyBinEvent) []string {
+	var sqlArr []string
+	stmtSql := ev.QuerySql.QualifiedSql
+	if stmtSql == "" {
+		// other tables may be referenced without database, the default database cannot be unset after it.
+		// the following sqls are with database, the statement is skipped by HandleDmlQuery with -do-not-add-prifixDb
+		stmtSql = ev.OrgSql
+		if ev.QuerySql.UseDatabase != "" {
+			sqlArr = append(sqlArr, fmt.Sprintf("USE `%s`", strings.Replace(ev.QuerySql.UseDatabase, "`", "``", -1)))
+		}
+	}
+	sqlArr = append(sqlArr, fmt.Sprintf("SET TIMESTAMP=%d", ev.Timestamp))
+	sqlArr = append(sqlArr, ev.StmtVars...)
+	sqlArr = append(sqlArr, stmtSql, "SET TIMESTAMP=DEFAULT")
+	for _, stmtVar := range ev.StmtVars {
+		// RAND_SEED is consumed by RAND() of the statement, it has no value to reset to
+		if strings.HasPrefix(stmtVar, "SET INSERT_ID=") {
+			sqlArr = append(sqlArr, "SET INSERT_ID=0")
+		} else if strings.HasPrefix(stmtVar, "SET LAST_INSERT_ID=") {
+			sqlArr = append(sqlArr, "SET LAST_INSERT_ID=0")
+		}
+	}
+	return sqlArr
+}
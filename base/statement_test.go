@@ -0,0 +1,62 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"my2sql/dsql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestGenSqlsForStatement(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		stmtVars []string
+		expected []string
+	}{
+		{"qualified", "insert into t1 values(rand())", []string{"SET @@RAND_SEED1=1, @@RAND_SEED2=2"},
+			[]string{"SET TIMESTAMP=1700000000", "SET @@RAND_SEED1=1, @@RAND_SEED2=2",
+				"insert into `db0`.t1 values(rand())", "SET TIMESTAMP=DEFAULT"}},
+		{"insert id", "insert into t1(a) values(last_insert_id())", []string{"SET LAST_INSERT_ID=5", "SET INSERT_ID=9"},
+			[]string{"SET TIMESTAMP=1700000000", "SET LAST_INSERT_ID=5", "SET INSERT_ID=9",
+				"insert into `db0`.t1(a) values(last_insert_id())", "SET TIMESTAMP=DEFAULT",
+				"SET LAST_INSERT_ID=0", "SET INSERT_ID=0"}},
+		{"not qualified", "insert into t1 select * from t2", nil,
+			[]string{"USE `db0`", "SET TIMESTAMP=1700000000", "insert into t1 select * from t2", "SET TIMESTAMP=DEFAULT"}},
+	}
+	for _, c := range cases {
+		sqlInfo, err := dsql.ParseDmlSql(c.sql, "db0")
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		ev := &MyBinEvent{QuerySql: sqlInfo, OrgSql: c.sql, StmtVars: c.stmtVars, Timestamp: 1700000000}
+		if sqls := GenSqlsForStatement(ev); !reflect.DeepEqual(sqls, c.expected) {
+			t.Errorf("%s:\n got      %q\n expected %q", c.name, sqls, c.expected)
+		}
+	}
+}
+
+func TestHandleDmlQueryWithoutPrefixDb(t *testing.T) {
+	cases := []struct {
+		sql            string
+		ifPrefixDb     bool
+		expectedOutput bool
+	}{
+		{"insert into t1 values(1)", false, true},
+		{"insert into t1 values(1)", true, true},
+		// USE db0 is needed, it would change the database of the following sqls without database
+		{"insert into t1 select * from t2", false, false},
+		{"insert into t1 select * from t2", true, true},
+	}
+	for _, c := range cases {
+		cfg := &ConfCmd{WorkType: "2sql", OutputFormat: C_outputFormatSql, SqlTblPrefixDb: c.ifPrefixDb,
+			TblFilter: NewTableFilter(nil, nil, nil, nil)}
+		ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.QUERY_EVENT, LogPos: 300, EventSize: 100}}
+		myEv := &MyBinEvent{}
+		myEv.HandleDmlQuery(cfg, ev, &replication.QueryEvent{Schema: []byte("db0"), Query: []byte(c.sql)})
+		if ifOutput := myEv.QuerySql != nil; ifOutput != c.expectedOutput {
+			t.Errorf("%s prefixDb=%v: output %v, expected %v", c.sql, c.ifPrefixDb, ifOutput, c.expectedOutput)
+		}
+	}
+}
@@ -17,7 +17,13 @@ var (
 	Stats_Result_Header_Column_names []string = []string{"binlog", "starttime", "stoptime",
 		"startpos", "stoppos", "inserts", "updates", "deletes", "database", "table"}
 	Stats_DDL_Header_Column_names        []string = []string{"datetime", "binlog", "startpos", "stoppos", "sql"}
-	Stats_BigLongTrx_Header_Column_names []string = []string{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables"}
+	Stats_BigLongTrx_Header_Column_names []string = []string{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables", "queries"}
+	// with -mode=relaylog, they are inserted after stoppos of relay log
+	Stats_Master_Header_Column_names []string = []string{"master_binlog", "master_startpos", "master_stoppos"}
+)
+
+const (
+	C_bigLongTrxMaxQueries int = 10 // at most this number of original sqls are printed for one transaction
 )
 
 type BinEventStats struct {
@@ -31,6 +37,12 @@ type BinEventStats struct {
 	RowCnt        uint32
 	QuerySql      string        // for type=query
 	ParsedSqlInfo *dsql.SqlInfo // for ddl
+	RowsQuery     string        // original sql of rows event
+
+	// coordinates in master binlog with -mode=relaylog, Binlog/StartPos/StopPos are those in relay log
+	MasterBinlog   string
+	MasterStartPos uint32
+	MasterStopPos  uint32
 }
 
 type OrgSqlPrint struct {
@@ -52,6 +64,10 @@ type BinEventStatsPrint struct {
 	Inserts   uint32
 	Updates   uint32
 	Deletes   uint32
+
+	MasterBinlog   string
+	MasterStartPos uint32
+	MasterStopPos  uint32
 }
 
 type BigLongTrxInfo struct {
@@ -65,21 +81,42 @@ type BigLongTrxInfo struct {
 	RowCnt     uint32                       // total row count for all statement
 	Duration   uint32                       // how long the trx lasts
 	Statements map[string]map[string]uint32 // rowcnt for each type statment: insert, update, delete. {db1.tb1:{insert:0, update:2, delete:10}}
+	Queries    []string                     // original sqls from ROWS_QUERY_EVENT
+	QueryCnt   int                          // count of original sqls, including those not kept in Queries
+	lastQuery  string
 
+	MasterBinlog   string
+	MasterStartPos uint32
+	MasterStopPos  uint32
 }
 
 
-func GetBigLongTrxPrintHeaderLine(headers []string) string {
-	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows","duration", "tables"}
-	return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-8s %-10s %s\n", ConvertStrArrToIntferfaceArrForPrint(headers)...)
+func GetBigLongTrxPrintHeaderLine(headers []string, ifRelay bool) string {
+	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows","duration", "tables", "queries"}
+	if ifRelay {
+		return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-17s %-15s %-15s %-8s %-10s %s %s\n",
+			ConvertStrArrToIntferfaceArrForPrint(GetStatsHeaderWithMasterColumns(headers))...)
+	}
+	return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-8s %-10s %s %s\n", ConvertStrArrToIntferfaceArrForPrint(headers)...)
 }
 
 
-func GetStatsPrintHeaderLine(headers []string) string {
+func GetStatsPrintHeaderLine(headers []string, ifRelay bool) string {
 	//[binlog, starttime, stoptime, startpos, stoppos, inserts, updates, deletes, database, table,]
+	if ifRelay {
+		return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-17s %-15s %-15s %-8s %-8s %-8s %-15s %-20s\n",
+			ConvertStrArrToIntferfaceArrForPrint(GetStatsHeaderWithMasterColumns(headers))...)
+	}
 	return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-8s %-8s %-8s %-15s %-20s\n", ConvertStrArrToIntferfaceArrForPrint(headers)...)
 }
 
+// GetStatsHeaderWithMasterColumns inserts the columns of master binlog after stoppos
+func GetStatsHeaderWithMasterColumns(headers []string) []string {
+	var arr []string
+	arr = append(arr, headers[:5]...)
+	arr = append(arr, Stats_Master_Header_Column_names...)
+	return append(arr, headers[5:]...)
+}
 
 func GetDbTbAndQueryAndRowCntFromBinevent(ev *replication.BinlogEvent) (string, string, string, string, uint32) {
 	var (
@@ -191,11 +228,13 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 
 			// trx cannot spreads in different binlogs
 			if querySql == "begin" {
-				oneBigLong = BigLongTrxInfo{Binlog: st.Binlog, StartPos: st.StartPos, StartTime: 0, RowCnt: 0, Statements: map[string]map[string]uint32{}}
+				oneBigLong = BigLongTrxInfo{Binlog: st.Binlog, StartPos: st.StartPos, StartTime: 0, RowCnt: 0, Statements: map[string]map[string]uint32{},
+					MasterBinlog: st.MasterBinlog, MasterStartPos: st.MasterStartPos}
 			} else if querySql == "commit" || querySql == "rollback" {
 				if oneBigLong.StartTime > 0 { // the rows event may be skipped by --databases --tables
 					//big and long trx
 					oneBigLong.StopPos = st.StopPos
+					oneBigLong.MasterStopPos = st.MasterStopPos
 					oneBigLong.StopTime = st.Timestamp
 					oneBigLong.Duration = oneBigLong.StopTime - oneBigLong.StartTime
 					if oneBigLong.RowCnt >= bigTrxRowsLimit || oneBigLong.Duration >= longTrxSecs {
@@ -212,9 +251,13 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 			if oneBigLong.StartPos == 0{
 				oneBigLong.StartPos = st.StartPos
 			}
+			if oneBigLong.MasterBinlog == "" {
+				oneBigLong.MasterBinlog, oneBigLong.MasterStartPos = st.MasterBinlog, st.MasterStartPos
+			}
 
 			oneBigLong.RowCnt += st.RowCnt
 			dbtbKey := GetAbsTableName(st.Database, st.Table)
+			oneBigLong.AddRowsQuery(st.RowsQuery)
 
 			if _, ok := oneBigLong.Statements[dbtbKey]; !ok {
 				oneBigLong.Statements[dbtbKey] = map[string]uint32{"insert": 0, "update": 0, "delete": 0}
@@ -230,7 +273,8 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 			//stats
 			if _, ok := statsPrintArr[oneTbKey]; !ok {
 				statsPrintArr[oneTbKey] = &BinEventStatsPrint{Binlog: st.Binlog, StartTime: st.Timestamp, StartPos: st.StartPos,
-					Database: st.Database, Table: st.Table, Inserts: 0, Updates: 0, Deletes: 0}
+					Database: st.Database, Table: st.Table, Inserts: 0, Updates: 0, Deletes: 0,
+					MasterBinlog: st.MasterBinlog, MasterStartPos: st.MasterStartPos}
 			}
 			switch st.QueryType {
 			case "insert":
@@ -242,6 +286,7 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 			}
 			statsPrintArr[oneTbKey].StopTime = st.Timestamp
 			statsPrintArr[oneTbKey].StopPos = st.StopPos
+			statsPrintArr[oneTbKey].MasterStopPos = st.MasterStopPos
 		}
 
 		if st.Timestamp >= lastPrintTime {
@@ -269,6 +314,14 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 
 func GetStatsPrintContentLine(st *BinEventStatsPrint) string {
 	//[binlog, starttime, stoptime, startpos, stoppos, inserts, updates, deletes, database, table]
+	if st.MasterBinlog != "" {
+		// -mode=relaylog
+		return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-17s %-15d %-15d %-8d %-8d %-8d %-15s %-20s\n",
+			st.Binlog, GetDatetimeStr(int64(st.StartTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
+			GetDatetimeStr(int64(st.StopTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
+			st.StartPos, st.StopPos, st.MasterBinlog, st.MasterStartPos, st.MasterStopPos,
+			st.Inserts, st.Updates, st.Deletes, st.Database, st.Table)
+	}
 	return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-8d %-8d %-8d %-15s %-20s\n",
 		st.Binlog, GetDatetimeStr(int64(st.StartTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 		GetDatetimeStr(int64(st.StopTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
@@ -276,12 +329,40 @@ func GetStatsPrintContentLine(st *BinEventStatsPrint) string {
 }
 
 func GetBigLongTrxContentLine(blTrx BigLongTrxInfo) string {
-	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables"}
-	return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-8d %-10d %s\n", blTrx.Binlog,
+	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables", "queries"}
+	if blTrx.MasterBinlog != "" {
+		// -mode=relaylog
+		return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-17s %-15d %-15d %-8d %-10d %s %s\n", blTrx.Binlog,
+			GetDatetimeStr(int64(blTrx.StartTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
+			GetDatetimeStr(int64(blTrx.StopTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
+			blTrx.StartPos, blTrx.StopPos, blTrx.MasterBinlog, blTrx.MasterStartPos, blTrx.MasterStopPos,
+			blTrx.RowCnt, blTrx.Duration, GetBigLongTrxStatementsStr(blTrx.Statements), blTrx.GetQueriesStr())
+	}
+	return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-8d %-10d %s %s\n", blTrx.Binlog,
 		GetDatetimeStr(int64(blTrx.StartTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 		GetDatetimeStr(int64(blTrx.StopTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 		blTrx.StartPos, blTrx.StopPos,
-		blTrx.RowCnt, blTrx.Duration, GetBigLongTrxStatementsStr(blTrx.Statements))
+		blTrx.RowCnt, blTrx.Duration, GetBigLongTrxStatementsStr(blTrx.Statements), blTrx.GetQueriesStr())
+}
+
+// AddRowsQuery records the original sql, consecutive rows events of one sql have the same original sql
+func (this *BigLongTrxInfo) AddRowsQuery(query string) {
+	if query == "" || (this.QueryCnt > 0 && this.lastQuery == query) {
+		return
+	}
+	this.lastQuery = query
+	this.QueryCnt++
+	if len(this.Queries) < C_bigLongTrxMaxQueries {
+		this.Queries = append(this.Queries, strings.Join(strings.Fields(query), " "))
+	}
+}
+
+func (this *BigLongTrxInfo) GetQueriesStr() string {
+	str := fmt.Sprintf("[%s]", strings.Join(this.Queries, "; "))
+	if this.QueryCnt > len(this.Queries) {
+		str += fmt.Sprintf("(%d more)", this.QueryCnt-len(this.Queries))
+	}
+	return str
 }
 
 func GetBigLongTrxStatementsStr(st map[string]map[string]uint32) string {
@@ -0,0 +1,84 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	C_binlogStdin     = "-"            // -local-binlog-file=- reads binlog stream from stdin
+	C_stdinBinlogName = "stdin.000001" // name of the first binlog in stream if -start-file is not set
+	C_eventTypeOffset = 4              // offset of event type in event header
+)
+
+// IfBinlogStream returns true if -local-binlog-file is stdin or a named pipe, which cannot be seeked nor reopened
+func IfBinlogStream(name string) bool {
+	if name == C_binlogStdin {
+		return true
+	}
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// BinlogStreamReader reads binlog files concatenated in one stream, such as `cat mysql-bin.0000*`,
+// each binlog file starts with the magic number \xfebin followed by FORMAT_DESCRIPTION_EVENT
+type BinlogStreamReader struct {
+	reader       *bufio.Reader
+	decompressor io.Closer
+}
+
+func NewBinlogStreamReader(r io.Reader) (*BinlogStreamReader, error) {
+	reader, decompressor, err := NewBinlogReader(r)
+	if err != nil {
+		return nil, err
+	}
+	bufReader, ok := reader.(*bufio.Reader)
+	if !ok {
+		bufReader = bufio.NewReaderSize(reader, C_binlogReadBufSize)
+	}
+	return &BinlogStreamReader{reader: bufReader, decompressor: decompressor}, nil
+}
+
+func (this *BinlogStreamReader) Read(p []byte) (int, error) {
+	return this.reader.Read(p)
+}
+
+func (this *BinlogStreamReader) Close() error {
+	if this.decompressor != nil {
+		return this.decompressor.Close()
+	}
+	return nil
+}
+
+// ReadBinlogMagic reads the magic number at the head of the next binlog file, io.EOF is returned at the end of stream
+func (this *BinlogStreamReader) ReadBinlogMagic() error {
+	b := make([]byte, len(replication.BinLogFileHeader))
+	if _, err := io.ReadFull(this.reader, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("incomplete binlog magic number at the end of stream")
+		}
+		return err
+	}
+	if !bytes.Equal(b, replication.BinLogFileHeader) {
+		return fmt.Errorf("not a valid binlog stream, head 4 bytes of binlog must be fe'bin', but got %x", b)
+	}
+	return nil
+}
+
+// IfNextBinlog returns true if the next binlog file follows, the magic number is not consumed.
+// the timestamp of event header may equal the magic number, so the event type after it must be FORMAT_DESCRIPTION_EVENT
+func (this *BinlogStreamReader) IfNextBinlog() bool {
+	magicLen := len(replication.BinLogFileHeader)
+	b, err := this.reader.Peek(magicLen + C_eventTypeOffset + 1)
+	if err != nil {
+		// end of stream or incomplete event header, it is handled when reading event header
+		return false
+	}
+	return bytes.Equal(b[:magicLen], replication.BinLogFileHeader) &&
+		replication.EventType(b[magicLen+C_eventTypeOffset]) == replication.FORMAT_DESCRIPTION_EVENT
+}
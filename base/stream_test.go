@@ -0,0 +1,91 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// testStreamEvent returns an event of size bytes, its timestamp is the magic number of binlog if ifMagicTs
+func testStreamEvent(tp replication.EventType, size int, ifMagicTs bool) []byte {
+	ev := append(testEventHeader(tp, size-replication.EventHeaderSize), make([]byte, size-replication.EventHeaderSize)...)
+	if ifMagicTs {
+		copy(ev, replication.BinLogFileHeader)
+	}
+	return ev
+}
+
+// testReadBinlogStream reads the stream the same as file mode, it returns the event types of each binlog in stream
+func testReadBinlogStream(t *testing.T, stream *BinlogStreamReader) [][]replication.EventType {
+	var binlogs [][]replication.EventType
+	for {
+		if err := stream.ReadBinlogMagic(); err == io.EOF {
+			return binlogs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var types []replication.EventType
+		for !stream.IfNextBinlog() {
+			h := make([]byte, replication.EventHeaderSize)
+			if _, err := io.ReadFull(stream, h); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			size := binary.LittleEndian.Uint32(h[9:])
+			if _, err := io.CopyN(ioutil.Discard, stream, int64(size)-int64(replication.EventHeaderSize)); err != nil {
+				t.Fatal(err)
+			}
+			types = append(types, replication.EventType(h[C_eventTypeOffset]))
+		}
+		binlogs = append(binlogs, types)
+	}
+}
+
+func TestBinlogStreamReaderIfNextBinlog(t *testing.T) {
+	fde := testFormatDescription()
+	// the timestamp of xid event is the magic number, it is not the next binlog as it is not followed by format description
+	first := bytes.Join([][]byte{replication.BinLogFileHeader, fde, testStreamEvent(replication.QUERY_EVENT, 40, false),
+		testStreamEvent(replication.XID_EVENT, 31, true), testStreamEvent(replication.ROTATE_EVENT, 44, false)}, nil)
+	second := bytes.Join([][]byte{replication.BinLogFileHeader, fde, testStreamEvent(replication.XID_EVENT, 31, true)}, nil)
+	stream := append(append([]byte{}, first...), second...)
+	expected := [][]replication.EventType{
+		{replication.FORMAT_DESCRIPTION_EVENT, replication.QUERY_EVENT, replication.XID_EVENT, replication.ROTATE_EVENT},
+		{replication.FORMAT_DESCRIPTION_EVENT, replication.XID_EVENT},
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"raw", stream},
+		{"gzip", testCompress(t, "gzip", stream)},
+		{"zstd", testCompress(t, "zstd", stream)},
+	}
+	for _, c := range cases {
+		reader, err := NewBinlogStreamReader(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		binlogs := testReadBinlogStream(t, reader)
+		reader.Close()
+		if !reflect.DeepEqual(binlogs, expected) {
+			t.Errorf("%s: events of binlogs in stream are %v, expected %v", c.name, binlogs, expected)
+		}
+	}
+
+	for _, data := range [][]byte{{0xfe, 'b'}, []byte("#!/bin/sh\n")} {
+		reader, err := NewBinlogStreamReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reader.ReadBinlogMagic(); err == nil || err == io.EOF {
+			t.Errorf("error expected for invalid binlog stream %q, but got %v", data, err)
+		}
+	}
+}
@@ -1,11 +1,133 @@
 package base
 
 import (
-//	"github.com/openark/golib/log"
- // "github.com/siddontang/go-log/log"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	constvar "my2sql/constvar"
+	toolkits "my2sql/toolkits"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+var (
+	GSystemDatabases []string = []string{"mysql", "information_schema", "performance_schema", "sys"}
 )
 
+// table definitions are kept in the json file as an array of TblInfoJson, ex:
+// [{"database":"db1","table":"tb1","columns":[{"column_name":"id","column_type":"int","is_unsigned":true}],
+//   "primary_key":["id"],"unique_keys":[]}]
+func (this *TablesColumnsInfo) ReadTblDefsFromJsonFile(jsonFile string) error {
+	content, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		log.Errorf("fail to read table definition file %s: %v", jsonFile, err)
+		return errors.Trace(err)
+	}
+
+	var tbDefs []*TblInfoJson
+	err = json.Unmarshal(content, &tbDefs)
+	if err != nil {
+		log.Errorf("fail to parse table definition file %s: %v", jsonFile, err)
+		return errors.Trace(err)
+	}
+
+	if len(this.tableInfos) < 1 {
+		this.tableInfos = map[string]*TblInfoJson{}
+	}
+	for _, oneTb := range tbDefs {
+		if oneTb.Database == "" || oneTb.Table == "" {
+			return errors.Errorf("schema/table is empty in table definition file %s", jsonFile)
+		}
+		if oneTb.PrimaryKey == nil {
+			oneTb.PrimaryKey = KeyInfo{}
+		}
+		if oneTb.UniqueKeys == nil {
+			oneTb.UniqueKeys = []KeyInfo{}
+		}
+		this.tableInfos[GetAbsTableName(oneTb.Database, oneTb.Table)] = oneTb
+	}
+	log.Infof("read %d table definitions from %s", len(tbDefs), jsonFile)
+	return nil
+}
+
+func (this *TablesColumnsInfo) DumpTblDefsToJsonFile(cfg *ConfCmd, jsonFile string) error {
+	query := "SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.TABLES WHERE TABLE_TYPE = 'BASE TABLE'"
+	rows, err := cfg.FromDB.Query(query)
+	if err != nil {
+		log.Errorf("%v fail to query mysql: "+query, err)
+		return errors.Trace(err)
+	}
 
+	var dbTbs [][]string
+	for rows.Next() {
+		var db, tb string
+		if err = rows.Scan(&db, &tb); err != nil {
+			rows.Close()
+			log.Errorf("rows scan err %v", err)
+			return errors.Trace(err)
+		}
+		if toolkits.ContainsString(GSystemDatabases, db) || !cfg.IsTargetTable(db, tb) {
+			continue
+		}
+		dbTbs = append(dbTbs, []string{db, tb})
+	}
+	rows.Close()
 
+	tbDefs := make([]*TblInfoJson, 0, len(dbTbs))
+	for _, dbTb := range dbTbs {
+		if err = this.GetTableColumns(cfg.FromDB, dbTb[0], dbTb[1]); err != nil {
+			return errors.Trace(err)
+		}
+		if err = this.GetTableKeysInfo(cfg.FromDB, dbTb[0], dbTb[1]); err != nil {
+			return errors.Trace(err)
+		}
+		tbDefs = append(tbDefs, this.tableInfos[GetAbsTableName(dbTb[0], dbTb[1])])
+	}
+	sort.Slice(tbDefs, func(i, j int) bool {
+		return GetAbsTableName(tbDefs[i].Database, tbDefs[i].Table) < GetAbsTableName(tbDefs[j].Database, tbDefs[j].Table)
+	})
 
+	content, err := json.MarshalIndent(tbDefs, "", constvar.JSON_INDENT_TAB)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = ioutil.WriteFile(jsonFile, append(content, '\n'), 0644)
+	if err != nil {
+		log.Errorf("fail to write table definition file %s: %v", jsonFile, err)
+		return errors.Trace(err)
+	}
+	log.Infof("dump %d table definitions into %s", len(tbDefs), jsonFile)
+	return nil
+}
 
+// GetTblInfoFromTableMap gets table definition from the optional metadata of table map event,
+// which is logged when binlog_row_metadata=FULL(mysql 8.0.1+). nil is returned if column names are not logged.
+// unique keys are not logged, so only primary key is used to build where condition
+func GetTblInfoFromTableMap(tbMap *replication.TableMapEvent) *TblInfoJson {
+	if len(tbMap.ColumnName) == 0 {
+		return nil
+	}
+	colNames := tbMap.ColumnNameString()
+	unsignedMap := tbMap.UnsignedMap()
+	collationMap := tbMap.CollationMap()
+	tbInfo := &TblInfoJson{Database: string(tbMap.Schema), Table: string(tbMap.Table),
+		Columns: make([]FieldInfo, len(colNames)), PrimaryKey: KeyInfo{}, UniqueKeys: []KeyInfo{}, UniqueKeyNames: []string{}}
+	for i, colName := range colNames {
+		typeName, _ := GetMysqlDataTypeNameAndSqlColumn("", colName, tbMap.ColumnType[i], tbMap.ColumnMeta[i])
+		if typeName == "blob" {
+			if collation, ok := collationMap[i]; ok && collation != C_binaryCollationId {
+				// text is stored as blob
+				typeName = "text"
+			}
+		}
+		tbInfo.Columns[i] = FieldInfo{FieldName: colName, FieldType: typeName, IsUnsigned: unsignedMap[i]}
+	}
+	for _, colIdx := range tbMap.PrimaryKey {
+		if int(colIdx) < len(colNames) {
+			tbInfo.PrimaryKey = append(tbInfo.PrimaryKey, colNames[colIdx])
+		}
+	}
+	return tbInfo
+}
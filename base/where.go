@@ -0,0 +1,290 @@
+package base
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"my2sql/dsql"
+	"my2sql/sqltypes"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_whereFalse   int8 = 0
+	C_whereTrue    int8 = 1
+	C_whereUnknown int8 = 2 // comparison with NULL
+)
+
+var (
+	gWhereMissingColumns map[string]bool = map[string]bool{} // db.tb.col not found, warned already
+)
+
+// whereRowContext is one row of rows event to evaluate -where, before is nil for insert and after is nil for delete
+type whereRowContext struct {
+	rEv      *replication.RowsEvent
+	colNames []FieldInfo
+	colIdx   map[string]int // lower case column name => index
+	enumMap  map[int][]string
+	setMap   map[int][]string
+	before   []interface{}
+	after    []interface{}
+}
+
+// FilterRowsByWhere removes the rows not matching -where from the rows event, both images of update are kept or removed.
+// It returns false if no row is left
+func (this *MyBinEvent) FilterRowsByWhere(cfg *ConfCmd, ev *replication.BinlogEvent, rEv *replication.RowsEvent) bool {
+	if cfg.WhereExpr == nil || len(rEv.Rows) == 0 {
+		return true
+	}
+	tbInfo, err := GetTblInfoForRowsEvent(rEv, this.MyPos)
+	if err != nil {
+		// the rows are kept, the worker fails to get the table struct in the same way, then skips and reports the event
+		return true
+	}
+	rowCtx := &whereRowContext{rEv: rEv, colNames: GetAllFieldNamesWithDroppedFields(len(rEv.Rows[0]), tbInfo.Columns),
+		colIdx: map[string]int{}, enumMap: rEv.Table.EnumStrValueMap(), setMap: rEv.Table.SetStrValueMap()}
+	for ci, col := range rowCtx.colNames {
+		rowCtx.colIdx[strings.ToLower(col.FieldName)] = ci
+	}
+
+	var rows [][]interface{}
+	switch ev.Header.EventType {
+	case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		for i := 0; i+1 < len(rEv.Rows); i += 2 {
+			rowCtx.before, rowCtx.after = rEv.Rows[i], rEv.Rows[i+1]
+			if rowCtx.Eval(cfg.WhereExpr) == C_whereTrue {
+				rows = append(rows, rEv.Rows[i], rEv.Rows[i+1])
+			}
+		}
+	case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range rEv.Rows {
+			rowCtx.before, rowCtx.after = row, nil
+			if rowCtx.Eval(cfg.WhereExpr) == C_whereTrue {
+				rows = append(rows, row)
+			}
+		}
+	default:
+		for _, row := range rEv.Rows {
+			rowCtx.before, rowCtx.after = nil, row
+			if rowCtx.Eval(cfg.WhereExpr) == C_whereTrue {
+				rows = append(rows, row)
+			}
+		}
+	}
+	rEv.Rows = rows
+	return len(rows) > 0
+}
+
+// Eval evaluates the expression in three-valued logic of SQL, only true rows are kept
+func (this *whereRowContext) Eval(expr *dsql.WhereExpr) int8 {
+	switch expr.Type {
+	case dsql.WHERE_EXPR_AND:
+		re := C_whereTrue
+		for _, child := range expr.Children {
+			switch this.Eval(child) {
+			case C_whereFalse:
+				return C_whereFalse
+			case C_whereUnknown:
+				re = C_whereUnknown
+			}
+		}
+		return re
+	case dsql.WHERE_EXPR_OR:
+		re := C_whereFalse
+		for _, child := range expr.Children {
+			switch this.Eval(child) {
+			case C_whereTrue:
+				return C_whereTrue
+			case C_whereUnknown:
+				re = C_whereUnknown
+			}
+		}
+		return re
+	case dsql.WHERE_EXPR_NOT:
+		re := this.Eval(expr.Children[0])
+		if re == C_whereUnknown {
+			return re
+		}
+		return 1 - re
+	case dsql.WHERE_EXPR_COMPARE:
+		left := this.GetOperandValue(expr.Left)
+		right := this.GetOperandValue(expr.Right)
+		if expr.Op == "<=>" {
+			if left == nil || right == nil {
+				return GetWhereBool(left == nil && right == nil)
+			}
+			return GetWhereBool(CompareWhereValues(left, right) == 0)
+		}
+		if left == nil || right == nil {
+			return C_whereUnknown
+		}
+		cmp := CompareWhereValues(left, right)
+		switch expr.Op {
+		case "=":
+			return GetWhereBool(cmp == 0)
+		case "!=":
+			return GetWhereBool(cmp != 0)
+		case "<":
+			return GetWhereBool(cmp < 0)
+		case "<=":
+			return GetWhereBool(cmp <= 0)
+		case ">":
+			return GetWhereBool(cmp > 0)
+		case ">=":
+			return GetWhereBool(cmp >= 0)
+		}
+	case dsql.WHERE_EXPR_IN:
+		left := this.GetOperandValue(expr.Left)
+		if left == nil {
+			return C_whereUnknown
+		}
+		re := C_whereFalse
+		for _, oneOperand := range expr.List {
+			oneVal := this.GetOperandValue(oneOperand)
+			if oneVal == nil {
+				re = C_whereUnknown
+			} else if CompareWhereValues(left, oneVal) == 0 {
+				re = C_whereTrue
+				break
+			}
+		}
+		if expr.Not && re != C_whereUnknown {
+			return 1 - re
+		}
+		return re
+	case dsql.WHERE_EXPR_IS_NULL:
+		return GetWhereBool((this.GetOperandValue(expr.Left) == nil) != expr.Not)
+	case dsql.WHERE_EXPR_CHANGED:
+		// only update changes column, insert and delete do not
+		if this.before == nil || this.after == nil {
+			return C_whereFalse
+		}
+		ci, ok := this.GetColumnIndex(expr.Left.Column)
+		if !ok {
+			return C_whereFalse
+		}
+		return GetWhereBool(!reflect.DeepEqual(this.before[ci], this.after[ci]))
+	}
+	return C_whereUnknown
+}
+
+func GetWhereBool(b bool) int8 {
+	if b {
+		return C_whereTrue
+	}
+	return C_whereFalse
+}
+
+func (this *whereRowContext) GetColumnIndex(colName string) (int, bool) {
+	ci, ok := this.colIdx[strings.ToLower(colName)]
+	if !ok {
+		colKey := fmt.Sprintf("%s.%s", GetAbsTableName(string(this.rEv.Table.Schema), string(this.rEv.Table.Table)), colName)
+		if !gWhereMissingColumns[colKey] {
+			gWhereMissingColumns[colKey] = true
+			log.Warnf("column %s of -where not found, it is taken as NULL", colKey)
+		}
+	}
+	return ci, ok
+}
+
+// GetOperandValue returns nil for NULL, *big.Rat for number and string for others
+func (this *whereRowContext) GetOperandValue(operand dsql.WhereOperand) interface{} {
+	if !operand.IsColumn {
+		if operand.IsNull {
+			return nil
+		}
+		if operand.IsNumber {
+			if r, ok := new(big.Rat).SetString(operand.Value); ok {
+				return r
+			}
+		}
+		return operand.Value
+	}
+	row := this.after
+	if operand.Image == dsql.WHERE_IMAGE_OLD || (operand.Image == dsql.WHERE_IMAGE_ROW && this.after == nil) {
+		row = this.before
+	}
+	if row == nil {
+		return nil
+	}
+	ci, ok := this.GetColumnIndex(operand.Column)
+	if !ok || ci >= len(row) {
+		return nil
+	}
+	return this.GetColumnValue(ci, row[ci])
+}
+
+// GetColumnValue converts column value decoded from binlog for comparison. enum and set are converted
+// to string only if binlog_row_metadata=FULL, otherwise they are compared as index and bitmap
+func (this *whereRowContext) GetColumnValue(ci int, v interface{}) interface{} {
+	col := this.colNames[ci]
+	if idx, ok := v.(int64); ok {
+		if enumVals, isEnum := this.enumMap[ci]; isEnum {
+			return GetEnumStr(enumVals, idx)
+		}
+		if setVals, isSet := this.setMap[ci]; isSet {
+			return GetSetStr(setVals, idx)
+		}
+	}
+	if col.IsUnsigned {
+		v = sqltypes.ConvertIntUnsigned(v, col.FieldType)
+	}
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return string(val)
+	case string:
+		// decimal, and unsigned bigint converted by ConvertIntUnsigned
+		colType := strings.ToLower(col.FieldType)
+		if colType == "decimal" || (col.IsUnsigned && strings.Contains(colType, "int")) {
+			if r, ok := new(big.Rat).SetString(val); ok {
+				return r
+			}
+		}
+		return val
+	case float32:
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(float64(val), 'g', -1, 32))
+		return r
+	case float64:
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(val, 'g', -1, 64))
+		return r
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		r, _ := new(big.Rat).SetString(fmt.Sprintf("%d", val))
+		return r
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// CompareWhereValues compares as numbers if either is number and the other can be converted to number, otherwise as strings
+func CompareWhereValues(a interface{}, b interface{}) int {
+	aRat, aIsRat := a.(*big.Rat)
+	bRat, bIsRat := b.(*big.Rat)
+	if aIsRat && !bIsRat {
+		if r, ok := new(big.Rat).SetString(strings.TrimSpace(b.(string))); ok {
+			bRat, bIsRat = r, true
+		}
+	} else if bIsRat && !aIsRat {
+		if r, ok := new(big.Rat).SetString(strings.TrimSpace(a.(string))); ok {
+			aRat, aIsRat = r, true
+		}
+	}
+	if aIsRat && bIsRat {
+		return aRat.Cmp(bRat)
+	}
+	return strings.Compare(GetWhereValueStr(a), GetWhereValueStr(b))
+}
+
+func GetWhereValueStr(v interface{}) string {
+	if r, ok := v.(*big.Rat); ok {
+		if r.IsInt() {
+			return r.Num().String()
+		}
+		return r.FloatString(10)
+	}
+	return v.(string)
+}
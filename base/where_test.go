@@ -0,0 +1,159 @@
+package base
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"my2sql/dsql"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// testWhereTableMap returns table map of db1.t1(id bigint unsigned, price decimal(10,2), name varchar(32),
+// status enum('new','paid','closed'), tags set('x','y','z')) with binlog_row_metadata=FULL
+func testWhereTableMap() *replication.TableMapEvent {
+	return &replication.TableMapEvent{
+		Schema:      []byte("db1"),
+		Table:       []byte("t1"),
+		ColumnCount: 5,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_VARCHAR,
+			mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+		ColumnMeta:       []uint16{0, 10<<8 | 2, 128, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1},
+		ColumnName:       [][]byte{[]byte("id"), []byte("price"), []byte("name"), []byte("status"), []byte("tags")},
+		SignednessBitmap: []byte{0x80},
+		EnumStrValue:     [][][]byte{{[]byte("new"), []byte("paid"), []byte("closed")}},
+		SetStrValue:      [][][]byte{{[]byte("x"), []byte("y"), []byte("z")}},
+	}
+}
+
+func testFilterRowsByWhere(t *testing.T, whereStr string, eventType replication.EventType, rows [][]interface{}) [][]interface{} {
+	whereExpr, err := dsql.ParseWhereExpr(whereStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ConfCmd{WhereExpr: whereExpr}
+	rEv := &replication.RowsEvent{Table: testWhereTableMap(), Rows: rows}
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: eventType}, Event: rEv}
+	myEv := &MyBinEvent{}
+	if left := myEv.FilterRowsByWhere(cfg, ev, rEv); left != (len(rEv.Rows) > 0) {
+		t.Errorf("%s: FilterRowsByWhere returns %v with %d rows left", whereStr, left, len(rEv.Rows))
+	}
+	return rEv.Rows
+}
+
+func TestFilterRowsByWhere(t *testing.T) {
+	// id=2^64-1 is -1 decoded as signed bigint, status and tags are index and bitmap
+	row1 := []interface{}{int64(-1), "10.50", "a", int64(1), int64(5)}
+	row2 := []interface{}{int64(2), "9.5", nil, int64(3), int64(0)}
+	row3 := []interface{}{int64(3), nil, "b", int64(2), int64(2)}
+	insertRows := [][]interface{}{row1, row2, row3}
+	cases := []struct {
+		where    string
+		expected [][]interface{}
+	}{
+		{"id = 18446744073709551615", [][]interface{}{row1}},
+		{"id > 2", [][]interface{}{row1, row3}},
+		{"id = '2'", [][]interface{}{row2}},
+		{"price = 10.5", [][]interface{}{row1}},
+		{"price >= 9.50 and price < '10.500'", [][]interface{}{row2}},
+		{"status = 'paid'", [][]interface{}{row3}},
+		{"status in ('new', 'closed')", [][]interface{}{row1, row2}},
+		{"tags = 'x,z'", [][]interface{}{row1}},
+		{"tags = ''", [][]interface{}{row2}},
+		{"name = 'a' or name = 'b'", [][]interface{}{row1, row3}},
+		// NULL is unknown in comparison, the row is not kept even with NOT
+		{"name != 'a'", [][]interface{}{row3}},
+		{"not (name = 'a')", [][]interface{}{row3}},
+		{"name is null", [][]interface{}{row2}},
+		{"name is not null and price is null", [][]interface{}{row3}},
+		{"name <=> null", [][]interface{}{row2}},
+		{"name in ('a', null)", [][]interface{}{row1}},
+		{"name not in ('a', null)", nil},
+		{"price > 1 or name = 'x'", [][]interface{}{row1, row2}},
+		{"no_such_col = 1", nil},
+		{"no_such_col is null", insertRows},
+		{"changed(name)", nil},
+	}
+	for _, c := range cases {
+		rows := testFilterRowsByWhere(t, c.where, replication.WRITE_ROWS_EVENTv2, append([][]interface{}{}, insertRows...))
+		if !reflect.DeepEqual(rows, c.expected) {
+			t.Errorf("%s: rows left are %v, expected %v", c.where, rows, c.expected)
+		}
+	}
+}
+
+func TestFilterRowsByWhereUpdate(t *testing.T) {
+	before1 := []interface{}{int64(1), "10.00", "a", int64(1), int64(1)}
+	after1 := []interface{}{int64(1), "10.00", "a", int64(2), int64(1)}
+	before2 := []interface{}{int64(2), "20.00", "b", int64(1), int64(1)}
+	after2 := []interface{}{int64(2), "25.00", "b", int64(1), int64(1)}
+	updateRows := [][]interface{}{before1, after1, before2, after2}
+	cases := []struct {
+		where    string
+		expected [][]interface{}
+	}{
+		{"changed(status)", [][]interface{}{before1, after1}},
+		{"changed(price) or changed(name)", [][]interface{}{before2, after2}},
+		{"old.status = 'new' and new.status = 'paid'", [][]interface{}{before1, after1}},
+		{"status = 'paid'", [][]interface{}{before1, after1}},
+		{"new.price > old.price", [][]interface{}{before2, after2}},
+		{"old.price = 25", nil},
+	}
+	for _, c := range cases {
+		rows := testFilterRowsByWhere(t, c.where, replication.UPDATE_ROWS_EVENTv2, append([][]interface{}{}, updateRows...))
+		if !reflect.DeepEqual(rows, c.expected) {
+			t.Errorf("%s: rows left are %v, expected %v", c.where, rows, c.expected)
+		}
+	}
+
+	// the before image is used for delete
+	deleteRows := [][]interface{}{before1, before2}
+	if rows := testFilterRowsByWhere(t, "name = 'b' and old.id = 2", replication.DELETE_ROWS_EVENTv2, deleteRows); !reflect.DeepEqual(rows, [][]interface{}{before2}) {
+		t.Errorf("delete: rows left are %v", rows)
+	}
+}
+
+func TestCompareWhereValues(t *testing.T) {
+	rat := func(s string) *big.Rat {
+		r, _ := new(big.Rat).SetString(s)
+		return r
+	}
+	cases := []struct {
+		a        interface{}
+		b        interface{}
+		expected int
+	}{
+		{rat("1"), rat("1.0"), 0},
+		{rat("-1"), rat("2"), -1},
+		{rat("10"), "9", 1},
+		{" 10 ", rat("10"), 0},
+		{rat("10"), "abc", -1},
+		{"abc", "abd", -1},
+		{rat("0.1"), "0.10", 0},
+		{"18446744073709551615", rat("18446744073709551614"), 1},
+	}
+	for _, c := range cases {
+		if cmp := CompareWhereValues(c.a, c.b); cmp != c.expected {
+			t.Errorf("compare %v with %v: got %d, expected %d", c.a, c.b, cmp, c.expected)
+		}
+	}
+}
+
+func TestFilterRowsByWhereMissingTblDef(t *testing.T) {
+	defer func(onlyFromFile bool) { GConfCmd.OnlyColFromFile = onlyFromFile }(GConfCmd.OnlyColFromFile)
+	GConfCmd.OnlyColFromFile = true
+	whereExpr, err := dsql.ParseWhereExpr("id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rows are kept for the worker to skip and report the event
+	rows := [][]interface{}{{int64(2)}, {int64(3)}}
+	rEv := &replication.RowsEvent{Table: &replication.TableMapEvent{Schema: []byte("db1"), Table: []byte("no_def"), ColumnCount: 1,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG}, ColumnMeta: []uint16{0}}, ColumnCount: 1, Rows: rows}
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2}, Event: rEv}
+	myEv := &MyBinEvent{MyPos: mysql.Position{Name: "mysql-bin.000001", Pos: 100}}
+	if !myEv.FilterRowsByWhere(&ConfCmd{WhereExpr: whereExpr}, ev, rEv) || !reflect.DeepEqual(rEv.Rows, rows) {
+		t.Errorf("rows of table without struct are filtered: %v", rEv.Rows)
+	}
+}
@@ -0,0 +1,620 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type sqlToken struct {
+	val    string
+	quoted bool // `ident`, 'str' or "str"
+	start  int  // offset of the token in the runes of sql
+}
+
+type ddlParser struct {
+	tokens []sqlToken
+	pos    int
+	useDb  string
+}
+
+// ParseDdlSql parses CREATE/ALTER/DROP/RENAME TABLE statements, just as much as needed
+// to keep track of columns, primary key and unique keys of tables.
+// nil is returned if the sql is not one of them.
+func ParseDdlSql(sqlStr string, useDb string) (*SqlInfo, error) {
+	p := &ddlParser{tokens: splitSqlTokens(sqlStr), useDb: useDb}
+	info := &SqlInfo{UseDatabase: useDb, SqlStr: sqlStr, SqlType: SQL_TYPE_UNKNOWN}
+	var err error
+
+	switch {
+	case p.isKw("create"):
+		p.next()
+		p.skipKw("temporary")
+		if !p.isKw("table") {
+			return nil, nil
+		}
+		p.next()
+		info.SqlType = SQL_TYPE_CREATE_TABLE
+		err = p.parseCreateTable(info)
+	case p.isKw("alter"):
+		p.next()
+		p.skipKw("online", "offline", "ignore")
+		if !p.isKw("table") {
+			return nil, nil
+		}
+		p.next()
+		info.SqlType = SQL_TYPE_ALTER_TABLE
+		err = p.parseAlterTable(info)
+	case p.isKw("drop"):
+		p.next()
+		p.skipKw("temporary")
+		if !p.isKw("table") {
+			return nil, nil
+		}
+		p.next()
+		info.SqlType = SQL_TYPE_DROP_TABLE
+		err = p.parseDropTable(info)
+	case p.isKw("rename"):
+		p.next()
+		if !p.isKw("table") {
+			return nil, nil
+		}
+		p.next()
+		info.SqlType = SQL_TYPE_RENAME_TABLE
+		err = p.parseRenameTable(info)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse ddl %s: %v", sqlStr, err)
+	}
+	return info, nil
+}
+
+// splitSqlTokens splits sql into identifiers, keywords, literals and punctuations. comments are removed
+func splitSqlTokens(sqlStr string) []sqlToken {
+	var tokens []sqlToken
+	s := []rune(sqlStr)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && i+2 < len(s) && s[i+1] == '-' && (s[i+2] == ' ' || s[i+2] == '\t')):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			i += 2
+			for i+1 < len(s) && !(s[i] == '*' && s[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '`' || c == '\'' || c == '"':
+			var buf []rune
+			start := i
+			i++
+			for i < len(s) {
+				if s[i] == '\\' && c != '`' && i+1 < len(s) {
+					buf = append(buf, s[i], s[i+1])
+					i += 2
+					continue
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						buf = append(buf, c)
+						i += 2
+						continue
+					}
+					break
+				}
+				buf = append(buf, s[i])
+				i++
+			}
+			i++
+			tokens = append(tokens, sqlToken{val: string(buf), quoted: true, start: start})
+		case isIdentRune(c):
+			j := i
+			for j < len(s) && isIdentRune(s[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{val: string(s[i:j]), start: i})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{val: string(c), start: i})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c == '$' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c > 127
+}
+
+func (this *ddlParser) end() bool {
+	return this.pos >= len(this.tokens)
+}
+
+func (this *ddlParser) peek() sqlToken {
+	if this.end() {
+		return sqlToken{}
+	}
+	return this.tokens[this.pos]
+}
+
+func (this *ddlParser) next() sqlToken {
+	t := this.peek()
+	this.pos++
+	return t
+}
+
+// isKw checks if current token is one of the unquoted keywords
+func (this *ddlParser) isKw(kws ...string) bool {
+	t := this.peek()
+	if t.quoted {
+		return false
+	}
+	for _, kw := range kws {
+		if strings.EqualFold(t.val, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *ddlParser) isPunct(p string) bool {
+	t := this.peek()
+	return !t.quoted && t.val == p
+}
+
+func (this *ddlParser) skipKw(kws ...string) bool {
+	if this.isKw(kws...) {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+// restHasKw checks if any of the unquoted keywords is in the rest tokens
+func (this *ddlParser) restHasKw(kws ...string) bool {
+	for _, t := range this.tokens[this.pos:] {
+		if t.quoted {
+			continue
+		}
+		for _, kw := range kws {
+			if strings.EqualFold(t.val, kw) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this *ddlParser) skipIfNotExists() {
+	if this.isKw("if") {
+		this.next()
+		this.skipKw("not")
+		this.skipKw("exists")
+	}
+}
+
+func (this *ddlParser) parseIdent() (string, error) {
+	t := this.next()
+	if t.val == "" && !t.quoted {
+		return "", fmt.Errorf("identifier expected at the end")
+	}
+	if !t.quoted && !isIdentRune([]rune(t.val)[0]) {
+		return "", fmt.Errorf("identifier expected, but got %s", t.val)
+	}
+	return t.val, nil
+}
+
+func (this *ddlParser) parseTableName() (DbTable, error) {
+	name, err := this.parseIdent()
+	if err != nil {
+		return DbTable{}, err
+	}
+	if this.isPunct(".") {
+		this.next()
+		tb, err := this.parseIdent()
+		if err != nil {
+			return DbTable{}, err
+		}
+		return DbTable{Database: name, Table: tb}, nil
+	}
+	return DbTable{Database: this.useDb, Table: name}, nil
+}
+
+// skipBalanced skips "(...)", the current token must be "("
+func (this *ddlParser) skipBalanced() {
+	depth := 0
+	for !this.end() {
+		t := this.next()
+		if t.quoted {
+			continue
+		}
+		if t.val == "(" {
+			depth++
+		} else if t.val == ")" {
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// skipToSeparator skips tokens until "," or ")" at the current level, which is not consumed
+func (this *ddlParser) skipToSeparator() {
+	for !this.end() {
+		if this.isPunct(",") || this.isPunct(")") {
+			return
+		}
+		if this.isPunct("(") {
+			this.skipBalanced()
+			continue
+		}
+		this.next()
+	}
+}
+
+// parseKeyColumns parses (col1, col2(10) DESC, ...)
+func (this *ddlParser) parseKeyColumns() ([]string, error) {
+	if !this.isPunct("(") {
+		return nil, fmt.Errorf("( expected for key columns, but got %s", this.peek().val)
+	}
+	this.next()
+	var cols []string
+	for !this.end() {
+		if this.isPunct("(") {
+			// functional key part, ex: ((lower(col)))
+			this.skipBalanced()
+		} else {
+			col, err := this.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, col)
+		}
+		this.skipToSeparator()
+		if this.isPunct(")") {
+			this.next()
+			return cols, nil
+		}
+		this.next()
+	}
+	return nil, fmt.Errorf("unexpected end of key columns")
+}
+
+// parseKeyDef parses the part after PRIMARY/UNIQUE: [KEY|INDEX] [name] [USING BTREE] (cols)
+func (this *ddlParser) parseKeyDef(primary bool) (KeyDef, error) {
+	key := KeyDef{Primary: primary}
+	this.skipKw("key", "index")
+	if !this.isPunct("(") && !this.isKw("using") {
+		name, err := this.parseIdent()
+		if err != nil {
+			return key, err
+		}
+		key.Name = name
+	}
+	if this.skipKw("using") {
+		this.next()
+	}
+	cols, err := this.parseKeyColumns()
+	if err != nil {
+		return key, err
+	}
+	key.Columns = cols
+	if primary {
+		key.Name = "PRIMARY"
+	} else if key.Name == "" && len(cols) > 0 {
+		key.Name = cols[0]
+	}
+	return key, nil
+}
+
+// parseColumnDef parses: name type[(len)] [UNSIGNED] [attributes...], stops at "," or ")" or FIRST/AFTER
+func (this *ddlParser) parseColumnDef() (ColumnDef, error) {
+	var col ColumnDef
+	name, err := this.parseIdent()
+	if err != nil {
+		return col, err
+	}
+	col.Name = name
+	tp, err := this.parseIdent()
+	if err != nil {
+		return col, err
+	}
+	col.Type = strings.ToLower(tp)
+	if col.Type == "double" && this.isKw("precision") {
+		this.next()
+	}
+	for !this.end() {
+		if this.isPunct(",") || this.isPunct(")") || this.isKw("first", "after") {
+			break
+		}
+		if this.isPunct("(") {
+			this.skipBalanced()
+			continue
+		}
+		switch {
+		case this.isKw("unsigned"):
+			col.Unsigned = true
+		case this.isKw("primary"):
+			col.PrimaryKey = true
+		case this.isKw("unique"):
+			col.UniqueKey = true
+		}
+		this.next()
+	}
+	return col, nil
+}
+
+func (this *ddlParser) parseCreateTable(info *SqlInfo) error {
+	this.skipIfNotExists()
+	tb, err := this.parseTableName()
+	if err != nil {
+		return err
+	}
+	info.Tables = []DbTable{tb}
+
+	if this.isKw("like") || (this.isPunct("(") && this.pos+1 < len(this.tokens) &&
+		strings.EqualFold(this.tokens[this.pos+1].val, "like") && !this.tokens[this.pos+1].quoted) {
+		this.skipKw("like")
+		if this.isPunct("(") {
+			this.next()
+			this.next()
+		}
+		info.LikeTable, err = this.parseTableName()
+		return err
+	}
+
+	info.AsSelect = this.restHasKw("select")
+	if !this.isPunct("(") {
+		if info.AsSelect {
+			// CREATE TABLE ... SELECT without column definitions
+			return nil
+		}
+		return fmt.Errorf("column definitions not found")
+	}
+	this.next()
+	if this.isKw("select") {
+		// CREATE TABLE ... (SELECT ...)
+		return nil
+	}
+	for !this.end() {
+		switch {
+		case this.isKw("constraint"):
+			this.next()
+			if !this.isKw("primary", "unique", "foreign", "check") {
+				this.next()
+			}
+			continue
+		case this.isKw("primary"):
+			this.next()
+			key, err := this.parseKeyDef(true)
+			if err != nil {
+				return err
+			}
+			info.Keys = append(info.Keys, key)
+		case this.isKw("unique"):
+			this.next()
+			key, err := this.parseKeyDef(false)
+			if err != nil {
+				return err
+			}
+			info.Keys = append(info.Keys, key)
+		case this.isKw("key", "index", "fulltext", "spatial", "foreign", "check"):
+			this.skipToSeparator()
+		default:
+			col, err := this.parseColumnDef()
+			if err != nil {
+				return err
+			}
+			info.Columns = append(info.Columns, col)
+		}
+		this.skipToSeparator()
+		if this.isPunct(")") {
+			return nil
+		}
+		this.next()
+	}
+	return fmt.Errorf("unexpected end of create table")
+}
+
+func (this *ddlParser) parseColumnPosition(spec *AlterSpec) error {
+	if this.skipKw("first") {
+		spec.First = true
+	} else if this.skipKw("after") {
+		col, err := this.parseIdent()
+		if err != nil {
+			return err
+		}
+		spec.AfterColumn = col
+	}
+	return nil
+}
+
+func (this *ddlParser) parseAlterTable(info *SqlInfo) error {
+	tb, err := this.parseTableName()
+	if err != nil {
+		return err
+	}
+	info.Tables = []DbTable{tb}
+
+	for !this.end() {
+		spec, ok, err := this.parseAlterSpec()
+		if err != nil {
+			return err
+		}
+		if ok {
+			info.AlterSpecs = append(info.AlterSpecs, spec...)
+		}
+		this.skipToSeparator()
+		if this.end() || this.isPunct(")") {
+			break
+		}
+		this.next()
+	}
+	return nil
+}
+
+// parseAlterSpec parses one alter specification, unrelated specification is ignored and false is returned
+func (this *ddlParser) parseAlterSpec() ([]AlterSpec, bool, error) {
+	var spec AlterSpec
+	var err error
+	switch {
+	case this.isKw("add"):
+		this.next()
+		if this.skipKw("constraint") && !this.isKw("primary", "unique", "foreign", "check") {
+			// constraint symbol
+			this.next()
+		}
+		if this.isKw("primary") {
+			this.next()
+			spec.Action = ALTER_ADD_KEY
+			spec.Key, err = this.parseKeyDef(true)
+			return []AlterSpec{spec}, err == nil, err
+		}
+		if this.isKw("unique") {
+			this.next()
+			spec.Action = ALTER_ADD_KEY
+			spec.Key, err = this.parseKeyDef(false)
+			return []AlterSpec{spec}, err == nil, err
+		}
+		if this.isKw("key", "index", "fulltext", "spatial", "foreign", "check", "partition") {
+			return nil, false, nil
+		}
+		this.skipKw("column")
+		if this.isPunct("(") {
+			// ADD COLUMN (col1 def1, col2 def2)
+			this.next()
+			var specs []AlterSpec
+			for !this.end() {
+				col, err := this.parseColumnDef()
+				if err != nil {
+					return nil, false, err
+				}
+				specs = append(specs, AlterSpec{Action: ALTER_ADD_COLUMN, Column: col})
+				this.skipToSeparator()
+				if this.isPunct(")") {
+					this.next()
+					break
+				}
+				this.next()
+			}
+			return specs, true, nil
+		}
+		spec.Action = ALTER_ADD_COLUMN
+		spec.Column, err = this.parseColumnDef()
+		if err == nil {
+			err = this.parseColumnPosition(&spec)
+		}
+		return []AlterSpec{spec}, err == nil, err
+	case this.isKw("drop"):
+		this.next()
+		switch {
+		case this.isKw("primary"):
+			this.next()
+			this.skipKw("key")
+			spec.Action = ALTER_DROP_PRIMARY_KEY
+			return []AlterSpec{spec}, true, nil
+		case this.isKw("index", "key"):
+			this.next()
+			spec.Action = ALTER_DROP_INDEX
+			spec.OldName, err = this.parseIdent()
+			return []AlterSpec{spec}, err == nil, err
+		case this.isKw("foreign", "check", "constraint", "partition"):
+			return nil, false, nil
+		}
+		this.skipKw("column")
+		spec.Action = ALTER_DROP_COLUMN
+		spec.OldName, err = this.parseIdent()
+		return []AlterSpec{spec}, err == nil, err
+	case this.isKw("modify"):
+		this.next()
+		this.skipKw("column")
+		spec.Action = ALTER_CHANGE_COLUMN
+		spec.Column, err = this.parseColumnDef()
+		if err == nil {
+			spec.OldName = spec.Column.Name
+			err = this.parseColumnPosition(&spec)
+		}
+		return []AlterSpec{spec}, err == nil, err
+	case this.isKw("change"):
+		this.next()
+		this.skipKw("column")
+		spec.Action = ALTER_CHANGE_COLUMN
+		spec.OldName, err = this.parseIdent()
+		if err == nil {
+			spec.Column, err = this.parseColumnDef()
+		}
+		if err == nil {
+			err = this.parseColumnPosition(&spec)
+		}
+		return []AlterSpec{spec}, err == nil, err
+	case this.isKw("rename"):
+		this.next()
+		if this.isKw("column", "index", "key") {
+			if this.skipKw("column") {
+				spec.Action = ALTER_RENAME_COLUMN
+			} else {
+				this.next()
+				spec.Action = ALTER_RENAME_INDEX
+			}
+			spec.OldName, err = this.parseIdent()
+			if err != nil {
+				return nil, false, err
+			}
+			this.skipKw("to")
+			spec.NewName, err = this.parseIdent()
+			return []AlterSpec{spec}, err == nil, err
+		}
+		this.skipKw("to", "as")
+		spec.Action = ALTER_RENAME_TABLE
+		spec.NewTable, err = this.parseTableName()
+		return []AlterSpec{spec}, err == nil, err
+	}
+	return nil, false, nil
+}
+
+func (this *ddlParser) parseDropTable(info *SqlInfo) error {
+	if this.isKw("if") {
+		this.next()
+		this.skipKw("exists")
+	}
+	for !this.end() {
+		tb, err := this.parseTableName()
+		if err != nil {
+			return err
+		}
+		info.Tables = append(info.Tables, tb)
+		if !this.isPunct(",") {
+			break
+		}
+		this.next()
+	}
+	return nil
+}
+
+func (this *ddlParser) parseRenameTable(info *SqlInfo) error {
+	for !this.end() {
+		from, err := this.parseTableName()
+		if err != nil {
+			return err
+		}
+		if !this.skipKw("to") {
+			return fmt.Errorf("TO expected in rename table")
+		}
+		to, err := this.parseTableName()
+		if err != nil {
+			return err
+		}
+		info.Tables = append(info.Tables, from, to)
+		if !this.isPunct(",") {
+			break
+		}
+		this.next()
+	}
+	return nil
+}
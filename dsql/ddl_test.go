@@ -0,0 +1,166 @@
+package dsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDdlSql(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected *SqlInfo
+	}{
+		{"not ddl", "insert into t values(1)", nil},
+		{"create index", "create index idx_a on t(a)", nil},
+		{
+			"create table",
+			"CREATE TABLE IF NOT EXISTS `db1`.`t1` (\n" +
+				"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(32) DEFAULT 'a,b' COMMENT 'name (x)',\n" +
+				"  `price` decimal(10,2),\n" +
+				"  code char(8) UNIQUE KEY,\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `uk_name` (`name`(10), `code` DESC),\n" +
+				"  KEY `idx_price` (`price`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db1", "t1"}},
+				Columns: []ColumnDef{{Name: "id", Type: "int", Unsigned: true}, {Name: "name", Type: "varchar"},
+					{Name: "price", Type: "decimal"}, {Name: "code", Type: "char", UniqueKey: true}},
+				Keys: []KeyDef{{Name: "PRIMARY", Columns: []string{"id"}, Primary: true},
+					{Name: "uk_name", Columns: []string{"name", "code"}}}},
+		},
+		{
+			"create table with column primary key and comments",
+			"create /* c1 */ table t2 (id bigint primary key, -- c2\n v double precision unsigned) # c3",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t2"}},
+				Columns: []ColumnDef{{Name: "id", Type: "bigint", PrimaryKey: true}, {Name: "v", Type: "double", Unsigned: true}}},
+		},
+		{
+			"create table like",
+			"create table t3 like db1.t1",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t3"}}, LikeTable: DbTable{"db1", "t1"}},
+		},
+		{
+			"create table (like)",
+			"create table if not exists db2.t3 (like t1)",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db2", "t3"}}, LikeTable: DbTable{"db0", "t1"}},
+		},
+		{
+			"create table select",
+			"create table t4 as select * from t1",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t4"}}, AsSelect: true},
+		},
+		{
+			"create table (select)",
+			"create table t4 (select id from t1)",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t4"}}, AsSelect: true},
+		},
+		{
+			"create table with columns select",
+			"create table t4 (id int primary key) select id, name from t1",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t4"}}, AsSelect: true,
+				Columns: []ColumnDef{{Name: "id", Type: "int", PrimaryKey: true}}},
+		},
+		{
+			"create table with quoted select",
+			"create table t5 (`select` int comment 'select')",
+			&SqlInfo{SqlType: SQL_TYPE_CREATE_TABLE, Tables: []DbTable{{"db0", "t5"}},
+				Columns: []ColumnDef{{Name: "select", Type: "int"}}},
+		},
+		{
+			"alter add column",
+			"alter table t1 add column c1 int unsigned not null default 0 after id, add c2 varchar(10) first",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db0", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_ADD_COLUMN, Column: ColumnDef{Name: "c1", Type: "int", Unsigned: true}, AfterColumn: "id"},
+				{Action: ALTER_ADD_COLUMN, Column: ColumnDef{Name: "c2", Type: "varchar"}, First: true}}},
+		},
+		{
+			"alter add multiple columns",
+			"alter table t1 add (c1 int, c2 text)",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db0", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_ADD_COLUMN, Column: ColumnDef{Name: "c1", Type: "int"}},
+				{Action: ALTER_ADD_COLUMN, Column: ColumnDef{Name: "c2", Type: "text"}}}},
+		},
+		{
+			"alter drop",
+			"alter table db1.t1 drop column c1, drop c2, drop primary key, drop index uk_name, drop foreign key fk1",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db1", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_DROP_COLUMN, OldName: "c1"}, {Action: ALTER_DROP_COLUMN, OldName: "c2"},
+				{Action: ALTER_DROP_PRIMARY_KEY}, {Action: ALTER_DROP_INDEX, OldName: "uk_name"}}},
+		},
+		{
+			"alter modify and change",
+			"alter table t1 modify c1 bigint unsigned first, change column `c2` `c3` char(4) after c1",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db0", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_CHANGE_COLUMN, Column: ColumnDef{Name: "c1", Type: "bigint", Unsigned: true}, OldName: "c1", First: true},
+				{Action: ALTER_CHANGE_COLUMN, Column: ColumnDef{Name: "c3", Type: "char"}, OldName: "c2", AfterColumn: "c1"}}},
+		},
+		{
+			"alter rename",
+			"alter table t1 rename column c1 to c2, rename index i1 to i2, rename to db2.t2",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db0", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_RENAME_COLUMN, OldName: "c1", NewName: "c2"},
+				{Action: ALTER_RENAME_INDEX, OldName: "i1", NewName: "i2"},
+				{Action: ALTER_RENAME_TABLE, NewTable: DbTable{"db2", "t2"}}}},
+		},
+		{
+			"alter add keys",
+			"alter table t1 add constraint pk primary key (id), add unique key uk1 (c1, c2), add index i3 (c3), engine=innodb",
+			&SqlInfo{SqlType: SQL_TYPE_ALTER_TABLE, Tables: []DbTable{{"db0", "t1"}}, AlterSpecs: []AlterSpec{
+				{Action: ALTER_ADD_KEY, Key: KeyDef{Name: "PRIMARY", Columns: []string{"id"}, Primary: true}},
+				{Action: ALTER_ADD_KEY, Key: KeyDef{Name: "uk1", Columns: []string{"c1", "c2"}}}}},
+		},
+		{
+			"rename table",
+			"rename table t1 to t1_old, db1.t2 to db2.t2",
+			&SqlInfo{SqlType: SQL_TYPE_RENAME_TABLE, Tables: []DbTable{{"db0", "t1"}, {"db0", "t1_old"}, {"db1", "t2"}, {"db2", "t2"}}},
+		},
+		{
+			"drop multiple tables",
+			"drop table if exists t1, `db1`.`t2`, t3 cascade",
+			&SqlInfo{SqlType: SQL_TYPE_DROP_TABLE, Tables: []DbTable{{"db0", "t1"}, {"db1", "t2"}, {"db0", "t3"}}},
+		},
+		{
+			"drop temporary table",
+			"drop temporary table t1",
+			&SqlInfo{SqlType: SQL_TYPE_DROP_TABLE, Tables: []DbTable{{"db0", "t1"}}},
+		},
+	}
+	for _, c := range cases {
+		info, err := ParseDdlSql(c.sql, "db0")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if c.expected == nil {
+			if info != nil {
+				t.Errorf("%s: expected nil, but got %+v", c.name, info)
+			}
+			continue
+		}
+		if info == nil {
+			t.Errorf("%s: expected %+v, but got nil", c.name, c.expected)
+			continue
+		}
+		c.expected.UseDatabase = "db0"
+		c.expected.SqlStr = c.sql
+		if !reflect.DeepEqual(info, c.expected) {
+			t.Errorf("%s:\n got      %+v\n expected %+v", c.name, info, c.expected)
+		}
+	}
+}
+
+func TestParseDdlSqlError(t *testing.T) {
+	cases := []string{
+		"create table t1",
+		"create table t1 (id int",
+		"rename table t1 t2",
+		"alter table t1 add primary key id",
+	}
+	for _, sql := range cases {
+		if _, err := ParseDdlSql(sql, "db0"); err == nil {
+			t.Errorf("error expected for %s", sql)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseDmlSql parses INSERT/REPLACE/UPDATE/DELETE in query event of statement or mixed format binlog,
+// just as much as needed to find the table. nil is returned if the sql is not one of them
+func ParseDmlSql(sqlStr string, useDb string) (*SqlInfo, error) {
+	p := &ddlParser{tokens: splitSqlTokens(sqlStr), useDb: useDb}
+	info := &SqlInfo{UseDatabase: useDb, SqlStr: sqlStr, SqlType: SQL_TYPE_UNKNOWN}
+	var (
+		err  error
+		dbTb DbTable
+	)
+
+	switch {
+	case p.isKw("insert", "replace"):
+		p.next()
+		for p.skipKw("low_priority", "delayed", "high_priority", "ignore") {
+		}
+		p.skipKw("into")
+		info.SqlType = SQL_TYPE_INSERT
+	case p.isKw("update"):
+		p.next()
+		for p.skipKw("low_priority", "ignore") {
+		}
+		info.SqlType = SQL_TYPE_UPDATE
+	case p.isKw("delete"):
+		p.next()
+		for p.skipKw("low_priority", "quick", "ignore") {
+		}
+		p.skipKw("from")
+		info.SqlType = SQL_TYPE_DELETE
+	default:
+		return nil, nil
+	}
+	if p.isPunct("(") {
+		// UPDATE (SELECT ...) AS t JOIN ...
+		return nil, fmt.Errorf("fail to parse dml %s: table name expected, but got (", sqlStr)
+	}
+	tbToken := p.pos
+	dbTb, err = p.parseTableName()
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse dml %s: %v", sqlStr, err)
+	}
+	info.Tables = []DbTable{dbTb}
+	info.QualifiedSql = p.qualifyDmlTable(sqlStr, tbToken, dbTb)
+	return info, nil
+}
+
+// qualifyDmlTable returns the dml with the database added to the target table, so that it is executed
+// without USE db. empty string is returned if other tables may be referenced, ex: subquery, join, multiple-table
+// UPDATE/DELETE, INSERT ... SELECT, the current token must be the one after the target table
+func (this *ddlParser) qualifyDmlTable(sqlStr string, tbToken int, dbTb DbTable) string {
+	if this.isPunct(",") || this.restHasKw("select", "join", "from", "using", "table") {
+		return ""
+	}
+	if this.pos-tbToken > 1 {
+		// db.tb
+		return sqlStr
+	}
+	if dbTb.Database == "" {
+		return ""
+	}
+	s := []rune(sqlStr)
+	start := this.tokens[tbToken].start
+	return fmt.Sprintf("%s`%s`.%s", string(s[:start]), strings.Replace(dbTb.Database, "`", "``", -1), string(s[start:]))
+}
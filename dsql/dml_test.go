@@ -0,0 +1,44 @@
+package dsql
+
+import (
+	"testing"
+)
+
+func TestParseDmlSqlQualifiedSql(t *testing.T) {
+	cases := []struct {
+		sql       string
+		table     DbTable
+		qualified string
+	}{
+		{"insert into t1 values(1, 'select')", DbTable{"db0", "t1"}, "insert into `db0`.t1 values(1, 'select')"},
+		{"INSERT IGNORE INTO `t1` (a) VALUES (now())", DbTable{"db0", "t1"}, "INSERT IGNORE INTO `db0`.`t1` (a) VALUES (now())"},
+		{"update t1 set a=a+1 where id=3", DbTable{"db0", "t1"}, "update `db0`.t1 set a=a+1 where id=3"},
+		{"delete from t1 where id in (1, 2)", DbTable{"db0", "t1"}, "delete from `db0`.t1 where id in (1, 2)"},
+		{"update db1.t1 set a=1", DbTable{"db1", "t1"}, "update db1.t1 set a=1"},
+		{"replace /* c */ into 表1 values('中文')", DbTable{"db0", "表1"}, "replace /* c */ into `db0`.表1 values('中文')"},
+		{"insert into t1 select * from t2", DbTable{"db0", "t1"}, ""},
+		{"update t1 join t2 on t1.id=t2.id set t1.a=t2.a", DbTable{"db0", "t1"}, ""},
+		{"update t1, t2 set t1.a=t2.a", DbTable{"db0", "t1"}, ""},
+		{"delete t1 from t1 join t2 using(id)", DbTable{"db0", "t1"}, ""},
+		{"delete from t1 where id in (select id from t2)", DbTable{"db0", "t1"}, ""},
+		{"update db1.t1 set a=(select max(a) from t2)", DbTable{"db1", "t1"}, ""},
+	}
+	for _, c := range cases {
+		info, err := ParseDmlSql(c.sql, "db0")
+		if err != nil {
+			t.Errorf("%s: %v", c.sql, err)
+			continue
+		}
+		if info.Tables[0] != c.table {
+			t.Errorf("%s: table is %v, expected %v", c.sql, info.Tables[0], c.table)
+		}
+		if info.QualifiedSql != c.qualified {
+			t.Errorf("%s: qualified sql is %q, expected %q", c.sql, info.QualifiedSql, c.qualified)
+		}
+	}
+
+	info, err := ParseDmlSql("insert into t1 values(1)", "")
+	if err != nil || info.QualifiedSql != "" {
+		t.Errorf("no database: qualified sql is %q, error %v", info.QualifiedSql, err)
+	}
+}
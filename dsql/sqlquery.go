@@ -1,7 +1,27 @@
 package dsql
 
+const (
+	SQL_TYPE_UNKNOWN = iota
+	SQL_TYPE_CREATE_TABLE
+	SQL_TYPE_ALTER_TABLE
+	SQL_TYPE_DROP_TABLE
+	SQL_TYPE_RENAME_TABLE
+	SQL_TYPE_INSERT // INSERT and REPLACE, statement based dml
+	SQL_TYPE_UPDATE
+	SQL_TYPE_DELETE
+)
 
-
+const (
+	ALTER_ADD_COLUMN = iota
+	ALTER_DROP_COLUMN
+	ALTER_CHANGE_COLUMN // MODIFY and CHANGE
+	ALTER_RENAME_COLUMN
+	ALTER_ADD_KEY
+	ALTER_DROP_PRIMARY_KEY
+	ALTER_DROP_INDEX
+	ALTER_RENAME_INDEX
+	ALTER_RENAME_TABLE
+)
 
 type DbTable struct {
 	Database string
@@ -15,10 +35,51 @@ func (this DbTable) Copy() DbTable {
 	}
 }
 
+type ColumnDef struct {
+	Name       string
+	Type       string // lower case type name without length, ex: int, varchar
+	Unsigned   bool
+	PrimaryKey bool // PRIMARY KEY in column definition
+	UniqueKey  bool // UNIQUE [KEY] in column definition
+}
+
+// only primary key and unique keys are kept, they are used to build where condition
+type KeyDef struct {
+	Name    string
+	Columns []string
+	Primary bool
+}
+
+type AlterSpec struct {
+	Action      int
+	Column      ColumnDef // ADD COLUMN, MODIFY, CHANGE
+	OldName     string    // CHANGE, DROP COLUMN, RENAME COLUMN, DROP INDEX, RENAME INDEX
+	NewName     string    // RENAME COLUMN, RENAME INDEX
+	First       bool      // FIRST
+	AfterColumn string    // AFTER col
+	Key         KeyDef    // ADD PRIMARY KEY, ADD UNIQUE
+	NewTable    DbTable   // RENAME TO
+}
+
 type SqlInfo struct {
+	// CREATE/ALTER TABLE: the table
+	// DROP TABLE: all tables dropped
+	// RENAME TABLE: pairs of tables, {from1, to1, from2, to2...}
+	// INSERT/UPDATE/DELETE: the first table, it is the target table except for multiple-table UPDATE/DELETE
 	Tables      []DbTable
 	UseDatabase string
 	SqlStr      string
 	SqlType     int
+
+	Columns    []ColumnDef // CREATE TABLE
+	Keys       []KeyDef    // CREATE TABLE
+	LikeTable  DbTable     // CREATE TABLE ... LIKE
+	AsSelect   bool        // CREATE TABLE ... SELECT, the columns of the select are unknown
+	AlterSpecs []AlterSpec // ALTER TABLE
+
+	QualifiedSql string // INSERT/UPDATE/DELETE with the database of the target table, empty if it cannot be qualified
 }
 
+func (this *SqlInfo) IsDml() bool {
+	return this.SqlType == SQL_TYPE_INSERT || this.SqlType == SQL_TYPE_UPDATE || this.SqlType == SQL_TYPE_DELETE
+}
@@ -0,0 +1,399 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	WHERE_EXPR_AND = iota
+	WHERE_EXPR_OR
+	WHERE_EXPR_NOT
+	WHERE_EXPR_COMPARE // Left Op Right
+	WHERE_EXPR_IN      // Left [NOT] IN (List)
+	WHERE_EXPR_IS_NULL // Left IS [NOT] NULL
+	WHERE_EXPR_CHANGED // changed(Left)
+)
+
+const (
+	WHERE_IMAGE_ROW = iota // after image of insert and update, before image of delete
+	WHERE_IMAGE_OLD        // old.col, before image
+	WHERE_IMAGE_NEW        // new.col, after image
+)
+
+const (
+	whereTokenIdent = iota
+	whereTokenQuotedIdent
+	whereTokenString
+	whereTokenNumber
+	whereTokenPunct
+)
+
+// WhereOperand is a column or a literal. literal number is kept as string
+type WhereOperand struct {
+	IsColumn bool
+	Column   string
+	Image    int
+	IsNull   bool
+	IsNumber bool
+	Value    string
+}
+
+type WhereExpr struct {
+	Type     int
+	Op       string // =, !=, <, <=, >, >=, <=>
+	Not      bool   // NOT IN, IS NOT NULL
+	Children []*WhereExpr
+	Left     WhereOperand
+	Right    WhereOperand
+	List     []WhereOperand
+}
+
+type whereToken struct {
+	kind int
+	val  string
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+// ParseWhereExpr parses the expression of -where, it supports =, !=, <>, <, <=, >, >=, <=>,
+// [NOT] IN (...), IS [NOT] NULL, AND, OR, NOT and changed(col).
+// column may be prefixed with old. or new. to refer to the before or after image
+func ParseWhereExpr(exprStr string) (*WhereExpr, error) {
+	tokens, err := splitWhereTokens(exprStr)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse %s: %v", exprStr, err)
+	}
+	p := &whereParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err == nil && !p.end() {
+		err = fmt.Errorf("unexpected %s", p.peek().val)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse %s: %v", exprStr, err)
+	}
+	return expr, nil
+}
+
+func splitWhereTokens(exprStr string) ([]whereToken, error) {
+	var tokens []whereToken
+	s := []rune(exprStr)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '`' || c == '\'' || c == '"':
+			var buf []rune
+			closed := false
+			i++
+			for i < len(s) {
+				if s[i] == '\\' && c != '`' && i+1 < len(s) {
+					buf = append(buf, unescapeWhereRune(s[i+1]))
+					i += 2
+					continue
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						buf = append(buf, c)
+						i += 2
+						continue
+					}
+					closed = true
+					break
+				}
+				buf = append(buf, s[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unclosed quote %c", c)
+			}
+			i++
+			if c == '`' {
+				tokens = append(tokens, whereToken{kind: whereTokenQuotedIdent, val: string(buf)})
+			} else {
+				tokens = append(tokens, whereToken{kind: whereTokenString, val: string(buf)})
+			}
+		case (c >= '0' && c <= '9') || (c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			j := i
+			for j < len(s) && ((s[j] >= '0' && s[j] <= '9') || s[j] == '.') {
+				j++
+			}
+			if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+				j++
+				if j < len(s) && (s[j] == '+' || s[j] == '-') {
+					j++
+				}
+				for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+					j++
+				}
+			}
+			if j < len(s) && isIdentRune(s[j]) {
+				// identifier starts with digits, ex: 1col
+				for j < len(s) && isIdentRune(s[j]) {
+					j++
+				}
+				tokens = append(tokens, whereToken{kind: whereTokenIdent, val: string(s[i:j])})
+			} else {
+				tokens = append(tokens, whereToken{kind: whereTokenNumber, val: string(s[i:j])})
+			}
+			i = j
+		case isIdentRune(c):
+			j := i
+			for j < len(s) && isIdentRune(s[j]) {
+				j++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenIdent, val: string(s[i:j])})
+			i = j
+		default:
+			punct := string(c)
+			if i+2 < len(s) && string(s[i:i+3]) == "<=>" {
+				punct = "<=>"
+			} else if i+1 < len(s) {
+				switch string(s[i : i+2]) {
+				case "<=", ">=", "<>", "!=", "&&", "||":
+					punct = string(s[i : i+2])
+				}
+			}
+			if !strings.Contains("=<>!&|(),.-", string(c)) || punct == "&" || punct == "|" {
+				return nil, fmt.Errorf("unexpected character %s", punct)
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenPunct, val: punct})
+			i += len([]rune(punct))
+		}
+	}
+	return tokens, nil
+}
+
+func unescapeWhereRune(c rune) rune {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+func (this *whereParser) end() bool {
+	return this.pos >= len(this.tokens)
+}
+
+func (this *whereParser) peek() whereToken {
+	if this.end() {
+		return whereToken{kind: whereTokenPunct}
+	}
+	return this.tokens[this.pos]
+}
+
+func (this *whereParser) next() whereToken {
+	t := this.peek()
+	this.pos++
+	return t
+}
+
+func (this *whereParser) isKw(kw string) bool {
+	t := this.peek()
+	return t.kind == whereTokenIdent && strings.EqualFold(t.val, kw)
+}
+
+func (this *whereParser) isPunct(punct ...string) bool {
+	t := this.peek()
+	if t.kind != whereTokenPunct {
+		return false
+	}
+	for _, p := range punct {
+		if t.val == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *whereParser) expectPunct(punct string) error {
+	if !this.isPunct(punct) {
+		return fmt.Errorf("%s expected, but got %s", punct, this.gotStr())
+	}
+	this.next()
+	return nil
+}
+
+func (this *whereParser) gotStr() string {
+	if this.end() {
+		return "the end"
+	}
+	return this.peek().val
+}
+
+func (this *whereParser) parseOr() (*WhereExpr, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for this.isKw("or") || this.isPunct("||") {
+		this.next()
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &WhereExpr{Type: WHERE_EXPR_OR, Children: []*WhereExpr{left, right}}
+	}
+	return left, nil
+}
+
+func (this *whereParser) parseAnd() (*WhereExpr, error) {
+	left, err := this.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for this.isKw("and") || this.isPunct("&&") {
+		this.next()
+		right, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &WhereExpr{Type: WHERE_EXPR_AND, Children: []*WhereExpr{left, right}}
+	}
+	return left, nil
+}
+
+func (this *whereParser) parseNot() (*WhereExpr, error) {
+	if this.isKw("not") || this.isPunct("!") {
+		this.next()
+		child, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &WhereExpr{Type: WHERE_EXPR_NOT, Children: []*WhereExpr{child}}, nil
+	}
+	return this.parsePrimary()
+}
+
+func (this *whereParser) parsePrimary() (*WhereExpr, error) {
+	if this.isPunct("(") {
+		this.next()
+		expr, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, this.expectPunct(")")
+	}
+	if this.isKw("changed") && this.pos+1 < len(this.tokens) && this.tokens[this.pos+1].kind == whereTokenPunct && this.tokens[this.pos+1].val == "(" {
+		this.pos += 2
+		col, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !col.IsColumn || col.Image != WHERE_IMAGE_ROW {
+			return nil, fmt.Errorf("changed() only accepts column name without old. or new.")
+		}
+		return &WhereExpr{Type: WHERE_EXPR_CHANGED, Left: col}, this.expectPunct(")")
+	}
+	return this.parsePredicate()
+}
+
+func (this *whereParser) parsePredicate() (*WhereExpr, error) {
+	left, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	expr := &WhereExpr{Left: left}
+	switch {
+	case this.isPunct("=", "!=", "<>", "<", "<=", ">", ">=", "<=>"):
+		expr.Type = WHERE_EXPR_COMPARE
+		expr.Op = this.next().val
+		if expr.Op == "<>" {
+			expr.Op = "!="
+		}
+		expr.Right, err = this.parseOperand()
+		return expr, err
+	case this.isKw("is"):
+		this.next()
+		expr.Type = WHERE_EXPR_IS_NULL
+		if this.isKw("not") {
+			this.next()
+			expr.Not = true
+		}
+		if !this.isKw("null") {
+			return nil, fmt.Errorf("NULL expected after IS, but got %s", this.gotStr())
+		}
+		this.next()
+		return expr, nil
+	case this.isKw("not") || this.isKw("in"):
+		if this.isKw("not") {
+			this.next()
+			expr.Not = true
+			if !this.isKw("in") {
+				return nil, fmt.Errorf("IN expected after NOT, but got %s", this.gotStr())
+			}
+		}
+		this.next()
+		expr.Type = WHERE_EXPR_IN
+		if err = this.expectPunct("("); err != nil {
+			return nil, err
+		}
+		for {
+			oneVal, err := this.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			expr.List = append(expr.List, oneVal)
+			if !this.isPunct(",") {
+				break
+			}
+			this.next()
+		}
+		return expr, this.expectPunct(")")
+	}
+	return nil, fmt.Errorf("comparison, IN or IS NULL expected, but got %s", this.gotStr())
+}
+
+// parseOperand parses column, old.column, new.column, string, number and NULL
+func (this *whereParser) parseOperand() (WhereOperand, error) {
+	t := this.next()
+	switch t.kind {
+	case whereTokenString:
+		return WhereOperand{Value: t.val}, nil
+	case whereTokenNumber:
+		return WhereOperand{Value: t.val, IsNumber: true}, nil
+	case whereTokenPunct:
+		if t.val == "-" && this.peek().kind == whereTokenNumber {
+			return WhereOperand{Value: "-" + this.next().val, IsNumber: true}, nil
+		}
+		if t.val == "" {
+			return WhereOperand{}, fmt.Errorf("column or value expected at the end")
+		}
+		return WhereOperand{}, fmt.Errorf("column or value expected, but got %s", t.val)
+	case whereTokenIdent:
+		if strings.EqualFold(t.val, "null") {
+			return WhereOperand{IsNull: true}, nil
+		}
+		if strings.EqualFold(t.val, "true") {
+			return WhereOperand{Value: "1", IsNumber: true}, nil
+		}
+		if strings.EqualFold(t.val, "false") {
+			return WhereOperand{Value: "0", IsNumber: true}, nil
+		}
+	}
+	col := WhereOperand{IsColumn: true, Column: t.val, Image: WHERE_IMAGE_ROW}
+	if t.kind == whereTokenIdent && this.isPunct(".") && (strings.EqualFold(t.val, "old") || strings.EqualFold(t.val, "new")) {
+		this.next()
+		nt := this.next()
+		if nt.kind != whereTokenIdent && nt.kind != whereTokenQuotedIdent {
+			return WhereOperand{}, fmt.Errorf("column expected after %s.", t.val)
+		}
+		col.Column = nt.val
+		col.Image = WHERE_IMAGE_NEW
+		if strings.EqualFold(t.val, "old") {
+			col.Image = WHERE_IMAGE_OLD
+		}
+	}
+	return col, nil
+}
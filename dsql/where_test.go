@@ -0,0 +1,119 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// whereExprStr renders the expression as s-expression to compare the parse tree
+func whereExprStr(expr *WhereExpr) string {
+	var children []string
+	for _, child := range expr.Children {
+		children = append(children, whereExprStr(child))
+	}
+	switch expr.Type {
+	case WHERE_EXPR_AND:
+		return "(and " + strings.Join(children, " ") + ")"
+	case WHERE_EXPR_OR:
+		return "(or " + strings.Join(children, " ") + ")"
+	case WHERE_EXPR_NOT:
+		return "(not " + strings.Join(children, " ") + ")"
+	case WHERE_EXPR_COMPARE:
+		return fmt.Sprintf("(%s %s %s)", expr.Op, whereOperandStr(expr.Left), whereOperandStr(expr.Right))
+	case WHERE_EXPR_IN:
+		var list []string
+		for _, oneVal := range expr.List {
+			list = append(list, whereOperandStr(oneVal))
+		}
+		if expr.Not {
+			return fmt.Sprintf("(not-in %s %s)", whereOperandStr(expr.Left), strings.Join(list, " "))
+		}
+		return fmt.Sprintf("(in %s %s)", whereOperandStr(expr.Left), strings.Join(list, " "))
+	case WHERE_EXPR_IS_NULL:
+		if expr.Not {
+			return fmt.Sprintf("(is-not-null %s)", whereOperandStr(expr.Left))
+		}
+		return fmt.Sprintf("(is-null %s)", whereOperandStr(expr.Left))
+	case WHERE_EXPR_CHANGED:
+		return fmt.Sprintf("(changed %s)", whereOperandStr(expr.Left))
+	}
+	return "?"
+}
+
+// whereOperandStr renders column as col, old:col or new:col, string as 'str', number as is
+func whereOperandStr(operand WhereOperand) string {
+	switch {
+	case operand.IsColumn && operand.Image == WHERE_IMAGE_OLD:
+		return "old:" + operand.Column
+	case operand.IsColumn && operand.Image == WHERE_IMAGE_NEW:
+		return "new:" + operand.Column
+	case operand.IsColumn:
+		return operand.Column
+	case operand.IsNull:
+		return "NULL"
+	case operand.IsNumber:
+		return operand.Value
+	}
+	return "'" + operand.Value + "'"
+}
+
+func TestParseWhereExpr(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{"id = 1", "(= id 1)"},
+		{"id<>1 and id != 2", "(and (!= id 1) (!= id 2))"},
+		{"a<1 || b<=2 && c>3", "(or (< a 1) (and (<= b 2) (> c 3)))"},
+		{"(a>=1 or b<=>NULL) and not c=2", "(and (or (>= a 1) (<=> b NULL)) (not (= c 2)))"},
+		{"!(a=1)", "(not (= a 1))"},
+		{"a in (1, 'x', null)", "(in a 1 'x' NULL)"},
+		{"a NOT IN (-1.5, 2e3)", "(not-in a -1.5 2e3)"},
+		{"a is null or b IS NOT NULL", "(or (is-null a) (is-not-null b))"},
+		{"old.status = 1 and new.status = 2", "(and (= old:status 1) (= new:status 2))"},
+		{"OLD.`order` != new.`order`", "(!= old:order new:order)"},
+		{"changed(price) and not changed(`name`)", "(and (changed price) (not (changed name)))"},
+		{"flag = true or flag = FALSE", "(or (= flag 1) (= flag 0))"},
+		{`name = 'it''s' or name = "a\"b" or name = 'c\nd'`, "(or (or (= name 'it's') (= name 'a\"b')) (= name 'c\nd'))"},
+		{"1col = .5", "(= 1col .5)"},
+		{"`a b` = '中文'", "(= a b '中文')"},
+		{"changed = 1", "(= changed 1)"},
+	}
+	for _, c := range cases {
+		expr, err := ParseWhereExpr(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got := whereExprStr(expr); got != c.expected {
+			t.Errorf("%s:\n got      %s\n expected %s", c.expr, got, c.expected)
+		}
+	}
+}
+
+func TestParseWhereExprError(t *testing.T) {
+	cases := []string{
+		"",
+		"a",
+		"a = ",
+		"a = 1 and",
+		"(a = 1",
+		"a = 1)",
+		"a = 'x",
+		"a in 1",
+		"a in (1, 2",
+		"a is 1",
+		"a not 1",
+		"a & 1",
+		"a ~ 1",
+		"changed(old.a)",
+		"changed(1)",
+		"old. = 1",
+	}
+	for _, exprStr := range cases {
+		if expr, err := ParseWhereExpr(exprStr); err == nil {
+			t.Errorf("error expected for %q, but got %s", exprStr, whereExprStr(expr))
+		}
+	}
+}
@@ -6,12 +6,14 @@ require (
 	github.com/dropbox/godropbox v0.0.0-20200228041828-52ad444d3502
 	github.com/go-mysql-org/go-mysql v0.0.0-00010101000000-000000000000
 	github.com/go-sql-driver/mysql v1.5.1-0.20200531100419-12508c83901b
+	github.com/google/uuid v1.3.0
 	github.com/juju/errors v0.0.0-20220203013757-bd733f3c86b9
+	github.com/klauspost/compress v1.15.15
 	github.com/siddontang/go-log v0.0.0-20190221022429-1e957dd83bed
+	github.com/ulikunitz/xz v0.5.11
 )
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/juju/testing v1.0.2 // indirect
 	github.com/pingcap/errors v0.11.5-0.20201126102027-b0a155152ca3 // indirect
 	github.com/shopspring/decimal v1.2.1-0.20200707070546-867ed12000cf // indirect
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"sync"
 
 	my "my2sql/base"
@@ -28,18 +29,30 @@ func main() {
 	}
 	if my.GConfCmd.Mode == "repl" {
 		my.ParserAllBinEventsFromRepl(my.GConfCmd)
-	} else if my.GConfCmd.Mode == "file" {
+	} else if my.GConfCmd.IfLocalFileMode() {
 		myParser := my.BinFileParser{}
 		myParser.Parser = replication.NewBinlogParser()
 		// donot parse mysql datetime/time column into go time structure, take it as string
 		myParser.Parser.SetParseTime(false) 
 		// sqlbuilder not support decimal type 
 		myParser.Parser.SetUseDecimal(false) 
+		myParser.Mysql8Parser = my.NewMysql8EventParser(nil)
+		if my.GConfCmd.Mode == "relaylog" {
+			myParser.RelayLog = my.NewRelayLogState()
+		}
 		myParser.MyParseAllBinlogFiles(my.GConfCmd)
 	}
 	wgGenSql.Wait()
 	close(my.GConfCmd.SqlChan)
 	wg.Wait() 
+	if my.GMissingTblDefs.Report() {
+		// sqls are incomplete, they must not be applied
+		my.GConfCmd.CloseFH()
+		os.Exit(1)
+	}
+	if my.GConfCmd.ApplyToDsn != "" {
+		my.ApplySqlFilesToTarget(my.GConfCmd)
+	}
 }
 
 